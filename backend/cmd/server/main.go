@@ -1,59 +1,173 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"kodama-backend/internal/auth"
+	"kodama-backend/internal/config"
 	"kodama-backend/internal/database"
 	"kodama-backend/internal/handlers"
+	"kodama-backend/internal/logging"
+	"kodama-backend/internal/metrics"
 	"kodama-backend/internal/middleware"
+	"kodama-backend/internal/notify"
+	"kodama-backend/internal/ratelimit"
+	"kodama-backend/internal/repository"
+	"kodama-backend/internal/sfu"
+	"kodama-backend/internal/tracing"
 
 	"github.com/gorilla/mux"
+	"github.com/pion/webrtc/v4"
+	"github.com/redis/go-redis/v9"
 	"github.com/rs/cors"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 )
 
 func main() {
-	// Połączenie z bazą danych
-	db, err := database.Connect()
+	// Konfiguracja — wczytywana raz; błędna (np. domyślny sekret w produkcji) blokuje start
+	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Nie można połączyć z bazą danych: %v", err)
+		log.Fatal(err)
+	}
+	logging.Setup(cfg.LogFormat, cfg.LogLevel)
+	auth.Configure(cfg.JWTSecret, cfg.JWTTTL)
+	if cfg.JWTSecret == config.DefaultJWTSecret {
+		slog.Warn("Domyślny JWT_SECRET — dopuszczalny tylko w trybie deweloperskim")
+	}
+
+	// Tracing (OTLP) — przed połączeniem z bazą, żeby sterownik SQL go widział
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Enabled:     cfg.TracingEnabled,
+		ServiceName: cfg.TracingServiceName,
+		Endpoint:    cfg.TracingEndpoint,
+		SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		fatal("Nie można uruchomić tracingu", err)
+	}
+
+	// Podkomenda: kodama-server migrate up|down [N]|status
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrateCommand(cfg, os.Args[2:]))
+	}
+
+	// Połączenie z bazą danych (zamykane po wygaszeniu serwera)
+	db, err := database.Connect(cfg)
+	if err != nil {
+		fatal("Nie można połączyć z bazą danych", err)
 	}
-	defer db.Close()
 
-	// Migracje
+	// Migracje (blokada doradcza — repliki mogą startować jednocześnie)
 	if err := database.RunMigrations(db); err != nil {
-		log.Fatalf("Błąd migracji: %v", err)
+		fatal("Błąd migracji", err)
 	}
 
-	log.Println("Migracje zakończone pomyślnie")
+	slog.Info("Migracje zakończone pomyślnie")
+
+	// SFU dla kanałów głosowych w trybie "sfu"
+	var sfuICEServers []webrtc.ICEServer
+	if len(cfg.STUNURLs) > 0 {
+		sfuICEServers = append(sfuICEServers, webrtc.ICEServer{URLs: cfg.STUNURLs})
+	}
+	voiceSFU, err := sfu.New(sfu.Config{
+		ICEServers: sfuICEServers,
+		PublicIPs:  cfg.SFUPublicIPs,
+		PortMin:    cfg.SFUPortMin,
+		PortMax:    cfg.SFUPortMax,
+	})
+	if err != nil {
+		fatal("Nie można uruchomić SFU", err)
+	}
 
 	// Router
 	r := mux.NewRouter()
+	if cfg.TracingEnabled {
+		// Span per trasa; przed RequestID, żeby logi żądania miały trace_id
+		r.Use(tracing.QueryParamPropagation, otelmux.Middleware(cfg.TracingServiceName))
+	}
+	r.Use(middleware.RealIP(cfg.TrustedProxies), middleware.RequestID, middleware.RequestLogger, middleware.MaxBodySize(cfg.MaxRequestBodyBytes))
 
-	// Handlers
-	authHandler := handlers.NewAuthHandler(db)
-	serverHandler := handlers.NewServerHandler(db)
+	// Repozytoria i handlery
+	store := repository.NewPostgres(db)
+	authHandler := handlers.NewAuthHandler(cfg, store, newDeviceNotifier(cfg))
+	serverHandler := handlers.NewServerHandler(store)
 	voiceState := handlers.NewVoiceState()
-	channelHandler := handlers.NewChannelHandler(db, voiceState)
+	channelHandler := handlers.NewChannelHandler(cfg, store, voiceState)
 	signalingHub := handlers.NewSignalingHub()
-	signalingHandler := handlers.NewSignalingHandler(signalingHub, voiceState)
+	signalingHandler := handlers.NewSignalingHandler(cfg, db, store, signalingHub, voiceState, voiceSFU)
+	healthHandler := handlers.NewHealthHandler(cfg, db, signalingHub)
+
+	// Metryki Prometheus (HTTP per trasa, signaling, pula połączeń z bazą) —
+	// endpoint na osobnym listenerze, niedostępny przez publiczny port API
+	var metricsSrv *http.Server
+	if cfg.MetricsEnabled {
+		metrics.RegisterDB(db)
+		metrics.RegisterVoice(signalingHub.Stats)
+		r.Use(middleware.Metrics)
+
+		metricsLn, err := net.Listen("tcp", cfg.MetricsAddr)
+		if err != nil {
+			fatal("Nie można uruchomić serwera metryk", err)
+		}
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /metrics", metrics.Handler())
+		metricsSrv = &http.Server{Handler: metricsMux, ReadHeaderTimeout: cfg.HTTPReadTimeout}
+		go func() {
+			slog.Info("Metryki uruchomione", "addr", metricsLn.Addr().String())
+			if err := metricsSrv.Serve(metricsLn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Błąd serwera metryk", "error", err)
+			}
+		}()
+	}
+
+	// Limity żądań — przy kilku instancjach wspólne w Redis, inaczej w pamięci procesu
+	var limitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimitRedisURL != "" {
+		opts, err := redis.ParseURL(cfg.RateLimitRedisURL)
+		if err != nil {
+			fatal("Nieprawidłowy RATE_LIMIT_REDIS_URL", err)
+		}
+		rdb := redis.NewClient(opts)
+		defer rdb.Close()
+		if err := rdb.Ping(context.Background()).Err(); err != nil {
+			// Limity działają w trybie fail-open — start nie zależy od Redis
+			slog.Warn("Redis limitów niedostępny", "error", err)
+		}
+		limitStore = ratelimit.NewRedisStore(rdb, "kodama:ratelimit:")
+	}
+	rateLimit := func(group string, rl config.RateLimit) func(http.Handler) http.Handler {
+		if !cfg.RateLimitEnabled {
+			return func(next http.Handler) http.Handler { return next }
+		}
+		return middleware.RateLimit(limitStore, group, ratelimit.Rule(rl))
+	}
+	authLimit := rateLimit("auth", cfg.RateLimitAuth)
+	messagesLimit := rateLimit("messages", cfg.RateLimitMessages)
 
-	// Publiczne endpointy
-	r.HandleFunc("/api/auth/register", authHandler.Register).Methods("POST")
-	r.HandleFunc("/api/auth/login", authHandler.Login).Methods("POST")
+	// Publiczne endpointy (limit per IP — ochrona przed zgadywaniem haseł)
+	r.Handle("/api/auth/register", authLimit(http.HandlerFunc(authHandler.Register))).Methods("POST")
+	r.Handle("/api/auth/login", authLimit(http.HandlerFunc(authHandler.Login))).Methods("POST")
 
-	// Healthcheck
-	r.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"ok"}`))
-	}).Methods("GET")
+	// Sondy: liveness (proces działa) i readiness (baza, migracje, stan signaling);
+	// /api/health zostaje dla zgodności jako liveness
+	r.HandleFunc("/healthz", healthHandler.Live).Methods("GET")
+	r.HandleFunc("/readyz", healthHandler.Ready).Methods("GET")
+	r.HandleFunc("/api/health", healthHandler.Live).Methods("GET")
 
 	// Chronione endpointy
 	protected := r.PathPrefix("/api").Subrouter()
-	protected.Use(middleware.AuthMiddleware)
+	protected.Use(middleware.AuthMiddleware, rateLimit("api", cfg.RateLimitAPI))
 	protected.HandleFunc("/me", authHandler.Me).Methods("GET")
+	protected.HandleFunc("/me/logins", authHandler.LoginHistory).Methods("GET")
 
 	// Serwery
 	protected.HandleFunc("/servers", serverHandler.CreateServer).Methods("POST")
@@ -68,45 +182,153 @@ func main() {
 	// Kanały
 	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels", channelHandler.CreateChannel).Methods("POST")
 	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels", channelHandler.ListChannels).Methods("GET")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels/positions", channelHandler.UpdateChannelPositions).Methods("PATCH")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels/{channelId:[0-9]+}", channelHandler.UpdateChannel).Methods("PATCH")
 	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels/{channelId:[0-9]+}", channelHandler.DeleteChannel).Methods("DELETE")
 
 	// Wiadomości tekstowe
 	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels/{channelId:[0-9]+}/messages", channelHandler.GetMessages).Methods("GET")
-	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels/{channelId:[0-9]+}/messages", channelHandler.SendMessage).Methods("POST")
+	protected.Handle("/servers/{serverId:[0-9]+}/channels/{channelId:[0-9]+}/messages", messagesLimit(http.HandlerFunc(channelHandler.SendMessage))).Methods("POST")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/messages/search", channelHandler.SearchMessages).Methods("GET")
 
 	// Kanały głosowe (REST — stan)
 	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels/{channelId:[0-9]+}/voice/join", channelHandler.JoinVoiceChannel).Methods("POST")
 	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels/{channelId:[0-9]+}/voice/participants", channelHandler.GetVoiceParticipants).Methods("GET")
 	protected.HandleFunc("/voice/leave", channelHandler.LeaveVoiceChannel).Methods("POST")
-	protected.HandleFunc("/voice/mute", channelHandler.ToggleMute).Methods("POST")
+	protected.HandleFunc("/voice/mute", signalingHandler.ToggleMute).Methods("POST")
+	protected.HandleFunc("/voice/deafen", signalingHandler.ToggleDeafen).Methods("POST")
 	protected.HandleFunc("/voice/state", channelHandler.GetMyVoiceState).Methods("GET")
+	protected.HandleFunc("/voice/ice-servers", channelHandler.GetICEServers).Methods("GET")
+
+	// Moderacja kanałów głosowych
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/voice/members/{userId:[0-9]+}/mute", signalingHandler.ServerMuteMember).Methods("POST")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/voice/members/{userId:[0-9]+}/deafen", signalingHandler.ServerDeafenMember).Methods("POST")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/voice/members/{userId:[0-9]+}/move", signalingHandler.MoveMember).Methods("POST")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/voice/members/{userId:[0-9]+}/disconnect", signalingHandler.DisconnectMember).Methods("POST")
+
+	// Nagrywanie kanałów głosowych (tryb SFU)
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels/{channelId:[0-9]+}/voice/recording/start", signalingHandler.StartRecording).Methods("POST")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels/{channelId:[0-9]+}/voice/recording/stop", signalingHandler.StopRecording).Methods("POST")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels/{channelId:[0-9]+}/voice/recordings", signalingHandler.ListRecordings).Methods("GET")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/voice/recordings/{recordingId:[0-9]+}", signalingHandler.GetRecording).Methods("GET")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/voice/recordings/{recordingId:[0-9]+}/tracks/{trackId:[0-9]+}", signalingHandler.DownloadRecordingTrack).Methods("GET")
 
 	// WebSocket signaling (WebRTC voice) — auth przez query param ?token=
 	r.HandleFunc("/api/ws/voice/{channelId:[0-9]+}", signalingHandler.HandleWebSocket)
 
 	// CORS
-	allowedOrigins := []string{"http://localhost:5173", "http://localhost:3000"}
-	if corsOrigin := os.Getenv("CORS_ORIGIN"); corsOrigin != "" {
-		allowedOrigins = append(allowedOrigins, corsOrigin)
-	}
-
 	c := cors.New(cors.Options{
-		AllowedOrigins:   allowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowedOrigins: cfg.CORSOrigins,
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Authorization", "Content-Type", middleware.RequestIDHeader},
+		ExposedHeaders: []string{
+			middleware.RequestIDHeader,
+			"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After",
+		},
 		AllowCredentials: true,
 	})
 
 	handler := c.Handler(r)
 
 	// Uruchomienie serwera
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	srv := &http.Server{
+		Addr:         ":" + cfg.Port,
+		Handler:      handler,
+		ReadTimeout:  cfg.HTTPReadTimeout,
+		WriteTimeout: cfg.HTTPWriteTimeout,
+		IdleTimeout:  cfg.HTTPIdleTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go authHandler.PruneLoginAttempts(ctx)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Serwer uruchomiony", "port", cfg.Port, "env", cfg.Env)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		fatal("Błąd serwera", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	// Wygaszanie: /readyz odpowiada 503, po SHUTDOWN_DRAIN_DELAY nowe połączenia
+	// są odrzucane, klienci signaling dostają server-shutdown, trwające requesty
+	// mogą się dokończyć, na końcu baza
+	slog.Info("Zamykanie serwera", "timeout", cfg.ShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	signalingDone := make(chan error, 1)
+	srv.RegisterOnShutdown(func() {
+		signalingDone <- signalingHandler.Shutdown(shutdownCtx)
+	})
+	if err := drainAndShutdown(shutdownCtx, srv, healthHandler, cfg.ShutdownDrainDelay); err != nil {
+		slog.Warn("Nie wszystkie requesty zakończyły się przed limitem", "error", err)
+	}
+	if err := <-signalingDone; err != nil {
+		slog.Warn("Nie wszystkie połączenia signaling zamknęły się przed limitem", "error", err)
 	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Błąd serwera", "error", err)
+	}
+	if metricsSrv != nil {
+		metricsSrv.Shutdown(shutdownCtx)
+	}
+
+	if err := db.Close(); err != nil {
+		slog.Error("Błąd zamykania połączeń z bazą", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Warn("Nie udało się wysłać wszystkich spanów", "error", err)
+	}
+	slog.Info("Serwer zatrzymany")
+}
 
-	log.Printf("Serwer uruchomiony na porcie %s", port)
-	if err := http.ListenAndServe(":"+port, handler); err != nil {
-		log.Fatalf("Błąd serwera: %v", err)
+// drainAndShutdown — /readyz od razu zaczyna odpowiadać 503, ale przez drainDelay
+// serwer dalej przyjmuje połączenia, żeby load balancer zobaczył nieudaną sondę
+// i wycofał instancję; dopiero potem Shutdown zamyka listener i czeka na
+// trwające requesty
+func drainAndShutdown(ctx context.Context, srv *http.Server, health *handlers.HealthHandler, drainDelay time.Duration) error {
+	health.Drain()
+	if drainDelay > 0 {
+		slog.Info("Wycofywanie instancji z ruchu", "delay", drainDelay.String())
+		timer := time.NewTimer(drainDelay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
 	}
+	return srv.Shutdown(ctx)
+}
+
+// newDeviceNotifier — powiadomienia o logowaniu z nowego urządzenia: email przez
+// SMTP, a bez SMTP_HOST tylko wpis w logu
+func newDeviceNotifier(cfg *config.Config) notify.Notifier {
+	if !cfg.NewDeviceNotifications {
+		return nil
+	}
+	var mailer notify.Mailer = notify.LogMailer{}
+	if cfg.SMTPHost != "" {
+		mailer = &notify.SMTPMailer{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		}
+	}
+	return &notify.MailNotifier{Mailer: mailer}
+}
+
+// fatal — błąd uniemożliwiający start: wpis w logu i wyjście z kodem 1
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"kodama-backend/internal/config"
+	"kodama-backend/internal/handlers"
+)
+
+// TestDrainBeforeShutdown — podczas wygaszania sonda gotowości dostaje 503, zanim
+// serwer zamknie listener; po Shutdown nowe połączenia są odrzucane
+func TestDrainBeforeShutdown(t *testing.T) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// Baza, z którą nie da się połączyć — /readyz przed wygaszaniem też zwraca
+	// 503, ale bez sprawdzenia "shutdown"
+	db, err := sql.Open("postgres", "postgres://127.0.0.1:1/kodama?sslmode=disable&connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	health := handlers.NewHealthHandler(&config.Config{HealthCheckTimeout: time.Second}, db, handlers.NewSignalingHub())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/readyz", health.Ready)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+
+	// Każda sonda przez nowe połączenie — odpowiedź dowodzi, że listener przyjmuje
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: time.Second}
+	probe := func() (int, map[string]any, error) {
+		resp, err := client.Get("http://" + ln.Addr().String() + "/readyz")
+		if err != nil {
+			return 0, nil, err
+		}
+		defer resp.Body.Close()
+		var body struct {
+			Checks map[string]any `json:"checks"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body.Checks, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- drainAndShutdown(ctx, srv, health, 500*time.Millisecond) }()
+
+	drained := false
+	for !drained {
+		select {
+		case err := <-done:
+			t.Fatalf("serwer zamknięty (%v), zanim sonda zobaczyła wygaszanie", err)
+		default:
+		}
+		status, checks, err := probe()
+		if err != nil {
+			t.Fatalf("sonda w trakcie wygaszania: %v", err)
+		}
+		_, drained = checks["shutdown"]
+		if drained && status != http.StatusServiceUnavailable {
+			t.Fatalf("status %d podczas wygaszania, oczekiwano 503", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := probe(); err == nil {
+		t.Error("po Shutdown serwer dalej przyjmuje połączenia")
+	}
+}
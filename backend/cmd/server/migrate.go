@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"kodama-backend/internal/config"
+	"kodama-backend/internal/database"
+)
+
+const migrateUsage = `Użycie: kodama-server migrate <polecenie>
+
+Polecenia:
+  up          zastosuj wszystkie oczekujące migracje
+  down [N]    wycofaj N ostatnich migracji (domyślnie 1)
+  status      pokaż zastosowane i oczekujące migracje`
+
+// runMigrateCommand obsługuje podkomendę "migrate"; zwraca kod wyjścia procesu
+func runMigrateCommand(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	steps := 1
+	switch args[0] {
+	case "up", "status":
+		if len(args) > 1 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+	case "down":
+		if len(args) > 2 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Fprintln(os.Stderr, "Liczba migracji do wycofania musi być dodatnia")
+				return 2
+			}
+			steps = n
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	db, err := database.Connect(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Nie można połączyć z bazą danych: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		done, err := database.MigrateUp(ctx, db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Błąd migracji: %v\n", err)
+			return 1
+		}
+		if len(done) == 0 {
+			fmt.Println("Schemat jest aktualny")
+		}
+
+	case "down":
+		done, err := database.MigrateDown(ctx, db, steps)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Błąd wycofywania migracji: %v\n", err)
+			return 1
+		}
+		if len(done) == 0 {
+			fmt.Println("Brak zastosowanych migracji")
+		}
+
+	case "status":
+		statuses, err := database.MigrationStatuses(ctx, db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Błąd odczytu stanu migracji: %v\n", err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "WERSJA\tNAZWA\tZASTOSOWANO")
+		for _, s := range statuses {
+			applied := "oczekuje"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		w.Flush()
+	}
+	return 0
+}
@@ -4,11 +4,22 @@ import (
 	"errors"
 	"time"
 
-	"kodama-backend/internal/config"
-
 	"github.com/golang-jwt/jwt/v5"
 )
 
+var (
+	jwtSecret []byte
+	tokenTTL  = 24 * time.Hour
+
+	errNotConfigured = errors.New("sekret JWT nie został skonfigurowany")
+)
+
+// Configure ustawia sekret i czas życia tokenów — wywoływane raz przy starcie
+func Configure(secret string, ttl time.Duration) {
+	jwtSecret = []byte(secret)
+	tokenTTL = ttl
+}
+
 type Claims struct {
 	UserID   int    `json:"user_id"`
 	Email    string `json:"email"`
@@ -17,31 +28,35 @@ type Claims struct {
 }
 
 func GenerateToken(userID int, email, username string) (string, error) {
-	cfg := config.Load()
+	if len(jwtSecret) == 0 {
+		return "", errNotConfigured
+	}
 
 	claims := Claims{
 		UserID:   userID,
 		Email:    email,
 		Username: username,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "kodama",
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(cfg.JWTSecret))
+	return token.SignedString(jwtSecret)
 }
 
 func ValidateToken(tokenString string) (*Claims, error) {
-	cfg := config.Load()
+	if len(jwtSecret) == 0 {
+		return nil, errNotConfigured
+	}
 
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("nieoczekiwana metoda podpisywania")
 		}
-		return []byte(cfg.JWTSecret), nil
+		return jwtSecret, nil
 	})
 
 	if err != nil {
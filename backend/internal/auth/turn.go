@@ -0,0 +1,23 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+// GenerateTURNCredentials tworzy tymczasowe dane logowania TURN (TURN REST API):
+// username = "<unix expiry>:<userID>", credential = base64(HMAC-SHA1(secret, username)).
+// Serwer TURN (np. coturn z use-auth-secret) weryfikuje je bez współdzielonej bazy haseł.
+func GenerateTURNCredentials(secret string, userID int, ttl time.Duration) (username, credential string, expiresAt time.Time) {
+	expiresAt = time.Now().Add(ttl)
+	username = fmt.Sprintf("%d:%d", expiresAt.Unix(), userID)
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	credential = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	return username, credential, expiresAt
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ──────────────────────────────────────────────
+// Źródła wartości: zmienne środowiskowe i plik
+// ──────────────────────────────────────────────
+
+// source — odczyt wartości po nazwie zmiennej środowiskowej. Klucze pliku to te
+// same nazwy małymi literami; zagnieżdżone sekcje łączy się podkreśleniem,
+// np. http: {read_timeout: 15s} = HTTP_READ_TIMEOUT.
+type source struct {
+	file map[string]string
+	errs []error
+}
+
+// loadFile — płaski słownik KLUCZ -> wartość z pliku YAML albo TOML
+func loadFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("nie można odczytać pliku konfiguracji: %w", err)
+	}
+
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("nieobsługiwany format pliku konfiguracji %q (oczekiwano .yaml, .yml albo .toml)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("błąd parsowania %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	flatten("", raw, values)
+	return values, nil
+}
+
+func flatten(prefix string, raw map[string]any, out map[string]string) {
+	for key, value := range raw {
+		key = strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			flatten(key, v, out)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			out[key] = strings.Join(items, ",")
+		default:
+			out[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// lookup — zmienna środowiskowa, a gdy jej brak — wartość z pliku
+func (s *source) lookup(key string) (string, bool) {
+	if value, ok := os.LookupEnv(key); ok {
+		return value, true
+	}
+	value, ok := s.file[key]
+	return value, ok
+}
+
+func (s *source) fail(format string, args ...any) {
+	s.errs = append(s.errs, fmt.Errorf(format, args...))
+}
+
+func (s *source) str(key, fallback string) string {
+	if value, ok := s.lookup(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func (s *source) int(key string, fallback int) int {
+	value, ok := s.lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		s.fail("%s: oczekiwano liczby całkowitej, jest %q", key, value)
+		return fallback
+	}
+	return parsed
+}
+
+func (s *source) float(key string, fallback float64) float64 {
+	value, ok := s.lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		s.fail("%s: oczekiwano liczby, jest %q", key, value)
+		return fallback
+	}
+	return parsed
+}
+
+func (s *source) bool(key string, fallback bool) bool {
+	value, ok := s.lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		s.fail("%s: oczekiwano true/false, jest %q", key, value)
+		return fallback
+	}
+	return parsed
+}
+
+// duration — liczba sekund ("15") albo zapis Go ("15s", "24h")
+func (s *source) duration(key string, fallback time.Duration) time.Duration {
+	value, ok := s.lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := parseDuration(value)
+	if err != nil {
+		s.fail("%s: oczekiwano czasu (np. 30 albo 30s), jest %q", key, value)
+		return fallback
+	}
+	return parsed
+}
+
+// list — wartości rozdzielone przecinkami; pusta zmienna to pusta lista
+func (s *source) list(key string, fallback []string) []string {
+	value, ok := s.lookup(key)
+	if !ok {
+		return fallback
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// rate — limit w zapisie "<liczba>/<okres>", np. "10/1m" albo "50/1s"
+func (s *source) rate(key string, fallback RateLimit) RateLimit {
+	value, ok := s.lookup(key)
+	if !ok {
+		return fallback
+	}
+	count, period, found := strings.Cut(strings.TrimSpace(value), "/")
+	limit, err := strconv.Atoi(count)
+	if err == nil && found {
+		var d time.Duration
+		if d, err = parseDuration(period); err == nil {
+			return RateLimit{Limit: limit, Period: d}
+		}
+	}
+	s.fail("%s: oczekiwano zapisu <liczba>/<okres> (np. 10/1m), jest %q", key, value)
+	return fallback
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_channels_position;
+DROP INDEX IF EXISTS idx_channels_parent;
+
+-- Kanały z kategorii trafiają poza kategorię, same kategorie znikają
+ALTER TABLE channels DROP COLUMN IF EXISTS position;
+ALTER TABLE channels DROP COLUMN IF EXISTS parent_id;
+DELETE FROM channels WHERE type = 'category';
+
+ALTER TABLE channels DROP CONSTRAINT IF EXISTS channels_type_check;
+ALTER TABLE channels ADD CONSTRAINT channels_type_check CHECK (type IN ('text', 'voice'));
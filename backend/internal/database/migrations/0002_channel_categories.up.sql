@@ -0,0 +1,9 @@
+-- Kategorie i kolejność kanałów
+ALTER TABLE channels DROP CONSTRAINT IF EXISTS channels_type_check;
+ALTER TABLE channels ADD CONSTRAINT channels_type_check CHECK (type IN ('text', 'voice', 'category'));
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES channels(id) ON DELETE SET NULL;
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS position INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_channels_parent ON channels(parent_id);
+CREATE INDEX IF NOT EXISTS idx_channels_position ON channels(server_id, position);
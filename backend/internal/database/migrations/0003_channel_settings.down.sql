@@ -0,0 +1,5 @@
+ALTER TABLE channels DROP COLUMN IF EXISTS user_limit;
+ALTER TABLE channels DROP COLUMN IF EXISTS bitrate;
+ALTER TABLE channels DROP COLUMN IF EXISTS nsfw;
+ALTER TABLE channels DROP COLUMN IF EXISTS slowmode_seconds;
+ALTER TABLE channels DROP COLUMN IF EXISTS topic;
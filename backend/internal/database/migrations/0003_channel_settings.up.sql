@@ -0,0 +1,6 @@
+-- Ustawienia kanałów (temat, slowmode, NSFW, parametry kanałów głosowych)
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS topic VARCHAR(1024) NOT NULL DEFAULT '';
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS slowmode_seconds INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS nsfw BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS bitrate INTEGER NOT NULL DEFAULT 64000;
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS user_limit INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE channels DROP CONSTRAINT IF EXISTS channels_voice_mode_check;
+ALTER TABLE channels DROP COLUMN IF EXISTS voice_mode;
@@ -0,0 +1,4 @@
+-- Tryb kanału głosowego: mesh (peer-to-peer) lub sfu (przekazywanie przez backend)
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS voice_mode VARCHAR(10) NOT NULL DEFAULT 'mesh';
+ALTER TABLE channels DROP CONSTRAINT IF EXISTS channels_voice_mode_check;
+ALTER TABLE channels ADD CONSTRAINT channels_voice_mode_check CHECK (voice_mode IN ('mesh', 'sfu'));
@@ -0,0 +1,2 @@
+ALTER TABLE channels DROP COLUMN IF EXISTS video_bitrate;
+ALTER TABLE channels DROP COLUMN IF EXISTS video_limit;
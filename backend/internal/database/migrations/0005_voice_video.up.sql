@@ -0,0 +1,3 @@
+-- Wideo na kanałach głosowych: limit nadających wideo (0 = bez limitu) i bitrate na nadawcę
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS video_limit INTEGER NOT NULL DEFAULT 4;
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS video_bitrate INTEGER NOT NULL DEFAULT 1000000;
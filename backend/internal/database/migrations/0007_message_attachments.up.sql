@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS message_attachments (
+	id SERIAL PRIMARY KEY,
+	message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+	filename VARCHAR(255) NOT NULL,
+	url TEXT NOT NULL,
+	content_type VARCHAR(100) NOT NULL DEFAULT '',
+	size_bytes BIGINT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_attachments_message ON message_attachments(message_id);
@@ -0,0 +1,3 @@
+-- Pliki nagrań w RECORDINGS_DIR zostają — usuwany jest tylko manifest
+DROP TABLE IF EXISTS voice_recording_tracks;
+DROP TABLE IF EXISTS voice_recordings;
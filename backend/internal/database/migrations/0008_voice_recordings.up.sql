@@ -0,0 +1,26 @@
+-- Nagrania kanałów głosowych (tryb SFU): manifest nagrania i pliki Ogg/Opus uczestników
+CREATE TABLE IF NOT EXISTS voice_recordings (
+	id SERIAL PRIMARY KEY,
+	channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+	server_id INTEGER NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+	started_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	stopped_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'recording'
+		CHECK (status IN ('recording', 'completed', 'interrupted')),
+	started_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+	stopped_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_voice_recordings_channel ON voice_recordings(channel_id, started_at DESC);
+
+CREATE TABLE IF NOT EXISTS voice_recording_tracks (
+	id SERIAL PRIMARY KEY,
+	recording_id INTEGER NOT NULL REFERENCES voice_recordings(id) ON DELETE CASCADE,
+	user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	file_name TEXT NOT NULL, -- ścieżka względem katalogu nagrań
+	size_bytes BIGINT NOT NULL DEFAULT 0,
+	started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	ended_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_voice_recording_tracks_recording ON voice_recording_tracks(recording_id);
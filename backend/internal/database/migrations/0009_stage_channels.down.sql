@@ -0,0 +1,4 @@
+-- Sceny stają się zwykłymi kanałami głosowymi
+UPDATE channels SET type = 'voice' WHERE type = 'stage';
+ALTER TABLE channels DROP CONSTRAINT IF EXISTS channels_type_check;
+ALTER TABLE channels ADD CONSTRAINT channels_type_check CHECK (type IN ('text', 'voice', 'category'));
@@ -0,0 +1,3 @@
+-- Kanały sceniczne (stage): mówcy i słuchacze, zawsze w trybie SFU
+ALTER TABLE channels DROP CONSTRAINT IF EXISTS channels_type_check;
+ALTER TABLE channels ADD CONSTRAINT channels_type_check CHECK (type IN ('text', 'voice', 'stage', 'category'));
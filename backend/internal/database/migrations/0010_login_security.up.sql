@@ -0,0 +1,26 @@
+-- Liczniki nieudanych logowań per konto (email) i per adres IP z rosnącymi blokadami
+CREATE TABLE IF NOT EXISTS login_attempts (
+	scope VARCHAR(10) NOT NULL CHECK (scope IN ('account', 'ip')),
+	subject TEXT NOT NULL, -- email małymi literami albo adres IP
+	failures INTEGER NOT NULL DEFAULT 0, -- nieudane próby od ostatniej blokady
+	lockouts INTEGER NOT NULL DEFAULT 0, -- dotychczasowe blokady (każda kolejna dłuższa)
+	locked_until TIMESTAMP WITH TIME ZONE,
+	last_failure_at TIMESTAMP WITH TIME ZONE,
+	PRIMARY KEY (scope, subject)
+);
+
+-- Historia logowań (także nieudanych) z adresem IP i user agentem
+CREATE TABLE IF NOT EXISTS login_history (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER REFERENCES users(id) ON DELETE CASCADE, -- NULL dla nieistniejącego konta
+	email TEXT NOT NULL,
+	ip_address VARCHAR(45) NOT NULL,
+	user_agent TEXT NOT NULL DEFAULT '',
+	success BOOLEAN NOT NULL,
+	reason VARCHAR(20) NOT NULL
+		CHECK (reason IN ('ok', 'registered', 'bad_password', 'unknown_account', 'locked')),
+	new_device BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_history_user ON login_history(user_id, created_at DESC);
@@ -0,0 +1,479 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"kodama-backend/internal/auth"
+	"kodama-backend/internal/config"
+	"kodama-backend/internal/middleware"
+	"kodama-backend/internal/models"
+	"kodama-backend/internal/repository"
+
+	"github.com/gorilla/mux"
+)
+
+// testAPI — trasy REST jak w cmd/server (bez limitów żądań) na repozytoriach w pamięci
+type testAPI struct {
+	t     *testing.T
+	srv   *httptest.Server
+	store *repository.Store
+}
+
+func newTestAPI(t *testing.T, configure ...func(cfg *config.Config)) *testAPI {
+	t.Helper()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	auth.Configure("test-secret", time.Hour)
+
+	cfg := &config.Config{
+		LoginMaxFailures:   3,
+		LoginMaxFailuresIP: 100,
+		LoginFailureWindow: time.Minute,
+		LoginLockout:       time.Minute,
+		LoginLockoutMax:    time.Hour,
+	}
+	for _, f := range configure {
+		f(cfg)
+	}
+	store := repository.NewMemory()
+	authHandler := NewAuthHandler(cfg, store, nil)
+	serverHandler := NewServerHandler(store)
+	channelHandler := NewChannelHandler(cfg, store, NewVoiceState())
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/auth/register", authHandler.Register).Methods("POST")
+	r.HandleFunc("/api/auth/login", authHandler.Login).Methods("POST")
+
+	protected := r.PathPrefix("/api").Subrouter()
+	protected.Use(middleware.AuthMiddleware)
+	protected.HandleFunc("/me", authHandler.Me).Methods("GET")
+	protected.HandleFunc("/servers", serverHandler.CreateServer).Methods("POST")
+	protected.HandleFunc("/servers", serverHandler.ListServers).Methods("GET")
+	protected.HandleFunc("/servers/join", serverHandler.JoinServer).Methods("POST")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels", channelHandler.CreateChannel).Methods("POST")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels", channelHandler.ListChannels).Methods("GET")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels/{channelId:[0-9]+}", channelHandler.UpdateChannel).Methods("PATCH")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels/{channelId:[0-9]+}", channelHandler.DeleteChannel).Methods("DELETE")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels/{channelId:[0-9]+}/messages", channelHandler.GetMessages).Methods("GET")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels/{channelId:[0-9]+}/messages", channelHandler.SendMessage).Methods("POST")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/messages/search", channelHandler.SearchMessages).Methods("GET")
+
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return &testAPI{t: t, srv: srv, store: store}
+}
+
+// do wysyła żądanie JSON; out (jeśli nie nil) dostaje zdekodowane ciało odpowiedzi 2xx
+func (a *testAPI) do(method, path, token string, body, out any) int {
+	a.t.Helper()
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		rd = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, a.srv.URL+path, rd)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := a.srv.Client().Do(req)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			a.t.Fatalf("%s %s: dekodowanie odpowiedzi: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// register zakłada konto i zwraca token
+func (a *testAPI) register(username string) string {
+	a.t.Helper()
+	var res models.AuthResponse
+	req := models.RegisterRequest{Email: username + "@example.com", Username: username, Password: "haslo-testowe"}
+	if code := a.do("POST", "/api/auth/register", "", req, &res); code != http.StatusCreated {
+		a.t.Fatalf("rejestracja %s: status %d", username, code)
+	}
+	return res.Token
+}
+
+// createServer zakłada serwer należący do właściciela tokena
+func (a *testAPI) createServer(token, name string) models.ServerResponse {
+	a.t.Helper()
+	var res models.ServerResponse
+	if code := a.do("POST", "/api/servers", token, models.CreateServerRequest{Name: name}, &res); code != http.StatusCreated {
+		a.t.Fatalf("tworzenie serwera: status %d", code)
+	}
+	return res
+}
+
+func (a *testAPI) join(token, inviteCode string) {
+	a.t.Helper()
+	if code := a.do("POST", "/api/servers/join", token, models.JoinServerRequest{InviteCode: inviteCode}, nil); code != http.StatusOK {
+		a.t.Fatalf("dołączanie do serwera: status %d", code)
+	}
+}
+
+func (a *testAPI) createChannel(token string, serverID int, name, chType string) models.Channel {
+	a.t.Helper()
+	var ch models.Channel
+	path := fmt.Sprintf("/api/servers/%d/channels", serverID)
+	if code := a.do("POST", path, token, models.CreateChannelRequest{Name: name, Type: chType}, &ch); code != http.StatusCreated {
+		a.t.Fatalf("tworzenie kanału: status %d", code)
+	}
+	return ch
+}
+
+type apiCase struct {
+	name   string
+	method string
+	path   string
+	token  string
+	body   any
+	want   int
+}
+
+// run wykonuje przypadki po kolei — kolejne mogą zależeć od skutków poprzednich
+func (a *testAPI) run(cases []apiCase) {
+	a.t.Helper()
+	for _, tc := range cases {
+		a.t.Run(tc.name, func(t *testing.T) {
+			if got := a.do(tc.method, tc.path, tc.token, tc.body, nil); got != tc.want {
+				t.Errorf("%s %s: status %d, oczekiwano %d", tc.method, tc.path, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAuthAPI(t *testing.T) {
+	a := newTestAPI(t)
+	reg := func(email, username, password string) models.RegisterRequest {
+		return models.RegisterRequest{Email: email, Username: username, Password: password}
+	}
+	login := func(email, password string) models.LoginRequest {
+		return models.LoginRequest{Email: email, Password: password}
+	}
+
+	a.run([]apiCase{
+		{"rejestracja", "POST", "/api/auth/register", "", reg("ala@example.com", "ala", "haslo-testowe"), http.StatusCreated},
+		{"zajęty email", "POST", "/api/auth/register", "", reg("ala@example.com", "ala2", "haslo-testowe"), http.StatusConflict},
+		{"krótkie hasło", "POST", "/api/auth/register", "", reg("ola@example.com", "ola", "krotkie"), http.StatusBadRequest},
+		{"zły email", "POST", "/api/auth/register", "", reg("ola@", "ola", "haslo-testowe"), http.StatusBadRequest},
+		{"krótka nazwa", "POST", "/api/auth/register", "", reg("ola@example.com", "ol", "haslo-testowe"), http.StatusBadRequest},
+		{"logowanie", "POST", "/api/auth/login", "", login("ala@example.com", "haslo-testowe"), http.StatusOK},
+		{"brak hasła", "POST", "/api/auth/login", "", login("ala@example.com", ""), http.StatusBadRequest},
+		{"nieznane konto", "POST", "/api/auth/login", "", login("nikt@example.com", "haslo-testowe"), http.StatusUnauthorized},
+		{"złe hasło 1", "POST", "/api/auth/login", "", login("ala@example.com", "zle-haslo"), http.StatusUnauthorized},
+		{"złe hasło 2", "POST", "/api/auth/login", "", login("ala@example.com", "zle-haslo"), http.StatusUnauthorized},
+		{"złe hasło 3", "POST", "/api/auth/login", "", login("ala@example.com", "zle-haslo"), http.StatusUnauthorized},
+		{"blokada konta", "POST", "/api/auth/login", "", login("ala@example.com", "haslo-testowe"), http.StatusTooManyRequests},
+		{"/me bez tokena", "GET", "/api/me", "", nil, http.StatusUnauthorized},
+		{"/me ze złym tokenem", "GET", "/api/me", "nie-token", nil, http.StatusUnauthorized},
+	})
+
+	// Token z rejestracji otwiera chronione endpointy
+	token := a.register("ewa")
+	var me models.User
+	if code := a.do("GET", "/api/me", token, nil, &me); code != http.StatusOK {
+		t.Fatalf("/me: status %d", code)
+	}
+	if me.Username != "ewa" || me.Email != "ewa@example.com" {
+		t.Errorf("/me = %+v", me)
+	}
+}
+
+// TestLoginLockoutParallel — równoległe próby nie omijają limitu: każda jest liczona
+// przed porównaniem hasła, więc hasło sprawdzają najwyżej LoginMaxFailures z nich
+func TestLoginLockoutParallel(t *testing.T) {
+	a := newTestAPI(t)
+	a.register("ala")
+
+	const attempts = 10
+	codes := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- a.do("POST", "/api/auth/login", "", models.LoginRequest{Email: "ala@example.com", Password: "zle-haslo"}, nil)
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	count := map[int]int{}
+	for code := range codes {
+		count[code]++
+	}
+	if count[http.StatusUnauthorized] != 3 || count[http.StatusTooManyRequests] != attempts-3 {
+		t.Errorf("statusy = %v, oczekiwano 3× 401 i %d× 429", count, attempts-3)
+	}
+}
+
+// TestLoginIPLimit — udane logowanie, które jest akurat LoginMaxFailuresIP-tą próbą
+// z adresu, nie zostawia blokady adresu; kolejne nieudane już ją zakładają
+func TestLoginIPLimit(t *testing.T) {
+	a := newTestAPI(t, func(cfg *config.Config) { cfg.LoginMaxFailuresIP = 4 })
+	a.register("ala")
+	a.register("ola")
+	login := func(username, password string) models.LoginRequest {
+		return models.LoginRequest{Email: username + "@example.com", Password: password}
+	}
+
+	a.run([]apiCase{
+		{"złe hasło ala 1", "POST", "/api/auth/login", "", login("ala", "zle-haslo"), http.StatusUnauthorized},
+		{"złe hasło ala 2", "POST", "/api/auth/login", "", login("ala", "zle-haslo"), http.StatusUnauthorized},
+		{"złe hasło ola", "POST", "/api/auth/login", "", login("ola", "zle-haslo"), http.StatusUnauthorized},
+		{"poprawne hasło jako 4. próba", "POST", "/api/auth/login", "", login("ala", "haslo-testowe"), http.StatusOK},
+		{"adres nie jest zablokowany", "POST", "/api/auth/login", "", login("ola", "haslo-testowe"), http.StatusOK},
+		{"złe hasło jako 4. próba", "POST", "/api/auth/login", "", login("ola", "zle-haslo"), http.StatusUnauthorized},
+		{"blokada adresu", "POST", "/api/auth/login", "", login("ala", "haslo-testowe"), http.StatusTooManyRequests},
+	})
+}
+
+func TestServerAPI(t *testing.T) {
+	a := newTestAPI(t)
+	owner := a.register("wlasciciel")
+	member := a.register("czlonek")
+	server := a.createServer(owner, "Serwer testowy")
+
+	if server.Role != "owner" || server.MemberCount != 1 {
+		t.Errorf("nowy serwer: rola %q, członków %d", server.Role, server.MemberCount)
+	}
+
+	join := func(code string) models.JoinServerRequest { return models.JoinServerRequest{InviteCode: code} }
+	a.run([]apiCase{
+		{"bez tokena", "POST", "/api/servers", "", models.CreateServerRequest{Name: "X serwer"}, http.StatusUnauthorized},
+		{"pusta nazwa", "POST", "/api/servers", owner, models.CreateServerRequest{Name: "  "}, http.StatusBadRequest},
+		{"za krótka nazwa", "POST", "/api/servers", owner, models.CreateServerRequest{Name: "X"}, http.StatusBadRequest},
+		{"pusty kod", "POST", "/api/servers/join", member, join(""), http.StatusBadRequest},
+		{"nieznany kod", "POST", "/api/servers/join", member, join("nieistnieje"), http.StatusNotFound},
+		{"dołączenie", "POST", "/api/servers/join", member, join(server.Server.InviteCode), http.StatusOK},
+		{"ponowne dołączenie", "POST", "/api/servers/join", member, join(server.Server.InviteCode), http.StatusConflict},
+		{"właściciel dołącza", "POST", "/api/servers/join", owner, join(server.Server.InviteCode), http.StatusConflict},
+	})
+
+	var servers []models.ServerResponse
+	if code := a.do("GET", "/api/servers", member, nil, &servers); code != http.StatusOK {
+		t.Fatalf("lista serwerów: status %d", code)
+	}
+	if len(servers) != 1 || servers[0].Server.ID != server.Server.ID || servers[0].Role != "member" || servers[0].MemberCount != 2 {
+		t.Errorf("lista serwerów członka = %+v", servers)
+	}
+}
+
+func TestChannelAPI(t *testing.T) {
+	a := newTestAPI(t)
+	owner := a.register("wlasciciel")
+	member := a.register("czlonek")
+	outsider := a.register("obcy")
+	server := a.createServer(owner, "Serwer testowy")
+	a.join(member, server.Server.InviteCode)
+
+	channels := fmt.Sprintf("/api/servers/%d/channels", server.Server.ID)
+	general := a.createChannel(owner, server.Server.ID, "ogólny", "text")
+	channel := fmt.Sprintf("%s/%d", channels, general.ID)
+	create := func(name, chType string) models.CreateChannelRequest {
+		return models.CreateChannelRequest{Name: name, Type: chType}
+	}
+	topic := "Nowy temat"
+	update := models.UpdateChannelRequest{Topic: &topic}
+
+	a.run([]apiCase{
+		{"członek tworzy kanał", "POST", channels, member, create("pogaduchy", "text"), http.StatusCreated},
+		{"kanał głosowy", "POST", channels, owner, create("głosowy", "voice"), http.StatusCreated},
+		{"kategoria", "POST", channels, owner, create("Kategoria", "category"), http.StatusCreated},
+		{"obcy tworzy kanał", "POST", channels, outsider, create("obcy", "text"), http.StatusForbidden},
+		{"nieznany typ", "POST", channels, owner, create("zły", "video"), http.StatusBadRequest},
+		{"pusta nazwa", "POST", channels, owner, create(" ", "text"), http.StatusBadRequest},
+		{"obcy listuje", "GET", channels, outsider, nil, http.StatusForbidden},
+		{"członek edytuje", "PATCH", channel, member, update, http.StatusForbidden},
+		{"właściciel edytuje", "PATCH", channel, owner, update, http.StatusOK},
+		{"edycja nieistniejącego", "PATCH", channels + "/9999", owner, update, http.StatusNotFound},
+		{"członek usuwa", "DELETE", channel, member, nil, http.StatusForbidden},
+		{"właściciel usuwa", "DELETE", channel, owner, nil, http.StatusOK},
+		{"ponowne usunięcie", "DELETE", channel, owner, nil, http.StatusNotFound},
+	})
+
+	var list []models.Channel
+	if code := a.do("GET", channels, member, nil, &list); code != http.StatusOK {
+		t.Fatalf("lista kanałów: status %d", code)
+	}
+	names := map[string]bool{}
+	for _, ch := range list {
+		names[ch.Name] = true
+	}
+	if len(list) != 3 || names["ogólny"] || !names["pogaduchy"] || !names["głosowy"] || !names["Kategoria"] {
+		t.Errorf("kanały po zmianach = %v", names)
+	}
+}
+
+func TestMessageAPI(t *testing.T) {
+	a := newTestAPI(t)
+	owner := a.register("wlasciciel")
+	member := a.register("czlonek")
+	outsider := a.register("obcy")
+	server := a.createServer(owner, "Serwer testowy")
+	a.join(member, server.Server.InviteCode)
+
+	general := a.createChannel(owner, server.Server.ID, "ogólny", "text")
+	voice := a.createChannel(owner, server.Server.ID, "głosowy", "voice")
+	messages := fmt.Sprintf("/api/servers/%d/channels/%d/messages", server.Server.ID, general.ID)
+	voiceMessages := fmt.Sprintf("/api/servers/%d/channels/%d/messages", server.Server.ID, voice.ID)
+	send := func(content string) models.SendMessageRequest { return models.SendMessageRequest{Content: content} }
+
+	a.run([]apiCase{
+		{"właściciel pisze", "POST", messages, owner, send("cotygodniowy raport gotowy"), http.StatusCreated},
+		{"członek pisze", "POST", messages, member, send("dzięki za raport"), http.StatusCreated},
+		{"członek pisze ponownie", "POST", messages, member, send("jutro spotkanie"), http.StatusCreated},
+		{"obcy pisze", "POST", messages, outsider, send("hej"), http.StatusForbidden},
+		{"pusta treść", "POST", messages, member, send("   "), http.StatusBadRequest},
+		{"za długa treść", "POST", messages, member, send(string(bytes.Repeat([]byte("a"), 2001))), http.StatusBadRequest},
+		{"kanał głosowy", "POST", voiceMessages, member, send("hej"), http.StatusBadRequest},
+		{"obcy czyta", "GET", messages, outsider, nil, http.StatusForbidden},
+		{"zły kursor", "GET", messages + "?before=abc", member, nil, http.StatusBadRequest},
+	})
+
+	t.Run("lista", func(t *testing.T) {
+		var list []models.Message
+		if code := a.do("GET", messages, member, nil, &list); code != http.StatusOK {
+			t.Fatalf("status %d", code)
+		}
+		var got []string
+		for _, m := range list {
+			got = append(got, m.Content)
+		}
+		want := []string{"cotygodniowy raport gotowy", "dzięki za raport", "jutro spotkanie"}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("wiadomości = %q, oczekiwano %q (najstarsze pierwsze)", got, want)
+		}
+		if list[1].Username != "czlonek" {
+			t.Errorf("autor = %q, oczekiwano czlonek", list[1].Username)
+		}
+
+		var page []models.Message
+		path := fmt.Sprintf("%s?limit=1&before=%d", messages, list[2].ID)
+		if code := a.do("GET", path, member, nil, &page); code != http.StatusOK {
+			t.Fatalf("paginacja: status %d", code)
+		}
+		if len(page) != 1 || page[0].ID != list[1].ID {
+			t.Errorf("strona przed %d = %+v, oczekiwano wiadomości %d", list[2].ID, page, list[1].ID)
+		}
+	})
+
+	screenshot := models.Attachment{Filename: "zrzut.png", URL: "https://cdn.example.com/zrzut.png", ContentType: "image/png", Size: 1024}
+	withFiles := func(content string, files ...models.Attachment) models.SendMessageRequest {
+		return models.SendMessageRequest{Content: content, Attachments: files}
+	}
+	a.run([]apiCase{
+		{"sam załącznik", "POST", messages, member, withFiles("", screenshot), http.StatusCreated},
+		{"załącznik bez URL", "POST", messages, member, withFiles("plik", models.Attachment{Filename: "a.txt"}), http.StatusBadRequest},
+		{"załącznik z innym schematem", "POST", messages, member, withFiles("plik", models.Attachment{Filename: "a.txt", URL: "file:///etc/passwd"}), http.StatusBadRequest},
+		{"załącznik bez nazwy", "POST", messages, member, withFiles("plik", models.Attachment{URL: "https://cdn.example.com/a"}), http.StatusBadRequest},
+		{"za dużo załączników", "POST", messages, member, withFiles("pliki", make([]models.Attachment, maxAttachments+1)...), http.StatusBadRequest},
+	})
+
+	t.Run("lista z załącznikiem", func(t *testing.T) {
+		var list []models.Message
+		if code := a.do("GET", messages+"?limit=1", member, nil, &list); code != http.StatusOK {
+			t.Fatalf("status %d", code)
+		}
+		if len(list) != 1 || len(list[0].Attachments) != 1 || list[0].Attachments[0].URL != screenshot.URL {
+			t.Errorf("ostatnia wiadomość = %+v, oczekiwano załącznika %s", list, screenshot.URL)
+		}
+	})
+
+	search := fmt.Sprintf("/api/servers/%d/messages/search?q=", server.Server.ID)
+	for _, tc := range []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"tekst", "raport", 2},
+		{"autor", "raport+from:czlonek", 1},
+		{"kanał", "in:ogólny", 4},
+		{"załącznik", "has:attachment", 1},
+		{"załącznik od autora", "has:attachment+from:wlasciciel", 0},
+		{"brak wyników", "urlop", 0},
+		{"data w przyszłości", "after:2999-01-01", 0},
+	} {
+		t.Run("szukaj "+tc.name, func(t *testing.T) {
+			var res models.MessageSearchResponse
+			if code := a.do("GET", search+tc.query, member, nil, &res); code != http.StatusOK {
+				t.Fatalf("status %d", code)
+			}
+			if res.Total != tc.want || len(res.Results) != tc.want {
+				t.Errorf("q=%s: %d wyników (total %d), oczekiwano %d", tc.query, len(res.Results), res.Total, tc.want)
+			}
+		})
+	}
+
+	a.run([]apiCase{
+		{"puste zapytanie", "GET", search, member, nil, http.StatusBadRequest},
+		{"zła data", "GET", search + "before:wczoraj", member, nil, http.StatusBadRequest},
+		{"obcy szuka", "GET", search + "raport", outsider, nil, http.StatusForbidden},
+	})
+}
+
+func TestSlowmodeAPI(t *testing.T) {
+	a := newTestAPI(t)
+	owner := a.register("wlasciciel")
+	member := a.register("czlonek")
+	server := a.createServer(owner, "Serwer testowy")
+	a.join(member, server.Server.InviteCode)
+
+	general := a.createChannel(owner, server.Server.ID, "ogólny", "text")
+	channel := fmt.Sprintf("/api/servers/%d/channels/%d", server.Server.ID, general.ID)
+	slowmode := 60
+	send := models.SendMessageRequest{Content: "hej"}
+
+	a.run([]apiCase{
+		{"włączenie slowmode", "PATCH", channel, owner, models.UpdateChannelRequest{Slowmode: &slowmode}, http.StatusOK},
+		{"pierwsza wiadomość", "POST", channel + "/messages", member, send, http.StatusCreated},
+		{"druga wiadomość", "POST", channel + "/messages", member, send, http.StatusTooManyRequests},
+		{"właściciel bez limitu 1", "POST", channel + "/messages", owner, send, http.StatusCreated},
+		{"właściciel bez limitu 2", "POST", channel + "/messages", owner, send, http.StatusCreated},
+	})
+
+	// Równoległe wiadomości nowego członka — przechodzi dokładnie jedna
+	late := a.register("spozniony")
+	a.join(late, server.Server.InviteCode)
+	const parallel = 10
+	codes := make(chan int, parallel)
+	var wg sync.WaitGroup
+	for range parallel {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- a.do("POST", channel+"/messages", late, send, nil)
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	count := map[int]int{}
+	for code := range codes {
+		count[code]++
+	}
+	if count[http.StatusCreated] != 1 || count[http.StatusTooManyRequests] != parallel-1 {
+		t.Errorf("statusy = %v, oczekiwano 1× 201 i %d× 429", count, parallel-1)
+	}
+}
@@ -1,25 +1,37 @@
 package handlers
 
 import (
-	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	"kodama-backend/internal/auth"
+	"kodama-backend/internal/config"
+	"kodama-backend/internal/logging"
 	"kodama-backend/internal/models"
+	"kodama-backend/internal/notify"
+	"kodama-backend/internal/repository"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthHandler struct {
-	db *sql.DB
+	users    repository.UserRepository
+	logins   repository.LoginRepository
+	lockout  lockoutPolicy
+	notifier notify.Notifier // nil = bez powiadomień o nowych urządzeniach
 }
 
-func NewAuthHandler(db *sql.DB) *AuthHandler {
-	return &AuthHandler{db: db}
+func NewAuthHandler(cfg *config.Config, store *repository.Store, notifier notify.Notifier) *AuthHandler {
+	return &AuthHandler{
+		users:    store.Users,
+		logins:   store.Logins,
+		lockout:  newLockoutPolicy(cfg),
+		notifier: notifier,
+	}
 }
 
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -36,10 +48,9 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Sprawdź czy email już istnieje
-	var exists bool
-	err := h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)", req.Email).Scan(&exists)
+	exists, err := h.users.EmailExists(r.Context(), req.Email)
 	if err != nil {
-		log.Printf("Błąd sprawdzania emaila: %v", err)
+		logError(r, "Błąd sprawdzania emaila", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
@@ -51,22 +62,19 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	// Hash hasła
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		log.Printf("Błąd hashowania hasła: %v", err)
+		logError(r, "Błąd hashowania hasła", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
 
 	// Wstawienie użytkownika
-	var user models.User
-	err = h.db.QueryRow(
-		`INSERT INTO users (email, username, password_hash) 
-		 VALUES ($1, $2, $3) 
-		 RETURNING id, email, username, created_at, updated_at`,
-		req.Email, req.Username, string(hashedPassword),
-	).Scan(&user.ID, &user.Email, &user.Username, &user.CreatedAt, &user.UpdatedAt)
-
+	user, err := h.users.Create(r.Context(), req.Email, req.Username, string(hashedPassword))
+	if errors.Is(err, repository.ErrConflict) {
+		sendError(w, http.StatusConflict, "Użytkownik z tym adresem email już istnieje")
+		return
+	}
 	if err != nil {
-		log.Printf("Błąd tworzenia użytkownika: %v", err)
+		logError(r, "Błąd tworzenia użytkownika", err)
 		sendError(w, http.StatusInternalServerError, "Nie można utworzyć użytkownika")
 		return
 	}
@@ -74,14 +82,25 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	// Generowanie tokena JWT
 	token, err := auth.GenerateToken(user.ID, user.Email, user.Username)
 	if err != nil {
-		log.Printf("Błąd generowania tokena: %v", err)
+		logError(r, "Błąd generowania tokena", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
 
+	// Urządzenie rejestracji jest znane — logowanie z niego nie wywoła powiadomienia
+	account, ip := loginSubjects(r, user.Email)
+	h.recordLogin(r, &models.LoginEvent{
+		UserID:    &user.ID,
+		Email:     account,
+		IPAddress: ip,
+		UserAgent: userAgent(r),
+		Success:   true,
+		Reason:    models.LoginRegistered,
+	})
+
 	sendJSON(w, http.StatusCreated, models.AuthResponse{
 		Token: token,
-		User:  user,
+		User:  *user,
 	})
 }
 
@@ -97,41 +116,77 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	account, ip := loginSubjects(r, req.Email)
+	// CreatedAt nadpisuje zapis historii; zostaje, gdy zapis się nie uda (treść powiadomienia)
+	event := models.LoginEvent{Email: account, IPAddress: ip, UserAgent: userAgent(r), CreatedAt: time.Now()}
+
 	// Pobranie użytkownika z bazy
-	var user models.User
-	err := h.db.QueryRow(
-		`SELECT id, email, username, password_hash, created_at, updated_at 
-		 FROM users WHERE email = $1`,
-		req.Email,
-	).Scan(&user.ID, &user.Email, &user.Username, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt)
-
-	if err == sql.ErrNoRows {
-		sendError(w, http.StatusUnauthorized, "Nieprawidłowy email lub hasło")
+	user, err := h.users.GetByEmail(r.Context(), req.Email)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		logError(r, "Błąd pobierania użytkownika", err)
+		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
+	if user != nil {
+		event.UserID = &user.ID
+	}
+
+	// Próba jest liczona przed sprawdzeniem hasła; przy blokadzie konta lub adresu
+	// hasło nie jest sprawdzane. Liczniki istnieją także dla nieznanych adresów
+	// email, więc blokada nie zdradza, czy konto istnieje.
+	until, ipAttempt, err := h.reserveAttempts(r, account, ip)
 	if err != nil {
-		log.Printf("Błąd pobierania użytkownika: %v", err)
+		logError(r, "Błąd sprawdzania blokady logowania", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
+	if !until.IsZero() {
+		event.Reason = models.LoginLocked
+		h.recordLogin(r, &event)
+		sendLocked(w, until)
+		return
+	}
 
-	// Weryfikacja hasła
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+	// Weryfikacja hasła — nieznane konto też przechodzi przez bcrypt
+	hash := dummyPasswordHash
+	if user != nil {
+		hash = user.PasswordHash
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil || user == nil {
+		event.Reason = models.LoginBadPassword
+		if user == nil {
+			event.Reason = models.LoginUnknownAccount
+		}
+		h.recordLogin(r, &event)
 		sendError(w, http.StatusUnauthorized, "Nieprawidłowy email lub hasło")
 		return
 	}
 
+	// Udane logowanie kasuje licznik konta; z licznika adresu IP znika tylko ta
+	// próba, żeby zalogowanie się na własne konto nie zerowało prób na cudzych
+	if err := h.logins.ResetAttempts(r.Context(), repository.ScopeAccount, account); err != nil {
+		logError(r, "Błąd zerowania licznika logowań", err)
+	}
+	h.refundAttempt(r, repository.ScopeIP, ip, ipAttempt)
+	event.Success = true
+	event.Reason = models.LoginOK
+	event.NewDevice = h.isNewDevice(r, user.ID, event.UserAgent)
+	h.recordLogin(r, &event)
+	if event.NewDevice {
+		h.notifyNewDevice(r, *user, event)
+	}
+
 	// Generowanie tokena JWT
 	token, err := auth.GenerateToken(user.ID, user.Email, user.Username)
 	if err != nil {
-		log.Printf("Błąd generowania tokena: %v", err)
+		logError(r, "Błąd generowania tokena", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
 
 	sendJSON(w, http.StatusOK, models.AuthResponse{
 		Token: token,
-		User:  user,
+		User:  *user,
 	})
 }
 
@@ -142,14 +197,9 @@ func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user models.User
-	err := h.db.QueryRow(
-		`SELECT id, email, username, created_at, updated_at FROM users WHERE id = $1`,
-		claims.UserID,
-	).Scan(&user.ID, &user.Email, &user.Username, &user.CreatedAt, &user.UpdatedAt)
-
+	user, err := h.users.GetByID(r.Context(), claims.UserID)
 	if err != nil {
-		log.Printf("Błąd pobierania użytkownika: %v", err)
+		logError(r, "Błąd pobierania użytkownika", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
@@ -205,6 +255,11 @@ func sendError(w http.ResponseWriter, status int, message string) {
 	sendJSON(w, status, models.ErrorResponse{Error: message})
 }
 
+// logError — błąd obsługi żądania w logu z polami korelacyjnymi (request_id, user_id)
+func logError(r *http.Request, msg string, err error) {
+	logging.FromContext(r.Context()).Error(msg, "error", err)
+}
+
 func decodeJSON(r *http.Request, v interface{}) error {
 	return json.NewDecoder(r.Body).Decode(v)
 }
@@ -1,21 +1,36 @@
 package handlers
 
 import (
-	"database/sql"
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"kodama-backend/internal/config"
+	"kodama-backend/internal/metrics"
 	"kodama-backend/internal/models"
+	"kodama-backend/internal/repository"
+	"kodama-backend/internal/signaling"
 
 	"github.com/gorilla/mux"
 )
 
+// maxAttachments — limit załączników jednej wiadomości
+const maxAttachments = 10
+
 type ChannelHandler struct {
-	db    *sql.DB
-	voice *VoiceState
+	cfg      *config.Config
+	servers  repository.ServerRepository
+	members  repository.MemberRepository
+	channels repository.ChannelRepository
+	messages repository.MessageRepository
+	voice    *VoiceState
 }
 
 // VoiceState — stan kanałów głosowych w pamięci (single source of truth)
@@ -34,35 +49,42 @@ func NewVoiceState() *VoiceState {
 	}
 }
 
-func NewChannelHandler(db *sql.DB, voice *VoiceState) *ChannelHandler {
-	return &ChannelHandler{db: db, voice: voice}
+func NewChannelHandler(cfg *config.Config, store *repository.Store, voice *VoiceState) *ChannelHandler {
+	return &ChannelHandler{
+		cfg:      cfg,
+		servers:  store.Servers,
+		members:  store.Members,
+		channels: store.Channels,
+		messages: store.Messages,
+		voice:    voice,
+	}
 }
 
 // requireServerMembership sprawdza czy użytkownik jest członkiem serwera
-func (h *ChannelHandler) requireServerMembership(userID, serverID int) bool {
-	var exists bool
-	h.db.QueryRow(
-		`SELECT EXISTS(SELECT 1 FROM server_members WHERE server_id = $1 AND user_id = $2)`,
-		serverID, userID,
-	).Scan(&exists)
-	return exists
+func (h *ChannelHandler) requireServerMembership(ctx context.Context, userID, serverID int) bool {
+	return memberRole(ctx, h.members, userID, serverID) != ""
 }
 
 // requireServerOwnership sprawdza czy użytkownik jest właścicielem serwera
-func (h *ChannelHandler) requireServerOwnership(userID, serverID int) bool {
-	var ownerID int
-	err := h.db.QueryRow(`SELECT owner_id FROM servers WHERE id = $1`, serverID).Scan(&ownerID)
+func (h *ChannelHandler) requireServerOwnership(ctx context.Context, userID, serverID int) bool {
+	ownerID, err := h.servers.OwnerID(ctx, serverID)
 	if err != nil {
 		return false
 	}
 	return ownerID == userID
 }
 
+// requireServerModerator sprawdza czy użytkownik jest właścicielem lub moderatorem serwera
+func (h *ChannelHandler) requireServerModerator(ctx context.Context, userID, serverID int) bool {
+	role := memberRole(ctx, h.members, userID, serverID)
+	return role == "owner" || role == "moderator"
+}
+
 // ──────────────────────────────────────────────
 // Kanały — CRUD
 // ──────────────────────────────────────────────
 
-// CreateChannel — tworzenie kanału tekstowego lub głosowego
+// CreateChannel — tworzenie kanału tekstowego, głosowego lub kategorii
 func (h *ChannelHandler) CreateChannel(w http.ResponseWriter, r *http.Request) {
 	claims, ok := getClaims(r)
 	if !ok {
@@ -77,7 +99,7 @@ func (h *ChannelHandler) CreateChannel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !h.requireServerMembership(claims.UserID, serverID) {
+	if !h.requireServerMembership(r.Context(), claims.UserID, serverID) {
 		sendError(w, http.StatusForbidden, "Nie jesteś członkiem tego serwera")
 		return
 	}
@@ -99,21 +121,32 @@ func (h *ChannelHandler) CreateChannel(w http.ResponseWriter, r *http.Request) {
 	}
 
 	req.Type = strings.TrimSpace(strings.ToLower(req.Type))
-	if req.Type != "text" && req.Type != "voice" {
-		sendError(w, http.StatusBadRequest, "Typ kanału musi być 'text' lub 'voice'")
+	if req.Type != "text" && !isVoiceChannelType(req.Type) && req.Type != "category" {
+		sendError(w, http.StatusBadRequest, "Typ kanału musi być 'text', 'voice', 'stage' lub 'category'")
 		return
 	}
 
-	var channel models.Channel
-	err = h.db.QueryRow(
-		`INSERT INTO channels (server_id, name, type)
-		 VALUES ($1, $2, $3)
-		 RETURNING id, server_id, name, type, created_at, updated_at`,
-		serverID, req.Name, req.Type,
-	).Scan(&channel.ID, &channel.ServerID, &channel.Name, &channel.Type, &channel.CreatedAt, &channel.UpdatedAt)
+	if req.ParentID != nil {
+		if req.Type == "category" {
+			sendError(w, http.StatusBadRequest, "Kategoria nie może należeć do innej kategorii")
+			return
+		}
+		if !h.isCategoryOfServer(r.Context(), *req.ParentID, serverID) {
+			sendError(w, http.StatusBadRequest, "Nieprawidłowa kategoria nadrzędna")
+			return
+		}
+	}
+
+	// Scena działa tylko przez SFU — serwer musi móc zatrzymać dźwięk słuchaczy
+	voiceMode := "mesh"
+	if req.Type == "stage" {
+		voiceMode = "sfu"
+	}
 
+	// Nowy kanał trafia na koniec listy
+	channel, err := h.channels.Create(r.Context(), serverID, req.Name, req.Type, req.ParentID, voiceMode)
 	if err != nil {
-		log.Printf("Błąd tworzenia kanału: %v", err)
+		logError(r, "Błąd tworzenia kanału", err)
 		sendError(w, http.StatusInternalServerError, "Nie można utworzyć kanału")
 		return
 	}
@@ -136,37 +169,248 @@ func (h *ChannelHandler) ListChannels(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !h.requireServerMembership(claims.UserID, serverID) {
+	if !h.requireServerMembership(r.Context(), claims.UserID, serverID) {
 		sendError(w, http.StatusForbidden, "Nie jesteś członkiem tego serwera")
 		return
 	}
 
-	rows, err := h.db.Query(
-		`SELECT id, server_id, name, type, created_at, updated_at
-		 FROM channels WHERE server_id = $1
-		 ORDER BY type ASC, created_at ASC`,
-		serverID,
-	)
+	channels, err := h.channels.ListByServer(r.Context(), serverID)
 	if err != nil {
-		log.Printf("Błąd pobierania kanałów: %v", err)
+		logError(r, "Błąd pobierania kanałów", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
-	defer rows.Close()
 
-	channels := []models.Channel{}
-	for rows.Next() {
-		var ch models.Channel
-		if err := rows.Scan(&ch.ID, &ch.ServerID, &ch.Name, &ch.Type, &ch.CreatedAt, &ch.UpdatedAt); err != nil {
-			log.Printf("Błąd skanowania kanału: %v", err)
-			continue
-		}
-		channels = append(channels, ch)
+	sendJSON(w, http.StatusOK, channels)
+}
+
+// isCategoryOfServer sprawdza czy kanał jest kategorią w danym serwerze
+func (h *ChannelHandler) isCategoryOfServer(ctx context.Context, channelID, serverID int) bool {
+	ch, err := h.channels.Get(ctx, serverID, channelID)
+	return err == nil && ch.Type == "category"
+}
+
+// UpdateChannelPositions — zmiana kolejności i kategorii wielu kanałów w jednej transakcji
+func (h *ChannelHandler) UpdateChannelPositions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := getClaims(r)
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Brak autoryzacji")
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverID, err := strconv.Atoi(vars["serverId"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe ID serwera")
+		return
+	}
+
+	if !h.requireServerOwnership(r.Context(), claims.UserID, serverID) {
+		sendError(w, http.StatusForbidden, "Tylko właściciel serwera może zmieniać kolejność kanałów")
+		return
+	}
+
+	var req []models.ChannelPosition
+	if err := decodeJSON(r, &req); err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe dane wejściowe")
+		return
+	}
+	if len(req) == 0 {
+		sendError(w, http.StatusBadRequest, "Lista pozycji jest pusta")
+		return
+	}
+
+	// Walidacja na typach kanałów z chwili zapisu (repozytorium blokuje je do końca operacji)
+	err = h.channels.UpdatePositions(r.Context(), serverID, req, func(types map[int]string) error {
+		return validateChannelPositions(req, types)
+	})
+	var verr *validationError
+	if errors.As(err, &verr) {
+		sendError(w, http.StatusBadRequest, verr.Error())
+		return
+	}
+	if err != nil {
+		logError(r, "Błąd aktualizacji pozycji kanałów", err)
+		sendError(w, http.StatusInternalServerError, "Nie można zmienić kolejności kanałów")
+		return
+	}
+
+	channels, err := h.channels.ListByServer(r.Context(), serverID)
+	if err != nil {
+		logError(r, "Błąd pobierania kanałów", err)
+		sendError(w, http.StatusInternalServerError, "Błąd serwera")
+		return
 	}
 
 	sendJSON(w, http.StatusOK, channels)
 }
 
+// validateChannelPositions sprawdza pozycje względem typów kanałów serwera (id -> type)
+func validateChannelPositions(req []models.ChannelPosition, types map[int]string) error {
+	seen := make(map[int]bool)
+	for _, p := range req {
+		chType, ok := types[p.ID]
+		if !ok {
+			return &validationError{"Kanał " + strconv.Itoa(p.ID) + " nie należy do tego serwera"}
+		}
+		if seen[p.ID] {
+			return &validationError{"Kanał " + strconv.Itoa(p.ID) + " występuje wielokrotnie"}
+		}
+		seen[p.ID] = true
+		if p.Position < 0 {
+			return &validationError{"Pozycja kanału nie może być ujemna"}
+		}
+		if p.ParentID != nil {
+			if chType == "category" {
+				return &validationError{"Kategoria nie może należeć do innej kategorii"}
+			}
+			if types[*p.ParentID] != "category" {
+				return &validationError{"Nieprawidłowa kategoria nadrzędna"}
+			}
+		}
+	}
+	return nil
+}
+
+// UpdateChannel — zmiana nazwy i ustawień kanału (właściciel lub moderator)
+func (h *ChannelHandler) UpdateChannel(w http.ResponseWriter, r *http.Request) {
+	claims, ok := getClaims(r)
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Brak autoryzacji")
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverID, err := strconv.Atoi(vars["serverId"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe ID serwera")
+		return
+	}
+	channelID, err := strconv.Atoi(vars["channelId"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe ID kanału")
+		return
+	}
+
+	if !h.requireServerModerator(r.Context(), claims.UserID, serverID) {
+		sendError(w, http.StatusForbidden, "Tylko właściciel lub moderator może edytować kanały")
+		return
+	}
+
+	existing, err := h.channels.Get(r.Context(), serverID, channelID)
+	if errors.Is(err, repository.ErrNotFound) {
+		sendError(w, http.StatusNotFound, "Kanał nie znaleziony")
+		return
+	}
+	if err != nil {
+		logError(r, "Błąd pobierania kanału", err)
+		sendError(w, http.StatusInternalServerError, "Błąd serwera")
+		return
+	}
+
+	var req models.UpdateChannelRequest
+	if err := decodeJSON(r, &req); err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe dane wejściowe")
+		return
+	}
+
+	if err := validateUpdateChannelRequest(&req, existing.Type); err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	channel, err := h.channels.Update(r.Context(), serverID, channelID, &req)
+	if err != nil {
+		logError(r, "Błąd aktualizacji kanału", err)
+		sendError(w, http.StatusInternalServerError, "Nie można zaktualizować kanału")
+		return
+	}
+
+	sendJSON(w, http.StatusOK, channel)
+}
+
+// validateUpdateChannelRequest sprawdza zakresy pól i czy pasują do typu kanału
+func validateUpdateChannelRequest(req *models.UpdateChannelRequest, chType string) error {
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if len(name) < 1 || len(name) > 100 {
+			return &validationError{"Nazwa kanału musi mieć od 1 do 100 znaków"}
+		}
+		req.Name = &name
+	}
+
+	if chType == "category" &&
+		(req.Topic != nil || req.Slowmode != nil || req.NSFW != nil || req.Bitrate != nil || req.UserLimit != nil ||
+			req.VoiceMode != nil || req.VideoLimit != nil || req.VideoBitrate != nil) {
+		return &validationError{"Kategoria ma tylko nazwę"}
+	}
+
+	if req.Topic != nil {
+		topic := strings.TrimSpace(*req.Topic)
+		if len(topic) > 1024 {
+			return &validationError{"Temat kanału nie może przekraczać 1024 znaków"}
+		}
+		req.Topic = &topic
+	}
+	if req.Slowmode != nil {
+		if chType != "text" {
+			return &validationError{"Slowmode dotyczy tylko kanałów tekstowych"}
+		}
+		if *req.Slowmode < 0 || *req.Slowmode > 21600 {
+			return &validationError{"Slowmode musi mieścić się w zakresie 0–21600 sekund"}
+		}
+	}
+	if req.Bitrate != nil {
+		if !isVoiceChannelType(chType) {
+			return &validationError{"Bitrate dotyczy tylko kanałów głosowych"}
+		}
+		if *req.Bitrate < 8000 || *req.Bitrate > 128000 {
+			return &validationError{"Bitrate musi mieścić się w zakresie 8000–128000 b/s"}
+		}
+	}
+	if req.UserLimit != nil {
+		if !isVoiceChannelType(chType) {
+			return &validationError{"Limit użytkowników dotyczy tylko kanałów głosowych"}
+		}
+		if *req.UserLimit < 0 || *req.UserLimit > 99 {
+			return &validationError{"Limit użytkowników musi mieścić się w zakresie 0–99"}
+		}
+	}
+	// Limit wideo dotyczy kolejnych ścieżek — obecni nadawcy nie są rozłączani
+	if req.VideoLimit != nil {
+		if !isVoiceChannelType(chType) {
+			return &validationError{"Limit wideo dotyczy tylko kanałów głosowych"}
+		}
+		if *req.VideoLimit < 0 || *req.VideoLimit > 25 {
+			return &validationError{"Limit wideo musi mieścić się w zakresie 0–25"}
+		}
+	}
+	if req.VideoBitrate != nil {
+		if !isVoiceChannelType(chType) {
+			return &validationError{"Bitrate wideo dotyczy tylko kanałów głosowych"}
+		}
+		if *req.VideoBitrate < 100000 || *req.VideoBitrate > 8000000 {
+			return &validationError{"Bitrate wideo musi mieścić się w zakresie 100000–8000000 b/s"}
+		}
+	}
+	// Zmiana trybu obowiązuje od kolejnego utworzenia pokoju (gdy kanał opustoszeje)
+	if req.VoiceMode != nil {
+		if !isVoiceChannelType(chType) {
+			return &validationError{"Tryb głosowy dotyczy tylko kanałów głosowych"}
+		}
+		mode := strings.TrimSpace(strings.ToLower(*req.VoiceMode))
+		if mode != "mesh" && mode != "sfu" {
+			return &validationError{"Tryb głosowy musi być 'mesh' lub 'sfu'"}
+		}
+		if chType == "stage" && mode != "sfu" {
+			return &validationError{"Kanał sceniczny działa tylko w trybie 'sfu'"}
+		}
+		req.VoiceMode = &mode
+	}
+
+	return nil
+}
+
 // DeleteChannel — usunięcie kanału (tylko właściciel serwera)
 func (h *ChannelHandler) DeleteChannel(w http.ResponseWriter, r *http.Request) {
 	claims, ok := getClaims(r)
@@ -187,18 +431,13 @@ func (h *ChannelHandler) DeleteChannel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !h.requireServerOwnership(claims.UserID, serverID) {
+	if !h.requireServerOwnership(r.Context(), claims.UserID, serverID) {
 		sendError(w, http.StatusForbidden, "Tylko właściciel serwera może usuwać kanały")
 		return
 	}
 
 	// Sprawdź czy kanał należy do serwera
-	var exists bool
-	h.db.QueryRow(
-		`SELECT EXISTS(SELECT 1 FROM channels WHERE id = $1 AND server_id = $2)`,
-		channelID, serverID,
-	).Scan(&exists)
-	if !exists {
+	if _, err := h.channels.Get(r.Context(), serverID, channelID); err != nil {
 		sendError(w, http.StatusNotFound, "Kanał nie znaleziony")
 		return
 	}
@@ -213,9 +452,8 @@ func (h *ChannelHandler) DeleteChannel(w http.ResponseWriter, r *http.Request) {
 	}
 	h.voice.mu.Unlock()
 
-	_, err = h.db.Exec(`DELETE FROM channels WHERE id = $1`, channelID)
-	if err != nil {
-		log.Printf("Błąd usuwania kanału: %v", err)
+	if err := h.channels.Delete(r.Context(), channelID); err != nil {
+		logError(r, "Błąd usuwania kanału", err)
 		sendError(w, http.StatusInternalServerError, "Nie można usunąć kanału")
 		return
 	}
@@ -247,22 +485,23 @@ func (h *ChannelHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !h.requireServerMembership(claims.UserID, serverID) {
+	if !h.requireServerMembership(r.Context(), claims.UserID, serverID) {
 		sendError(w, http.StatusForbidden, "Nie jesteś członkiem tego serwera")
 		return
 	}
 
 	// Sprawdź czy kanał jest tekstowy i należy do serwera
-	var chType string
-	err = h.db.QueryRow(
-		`SELECT type FROM channels WHERE id = $1 AND server_id = $2`,
-		channelID, serverID,
-	).Scan(&chType)
-	if err == sql.ErrNoRows {
+	channel, err := h.channels.Get(r.Context(), serverID, channelID)
+	if errors.Is(err, repository.ErrNotFound) {
 		sendError(w, http.StatusNotFound, "Kanał nie znaleziony")
 		return
 	}
-	if chType != "text" {
+	if err != nil {
+		logError(r, "Błąd pobierania kanału", err)
+		sendError(w, http.StatusInternalServerError, "Błąd serwera")
+		return
+	}
+	if channel.Type != "text" {
 		sendError(w, http.StatusBadRequest, "To nie jest kanał tekstowy")
 		return
 	}
@@ -275,56 +514,22 @@ func (h *ChannelHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	var rows *sql.Rows
-	beforeID := r.URL.Query().Get("before")
-	if beforeID != "" {
-		bid, err := strconv.Atoi(beforeID)
+	beforeID := 0
+	if before := r.URL.Query().Get("before"); before != "" {
+		beforeID, err = strconv.Atoi(before)
 		if err != nil {
 			sendError(w, http.StatusBadRequest, "Nieprawidłowy parametr 'before'")
 			return
 		}
-		rows, err = h.db.Query(
-			`SELECT m.id, m.channel_id, m.user_id, u.username, m.content, m.created_at
-			 FROM messages m
-			 JOIN users u ON u.id = m.user_id
-			 WHERE m.channel_id = $1 AND m.id < $2
-			 ORDER BY m.created_at DESC
-			 LIMIT $3`,
-			channelID, bid, limit,
-		)
-	} else {
-		rows, err = h.db.Query(
-			`SELECT m.id, m.channel_id, m.user_id, u.username, m.content, m.created_at
-			 FROM messages m
-			 JOIN users u ON u.id = m.user_id
-			 WHERE m.channel_id = $1
-			 ORDER BY m.created_at DESC
-			 LIMIT $2`,
-			channelID, limit,
-		)
 	}
 
+	// Najstarsze pierwsze
+	messages, err := h.messages.List(r.Context(), channelID, beforeID, limit)
 	if err != nil {
-		log.Printf("Błąd pobierania wiadomości: %v", err)
+		logError(r, "Błąd pobierania wiadomości", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
-	defer rows.Close()
-
-	messages := []models.Message{}
-	for rows.Next() {
-		var m models.Message
-		if err := rows.Scan(&m.ID, &m.ChannelID, &m.UserID, &m.Username, &m.Content, &m.CreatedAt); err != nil {
-			log.Printf("Błąd skanowania wiadomości: %v", err)
-			continue
-		}
-		messages = append(messages, m)
-	}
-
-	// Odwróć kolejność — najstarsze pierwsze
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
-	}
 
 	sendJSON(w, http.StatusOK, messages)
 }
@@ -349,22 +554,23 @@ func (h *ChannelHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !h.requireServerMembership(claims.UserID, serverID) {
+	if !h.requireServerMembership(r.Context(), claims.UserID, serverID) {
 		sendError(w, http.StatusForbidden, "Nie jesteś członkiem tego serwera")
 		return
 	}
 
 	// Sprawdź czy kanał tekstowy i należy do serwera
-	var chType string
-	err = h.db.QueryRow(
-		`SELECT type FROM channels WHERE id = $1 AND server_id = $2`,
-		channelID, serverID,
-	).Scan(&chType)
-	if err == sql.ErrNoRows {
+	channel, err := h.channels.Get(r.Context(), serverID, channelID)
+	if errors.Is(err, repository.ErrNotFound) {
 		sendError(w, http.StatusNotFound, "Kanał nie znaleziony")
 		return
 	}
-	if chType != "text" {
+	if err != nil {
+		logError(r, "Błąd pobierania kanału", err)
+		sendError(w, http.StatusInternalServerError, "Błąd serwera")
+		return
+	}
+	if channel.Type != "text" {
 		sendError(w, http.StatusBadRequest, "To nie jest kanał tekstowy")
 		return
 	}
@@ -376,7 +582,7 @@ func (h *ChannelHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	req.Content = strings.TrimSpace(req.Content)
-	if req.Content == "" {
+	if req.Content == "" && len(req.Attachments) == 0 {
 		sendError(w, http.StatusBadRequest, "Treść wiadomości jest wymagana")
 		return
 	}
@@ -384,26 +590,61 @@ func (h *ChannelHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 		sendError(w, http.StatusBadRequest, "Wiadomość nie może przekraczać 2000 znaków")
 		return
 	}
+	if err := validateAttachments(req.Attachments); err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	var msg models.Message
-	err = h.db.QueryRow(
-		`INSERT INTO messages (channel_id, user_id, content)
-		 VALUES ($1, $2, $3)
-		 RETURNING id, channel_id, user_id, content, created_at`,
-		channelID, claims.UserID, req.Content,
-	).Scan(&msg.ID, &msg.ChannelID, &msg.UserID, &msg.Content, &msg.CreatedAt)
+	// Slowmode — moderatorzy nie podlegają ograniczeniu. Odstęp sprawdza repozytorium
+	// w transakcji zapisu, więc równoległe żądania nie ominą limitu.
+	var slowmode time.Duration
+	if channel.Slowmode > 0 && !h.requireServerModerator(r.Context(), claims.UserID, serverID) {
+		slowmode = time.Duration(channel.Slowmode) * time.Second
+	}
 
+	msg, err := h.messages.Create(r.Context(), channelID, claims.UserID, req.Content, req.Attachments, slowmode)
+	var slow *repository.SlowmodeError
+	if errors.As(err, &slow) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(slow.Wait.Seconds()))))
+		sendError(w, http.StatusTooManyRequests, "Kanał ma włączony slowmode — poczekaj przed wysłaniem kolejnej wiadomości")
+		return
+	}
 	if err != nil {
-		log.Printf("Błąd wysyłania wiadomości: %v", err)
+		logError(r, "Błąd wysyłania wiadomości", err)
 		sendError(w, http.StatusInternalServerError, "Nie można wysłać wiadomości")
 		return
 	}
-
-	msg.Username = claims.Username
+	metrics.MessagesSent.Inc()
 
 	sendJSON(w, http.StatusCreated, msg)
 }
 
+// validateAttachments — załączniki są już wysłane (URL http/https); serwer zapisuje
+// tylko ich opis
+func validateAttachments(attachments []models.Attachment) error {
+	if len(attachments) > maxAttachments {
+		return &validationError{fmt.Sprintf("Wiadomość może mieć najwyżej %d załączników", maxAttachments)}
+	}
+	for i := range attachments {
+		a := &attachments[i]
+		a.Filename = strings.TrimSpace(a.Filename)
+		if a.Filename == "" || len(a.Filename) > 255 {
+			return &validationError{"Nazwa załącznika musi mieć od 1 do 255 znaków"}
+		}
+		u, err := url.Parse(a.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &validationError{"Adres załącznika musi być URL-em http lub https"}
+		}
+		if len(a.ContentType) > 100 {
+			return &validationError{"Typ załącznika nie może przekraczać 100 znaków"}
+		}
+		if a.Size < 0 {
+			return &validationError{"Nieprawidłowy rozmiar załącznika"}
+		}
+	}
+	return nil
+}
+
 // ──────────────────────────────────────────────
 // Kanały głosowe
 // ──────────────────────────────────────────────
@@ -428,29 +669,46 @@ func (h *ChannelHandler) JoinVoiceChannel(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if !h.requireServerMembership(claims.UserID, serverID) {
+	if !h.requireServerMembership(r.Context(), claims.UserID, serverID) {
 		sendError(w, http.StatusForbidden, "Nie jesteś członkiem tego serwera")
 		return
 	}
 
 	// Sprawdź czy kanał głosowy i należy do serwera
-	var chType string
-	err = h.db.QueryRow(
-		`SELECT type FROM channels WHERE id = $1 AND server_id = $2`,
-		channelID, serverID,
-	).Scan(&chType)
-	if err == sql.ErrNoRows {
+	channel, err := h.channels.Get(r.Context(), serverID, channelID)
+	if errors.Is(err, repository.ErrNotFound) {
 		sendError(w, http.StatusNotFound, "Kanał nie znaleziony")
 		return
 	}
-	if chType != "voice" {
+	if err != nil {
+		logError(r, "Błąd pobierania kanału", err)
+		sendError(w, http.StatusInternalServerError, "Błąd serwera")
+		return
+	}
+	if !isVoiceChannelType(channel.Type) {
 		sendError(w, http.StatusBadRequest, "To nie jest kanał głosowy")
 		return
 	}
 
+	// Uprawnienie "move members" pozwala wejść na pełny kanał
+	userLimit := channel.UserLimit
+	bypassLimit := userLimit > 0 && hasPermission(r.Context(), h.members, claims.UserID, serverID, PermMoveMembers)
+	stageRole := initialStageRole(channel.Type, memberRole(r.Context(), h.members, claims.UserID, serverID))
+
 	h.voice.mu.Lock()
 	defer h.voice.mu.Unlock()
 
+	if userLimit > 0 && !bypassLimit {
+		others := len(h.voice.channels[channelID])
+		if _, already := h.voice.channels[channelID][claims.UserID]; already {
+			others--
+		}
+		if others >= userLimit {
+			sendError(w, http.StatusForbidden, "Kanał głosowy jest pełny")
+			return
+		}
+	}
+
 	// Jeśli użytkownik jest już na innym kanale — opuść go
 	if oldChannelID, exists := h.voice.userChannel[claims.UserID]; exists {
 		if participants, ok := h.voice.channels[oldChannelID]; ok {
@@ -467,9 +725,11 @@ func (h *ChannelHandler) JoinVoiceChannel(w http.ResponseWriter, r *http.Request
 	}
 
 	participant := &models.VoiceParticipant{
-		UserID:   claims.UserID,
-		Username: claims.Username,
-		Muted:    false,
+		UserID:    claims.UserID,
+		Username:  claims.Username,
+		Muted:     stageRole == signaling.StageAudience,
+		JoinedAt:  time.Now().UTC(),
+		StageRole: stageRole,
 	}
 	h.voice.channels[channelID][claims.UserID] = participant
 	h.voice.userChannel[claims.UserID] = channelID
@@ -527,7 +787,7 @@ func (h *ChannelHandler) GetVoiceParticipants(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	if !h.requireServerMembership(claims.UserID, serverID) {
+	if !h.requireServerMembership(r.Context(), claims.UserID, serverID) {
 		sendError(w, http.StatusForbidden, "Nie jesteś członkiem tego serwera")
 		return
 	}
@@ -539,39 +799,6 @@ func (h *ChannelHandler) GetVoiceParticipants(w http.ResponseWriter, r *http.Req
 	sendJSON(w, http.StatusOK, participants)
 }
 
-// ToggleMute — wycisz/odcisz mikrofon
-func (h *ChannelHandler) ToggleMute(w http.ResponseWriter, r *http.Request) {
-	claims, ok := getClaims(r)
-	if !ok {
-		sendError(w, http.StatusUnauthorized, "Brak autoryzacji")
-		return
-	}
-
-	var req models.MuteRequest
-	if err := decodeJSON(r, &req); err != nil {
-		sendError(w, http.StatusBadRequest, "Nieprawidłowe dane wejściowe")
-		return
-	}
-
-	h.voice.mu.Lock()
-	defer h.voice.mu.Unlock()
-
-	channelID, exists := h.voice.userChannel[claims.UserID]
-	if !exists {
-		sendError(w, http.StatusBadRequest, "Nie jesteś na żadnym kanale głosowym")
-		return
-	}
-
-	if participants, ok := h.voice.channels[channelID]; ok {
-		if p, ok := participants[claims.UserID]; ok {
-			p.Muted = req.Muted
-		}
-	}
-
-	participants := h.getParticipantsList(channelID)
-	sendJSON(w, http.StatusOK, participants)
-}
-
 // GetMyVoiceState — pobierz aktualny stan głosowy użytkownika
 func (h *ChannelHandler) GetMyVoiceState(w http.ResponseWriter, r *http.Request) {
 	claims, ok := getClaims(r)
@@ -585,24 +812,19 @@ func (h *ChannelHandler) GetMyVoiceState(w http.ResponseWriter, r *http.Request)
 
 	channelID, exists := h.voice.userChannel[claims.UserID]
 	if !exists {
-		sendJSON(w, http.StatusOK, map[string]interface{}{
-			"in_channel": false,
-		})
+		sendJSON(w, http.StatusOK, models.MyVoiceStateResponse{InChannel: false})
 		return
 	}
 
-	var muted bool
+	resp := models.MyVoiceStateResponse{InChannel: true, ChannelID: channelID}
 	if participants, ok := h.voice.channels[channelID]; ok {
 		if p, ok := participants[claims.UserID]; ok {
-			muted = p.Muted
+			state := *p
+			resp.VoiceParticipant = &state
 		}
 	}
 
-	sendJSON(w, http.StatusOK, map[string]interface{}{
-		"in_channel": true,
-		"channel_id": channelID,
-		"muted":      muted,
-	})
+	sendJSON(w, http.StatusOK, resp)
 }
 
 // helper
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"kodama-backend/internal/config"
+	"kodama-backend/internal/database"
+	"kodama-backend/internal/logging"
+)
+
+// ──────────────────────────────────────────────
+// Sondy liveness i readiness (Docker Compose, Kubernetes)
+// ──────────────────────────────────────────────
+
+type HealthHandler struct {
+	db       *sql.DB
+	hub      *SignalingHub
+	timeout  time.Duration
+	draining atomic.Bool // serwer jest zamykany — instancja nie przyjmuje nowego ruchu
+}
+
+func NewHealthHandler(cfg *config.Config, db *sql.DB, hub *SignalingHub) *HealthHandler {
+	return &HealthHandler{db: db, hub: hub, timeout: cfg.HealthCheckTimeout}
+}
+
+// healthCheck — wynik sprawdzenia jednej zależności
+type healthCheck struct {
+	Status    string  `json:"status"` // "ok" albo "fail"
+	Error     string  `json:"error,omitempty"`
+	LatencyMS float64 `json:"latency_ms,omitempty"`
+	// Migracje: najnowsza zastosowana wersja i wersje czekające na zastosowanie
+	Version *int  `json:"version,omitempty"`
+	Pending []int `json:"pending,omitempty"`
+
+	cause error // szczegóły tylko do logu — sonda jest publiczna
+}
+
+type readiness struct {
+	Status    string                 `json:"status"` // "ok" albo "unavailable"
+	Checks    map[string]healthCheck `json:"checks"`
+	Signaling signalingStats         `json:"signaling"`
+}
+
+type signalingStats struct {
+	Rooms        int `json:"rooms"`
+	Participants int `json:"participants"`
+}
+
+// Live — proces działa i obsługuje żądania. Nie sprawdza zależności: restart
+// procesu nie naprawi niedostępnej bazy.
+func (h *HealthHandler) Live(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Drain — od początku zamykania serwera /readyz odpowiada 503, żeby load balancer
+// przestał kierować ruch na tę instancję
+func (h *HealthHandler) Drain() {
+	h.draining.Store(true)
+}
+
+// Ready — instancja może przyjmować ruch: serwer nie jest zamykany, baza odpowiada
+// w limicie czasu, a jej schemat ma wszystkie migracje tej wersji serwera.
+// W przeciwnym razie 503.
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	res := readiness{Status: "ok", Checks: map[string]healthCheck{}}
+	if h.draining.Load() {
+		res.Status = "unavailable"
+		res.Checks["shutdown"] = healthCheck{Status: "fail", Error: "serwer jest zamykany"}
+		res.Signaling.Rooms, res.Signaling.Participants = h.hub.Stats()
+		sendJSON(w, http.StatusServiceUnavailable, res)
+		return
+	}
+	res.Checks["database"] = h.checkDatabase(ctx)
+	if res.Checks["database"].Status == "ok" {
+		res.Checks["migrations"] = h.checkMigrations(ctx)
+	} else {
+		res.Checks["migrations"] = healthCheck{Status: "fail", Error: "baza danych niedostępna"}
+	}
+	res.Signaling.Rooms, res.Signaling.Participants = h.hub.Stats()
+
+	status := http.StatusOK
+	for name, check := range res.Checks {
+		if check.Status != "ok" {
+			res.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+			attrs := []any{"check", name, "error", check.Error}
+			if check.cause != nil {
+				attrs = append(attrs, "cause", check.cause)
+			}
+			logging.FromContext(r.Context()).Warn("Instancja niegotowa", attrs...)
+		}
+	}
+	sendJSON(w, status, res)
+}
+
+func (h *HealthHandler) checkDatabase(ctx context.Context) healthCheck {
+	start := time.Now()
+	if err := h.db.PingContext(ctx); err != nil {
+		return healthCheck{Status: "fail", Error: "brak połączenia z bazą danych", cause: err}
+	}
+	return healthCheck{Status: "ok", LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+}
+
+func (h *HealthHandler) checkMigrations(ctx context.Context) healthCheck {
+	statuses, err := database.MigrationStatuses(ctx, h.db)
+	if err != nil {
+		return healthCheck{Status: "fail", Error: "nie można odczytać stanu migracji", cause: err}
+	}
+	check := healthCheck{Status: "ok"}
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			check.Pending = append(check.Pending, s.Version)
+		} else {
+			v := s.Version
+			check.Version = &v
+		}
+	}
+	if len(check.Pending) > 0 {
+		check.Status = "fail"
+		check.Error = "schemat bazy nie jest aktualny — uruchom migracje"
+	}
+	return check
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestReadyWhileDraining — po rozpoczęciu zamykania /readyz odpowiada 503 bez
+// sprawdzania bazy
+func TestReadyWhileDraining(t *testing.T) {
+	h := &HealthHandler{hub: NewSignalingHub()}
+	h.Drain()
+
+	rec := httptest.NewRecorder()
+	h.Ready(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, oczekiwano 503", rec.Code)
+	}
+	var res readiness
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != "unavailable" || res.Checks["shutdown"].Status != "fail" {
+		t.Errorf("odpowiedź = %+v", res)
+	}
+}
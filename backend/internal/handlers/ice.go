@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+
+	"kodama-backend/internal/auth"
+	"kodama-backend/internal/config"
+	"kodama-backend/internal/models"
+)
+
+// iceServersForUser — serwery STUN z konfiguracji oraz TURN z tymczasowymi
+// danymi logowania wygenerowanymi dla użytkownika
+func iceServersForUser(cfg *config.Config, userID int) models.ICEServersResponse {
+	resp := models.ICEServersResponse{ICEServers: []models.ICEServer{}}
+	if len(cfg.STUNURLs) > 0 {
+		resp.ICEServers = append(resp.ICEServers, models.ICEServer{URLs: cfg.STUNURLs})
+	}
+	if len(cfg.TURNURLs) > 0 && cfg.TURNSecret != "" {
+		username, credential, expiresAt := auth.GenerateTURNCredentials(cfg.TURNSecret, userID, cfg.TURNCredTTL)
+		resp.ICEServers = append(resp.ICEServers, models.ICEServer{
+			URLs:       cfg.TURNURLs,
+			Username:   username,
+			Credential: credential,
+		})
+		resp.ExpiresAt = &expiresAt
+	}
+	return resp
+}
+
+// GetICEServers — konfiguracja ICE dla klienta WebRTC
+func (h *ChannelHandler) GetICEServers(w http.ResponseWriter, r *http.Request) {
+	claims, ok := getClaims(r)
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Brak autoryzacji")
+		return
+	}
+
+	sendJSON(w, http.StatusOK, iceServersForUser(h.cfg, claims.UserID))
+}
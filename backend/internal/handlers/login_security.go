@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"kodama-backend/internal/auth"
+	"kodama-backend/internal/config"
+	"kodama-backend/internal/logging"
+	"kodama-backend/internal/middleware"
+	"kodama-backend/internal/models"
+	"kodama-backend/internal/repository"
+)
+
+// ──────────────────────────────────────────────
+// Blokady logowania i historia logowań
+// ──────────────────────────────────────────────
+
+const (
+	loginHistoryLimit  = 50               // wpisy zwracane przez GET /api/me/logins
+	maxUserAgentLength = 512              // dłuższe nagłówki są przycinane przed zapisem
+	notifyTimeout      = 30 * time.Second // limit czasu wysyłki powiadomienia
+	pruneInterval      = time.Hour        // częstotliwość usuwania wygasłych liczników
+
+	// dummyPasswordHash — porównywany przy nieznanym adresie email, żeby czas
+	// odpowiedzi nie zdradzał, czy konto istnieje (koszt jak bcrypt.DefaultCost)
+	dummyPasswordHash = "$2a$10$7SAKSF5amWWUaPRSnuTF5eIn2VnChQ/7eaAsVGMnwoHzfibi9bLy6"
+)
+
+// lockoutPolicy — progi nieudanych prób i czasy blokad (z konfiguracji)
+type lockoutPolicy struct {
+	maxFailures   int // na konto
+	maxFailuresIP int // z jednego adresu
+	window        time.Duration
+	base          time.Duration
+	max           time.Duration
+}
+
+func newLockoutPolicy(cfg *config.Config) lockoutPolicy {
+	return lockoutPolicy{
+		maxFailures:   cfg.LoginMaxFailures,
+		maxFailuresIP: cfg.LoginMaxFailuresIP,
+		window:        cfg.LoginFailureWindow,
+		base:          cfg.LoginLockout,
+		max:           cfg.LoginLockoutMax,
+	}
+}
+
+// duration — n-ta blokada trwa base * 2^(n-1), najwyżej max
+func (p lockoutPolicy) duration(n int) time.Duration {
+	d := p.base
+	for i := 1; i < n && d < p.max; i++ {
+		d *= 2
+	}
+	return min(d, p.max)
+}
+
+// fail — zlicza nieudaną próbę; po limit próbach w oknie zakłada kolejną blokadę
+func (p lockoutPolicy) fail(a *models.LoginAttempts, limit int, now time.Time) {
+	if now.Sub(a.LastFailureAt) > p.window {
+		a.Failures = 0
+	}
+	// Okres spokoju dłuższy niż najdłuższa blokada — wcześniejsze blokady są zapominane
+	if now.Sub(a.LockedUntil) > p.max {
+		a.Lockouts = 0
+	}
+	a.Failures++
+	a.LastFailureAt = now
+	if a.Failures >= limit {
+		a.Lockouts++
+		a.Failures = 0
+		a.LockedUntil = now.Add(p.duration(a.Lockouts))
+	}
+}
+
+// loginSubjects — klucze liczników: email (bez względu na wielkość liter) i adres IP
+func loginSubjects(r *http.Request, email string) (account, ip string) {
+	return strings.ToLower(strings.TrimSpace(email)), middleware.ClientIP(r).String()
+}
+
+func userAgent(r *http.Request) string {
+	ua := r.UserAgent()
+	if len(ua) > maxUserAgentLength {
+		ua = strings.ToValidUTF8(ua[:maxUserAgentLength], "")
+	}
+	return ua
+}
+
+// attemptReservation — stan licznika sprzed zarezerwowanej próby i numer blokady,
+// którą ta próba założyła (0 = żadnej)
+type attemptReservation struct {
+	before  models.LoginAttempts
+	lockout int
+}
+
+// reserveAttempt — sprawdza blokadę i od razu zlicza próbę, w jednej transakcji
+// na wierszu licznika, jeszcze przed porównaniem hasła. Równoległe żądania nie
+// prześlizgną się więc obok limitu. Zwraca koniec aktywnej blokady (zero = brak);
+// zablokowana próba nie jest liczona.
+func (h *AuthHandler) reserveAttempt(r *http.Request, scope, subject string, limit int, now time.Time) (time.Time, attemptReservation, error) {
+	var until time.Time
+	var res attemptReservation
+	a, err := h.logins.UpdateAttempts(r.Context(), scope, subject, func(a *models.LoginAttempts) {
+		if a.LockedUntil.After(now) {
+			until = a.LockedUntil
+			return
+		}
+		res.before = *a
+		h.lockout.fail(a, limit, now)
+		if !a.LockedUntil.Equal(res.before.LockedUntil) {
+			res.lockout = a.Lockouts
+		}
+	})
+	if err != nil {
+		return time.Time{}, attemptReservation{}, err
+	}
+	if res.lockout > 0 {
+		logging.FromContext(r.Context()).Warn("Blokada logowania",
+			"scope", scope, "subject", subject, "lockouts", a.Lockouts, "locked_until", a.LockedUntil)
+	}
+	return until, res, nil
+}
+
+// reserveAttempts — rezerwuje próbę na adresie IP, potem na koncie. Gdy konto jest
+// zablokowane, próba adresu jest zwracana — hasło i tak nie zostanie sprawdzone.
+// Zwraca rezerwację na adresie IP (do zwrotu po udanym logowaniu).
+func (h *AuthHandler) reserveAttempts(r *http.Request, account, ip string) (time.Time, attemptReservation, error) {
+	now := time.Now()
+	until, ipRes, err := h.reserveAttempt(r, repository.ScopeIP, ip, h.lockout.maxFailuresIP, now)
+	if err != nil || !until.IsZero() {
+		return until, ipRes, err
+	}
+	until, _, err = h.reserveAttempt(r, repository.ScopeAccount, account, h.lockout.maxFailures, now)
+	if err == nil && !until.IsZero() {
+		h.refundAttempt(r, repository.ScopeIP, ip, ipRes)
+	}
+	return until, ipRes, err
+}
+
+// refundAttempt — zwraca zarezerwowaną próbę (udane logowanie, niesprawdzone hasło).
+// Blokada założona przez tę próbę (wciąż ostatnia) jest zdejmowana, a licznik wraca
+// do stanu sprzed niej — inne żądania nie były w tym czasie liczone, bo trafiały
+// na blokadę.
+func (h *AuthHandler) refundAttempt(r *http.Request, scope, subject string, res attemptReservation) {
+	_, err := h.logins.UpdateAttempts(r.Context(), scope, subject, func(a *models.LoginAttempts) {
+		if res.lockout > 0 && a.Lockouts == res.lockout && a.Failures == 0 {
+			*a = res.before
+			return
+		}
+		if a.Failures > 0 {
+			a.Failures--
+		}
+	})
+	if err != nil {
+		logError(r, "Błąd zwrotu próby logowania", err)
+	}
+}
+
+// recordLogin — wpis historii; błąd zapisu nie przerywa logowania
+func (h *AuthHandler) recordLogin(r *http.Request, event *models.LoginEvent) {
+	if err := h.logins.Record(r.Context(), event); err != nil {
+		logError(r, "Błąd zapisu historii logowań", err)
+	}
+}
+
+// isNewDevice — pierwsze udane logowanie z tym user agentem. Konta bez żadnej
+// historii (sprzed jej wprowadzenia) nie dostają powiadomienia.
+func (h *AuthHandler) isNewDevice(r *http.Request, userID int, ua string) bool {
+	sameAgent, total, err := h.logins.Devices(r.Context(), userID, ua)
+	if err != nil {
+		logError(r, "Błąd sprawdzania urządzeń użytkownika", err)
+		return false
+	}
+	return total > 0 && sameAgent == 0
+}
+
+// notifyNewDevice — powiadomienie w tle, żeby wolny serwer pocztowy nie opóźniał logowania
+func (h *AuthHandler) notifyNewDevice(r *http.Request, user models.User, event models.LoginEvent) {
+	if h.notifier == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), notifyTimeout)
+	go func() {
+		defer cancel()
+		if err := h.notifier.NewDeviceLogin(ctx, user, event); err != nil {
+			logging.FromContext(ctx).Error("Błąd powiadomienia o logowaniu z nowego urządzenia", "error", err)
+		}
+	}()
+}
+
+// sendLocked — 429 z Retry-After do końca blokady
+func sendLocked(w http.ResponseWriter, until time.Time) {
+	wait := time.Until(until)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	sendError(w, http.StatusTooManyRequests, fmt.Sprintf(
+		"Zbyt wiele nieudanych prób logowania — spróbuj ponownie za %d min", int(math.Ceil(wait.Minutes()))))
+}
+
+// PruneLoginAttempts co godzinę usuwa liczniki, które nie wpływają już na
+// blokady; kończy się razem z ctx
+func (h *AuthHandler) PruneLoginAttempts(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		before := time.Now().Add(-max(h.lockout.window, h.lockout.max))
+		if n, err := h.logins.PruneAttempts(ctx, before); err != nil {
+			logging.FromContext(ctx).Error("Błąd usuwania liczników logowań", "error", err)
+		} else if n > 0 {
+			logging.FromContext(ctx).Debug("Usunięto wygasłe liczniki logowań", "count", n)
+		}
+	}
+}
+
+// LoginHistory — ostatnie logowania zalogowanego użytkownika (także nieudane próby)
+func (h *AuthHandler) LoginHistory(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("claims").(*auth.Claims)
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Brak autoryzacji")
+		return
+	}
+
+	events, err := h.logins.History(r.Context(), claims.UserID, loginHistoryLimit)
+	if err != nil {
+		logError(r, "Błąd pobierania historii logowań", err)
+		sendError(w, http.StatusInternalServerError, "Błąd serwera")
+		return
+	}
+	sendJSON(w, http.StatusOK, events)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+
+	"kodama-backend/internal/repository"
+)
+
+// ──────────────────────────────────────────────
+// Uprawnienia członków serwera (na podstawie roli)
+// ──────────────────────────────────────────────
+
+// Permission — pojedyncze uprawnienie wynikające z roli w serwerze
+type Permission string
+
+const (
+	// PermMoveMembers — przenoszenie użytkowników między kanałami głosowymi
+	// i dołączanie do pełnych kanałów (omijanie user_limit)
+	PermMoveMembers Permission = "move_members"
+	// PermMuteMembers — wyciszanie użytkowników na kanałach głosowych
+	PermMuteMembers Permission = "mute_members"
+	// PermDeafenMembers — wygłuszanie użytkowników na kanałach głosowych
+	PermDeafenMembers Permission = "deafen_members"
+	// PermRecordVoice — nagrywanie kanałów głosowych i pobieranie nagrań
+	PermRecordVoice Permission = "record_voice"
+	// PermManageStage — zatwierdzanie i odbieranie głosu na kanałach scenicznych
+	// (moderatorzy sceny wchodzą na nią od razu jako mówcy)
+	PermManageStage Permission = "manage_stage"
+)
+
+// rolePermissions — uprawnienia przypisane do ról ("member" nie ma dodatkowych)
+var rolePermissions = map[string][]Permission{
+	"owner":     {PermMoveMembers, PermMuteMembers, PermDeafenMembers, PermRecordVoice, PermManageStage},
+	"moderator": {PermMoveMembers, PermMuteMembers, PermDeafenMembers, PermRecordVoice, PermManageStage},
+}
+
+// roleHasPermission sprawdza czy rola daje dane uprawnienie
+func roleHasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// memberRole zwraca rolę użytkownika w serwerze ("" gdy nie jest członkiem)
+func memberRole(ctx context.Context, members repository.MemberRepository, userID, serverID int) string {
+	role, _ := members.Role(ctx, serverID, userID)
+	return role
+}
+
+// hasPermission sprawdza czy użytkownik ma uprawnienie w serwerze
+func hasPermission(ctx context.Context, members repository.MemberRepository, userID, serverID int, perm Permission) bool {
+	return roleHasPermission(memberRole(ctx, members, userID, serverID), perm)
+}
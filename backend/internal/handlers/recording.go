@@ -0,0 +1,444 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"kodama-backend/internal/logging"
+	"kodama-backend/internal/models"
+	"kodama-backend/internal/sfu"
+	"kodama-backend/internal/signaling"
+
+	"github.com/gorilla/mux"
+)
+
+// ──────────────────────────────────────────────
+// Nagrywanie kanałów głosowych (tryb SFU)
+// ──────────────────────────────────────────────
+
+// recordingTarget — wspólna część start/stop: autoryzacja, uprawnienie i kanał z URL
+func (sh *SignalingHandler) recordingTarget(w http.ResponseWriter, r *http.Request) (int, int, int, bool) {
+	claims, ok := getClaims(r)
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Brak autoryzacji")
+		return 0, 0, 0, false
+	}
+
+	vars := mux.Vars(r)
+	serverID, err := strconv.Atoi(vars["serverId"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe ID serwera")
+		return 0, 0, 0, false
+	}
+	channelID, err := strconv.Atoi(vars["channelId"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe ID kanału")
+		return 0, 0, 0, false
+	}
+
+	if !hasPermission(r.Context(), sh.members, claims.UserID, serverID, PermRecordVoice) {
+		sendError(w, http.StatusForbidden, "Brak uprawnień do nagrywania kanałów głosowych")
+		return 0, 0, 0, false
+	}
+	if !sh.channelBelongsToServer(r.Context(), channelID, serverID) {
+		sendError(w, http.StatusNotFound, "Kanał nie znaleziony")
+		return 0, 0, 0, false
+	}
+
+	return claims.UserID, serverID, channelID, true
+}
+
+// StartRecording — POST /servers/{serverId}/channels/{channelId}/voice/recording/start
+func (sh *SignalingHandler) StartRecording(w http.ResponseWriter, r *http.Request) {
+	userID, serverID, channelID, ok := sh.recordingTarget(w, r)
+	if !ok {
+		return
+	}
+
+	// Serwer nagrywa tylko media, które przez niego przechodzą
+	room, ok := sh.hub.getRoom(channelID)
+	if !ok {
+		sendError(w, http.StatusConflict, "Na kanale nie ma nikogo")
+		return
+	}
+	if room.mode != "sfu" {
+		sendError(w, http.StatusConflict, "Nagrywanie wymaga kanału w trybie SFU")
+		return
+	}
+
+	sh.recMu.Lock()
+	defer sh.recMu.Unlock()
+
+	if _, active := sh.recordings[channelID]; active {
+		sendError(w, http.StatusConflict, "Kanał jest już nagrywany")
+		return
+	}
+
+	rec := models.VoiceRecording{ChannelID: channelID, ServerID: serverID, StartedBy: &userID, Status: "recording"}
+	err := sh.db.QueryRowContext(r.Context(),
+		`INSERT INTO voice_recordings (channel_id, server_id, started_by)
+		 VALUES ($1, $2, $3)
+		 RETURNING id, started_at`,
+		channelID, serverID, userID,
+	).Scan(&rec.ID, &rec.StartedAt)
+	if err != nil {
+		logError(r, "Błąd zapisu nagrania", err)
+		sendError(w, http.StatusInternalServerError, "Błąd serwera")
+		return
+	}
+
+	if err := sh.sfu.StartRecording(channelID, sh.recordingsDir, strconv.Itoa(rec.ID)); err != nil {
+		logging.FromContext(r.Context()).Error("Błąd rozpoczęcia nagrywania w SFU", "channel_id", channelID, "error", err)
+		sh.db.ExecContext(r.Context(), `DELETE FROM voice_recordings WHERE id = $1`, rec.ID)
+		if errors.Is(err, sfu.ErrAlreadyRecording) {
+			sendError(w, http.StatusConflict, "Kanał jest już nagrywany")
+			return
+		}
+		sendError(w, http.StatusInternalServerError, "Nie udało się rozpocząć nagrywania")
+		return
+	}
+	sh.recordings[channelID] = &rec
+
+	sh.broadcastToRoom(channelID, 0, signaling.Message{
+		Type:      signaling.TypeRecordingState,
+		ChannelID: channelID,
+		Payload:   mustMarshal(recordingState(&rec)),
+	})
+	logging.FromContext(r.Context()).Info("Rozpoczęto nagrywanie kanału", "channel_id", channelID, "recording_id", rec.ID)
+
+	sendJSON(w, http.StatusCreated, rec)
+}
+
+// StopRecording — POST /servers/{serverId}/channels/{channelId}/voice/recording/stop
+func (sh *SignalingHandler) StopRecording(w http.ResponseWriter, r *http.Request) {
+	userID, _, channelID, ok := sh.recordingTarget(w, r)
+	if !ok {
+		return
+	}
+
+	// Manifest musi się zapisać, nawet gdy klient zerwie połączenie w trakcie
+	rec, err := sh.stopRecording(context.WithoutCancel(r.Context()), channelID, &userID)
+	if errors.Is(err, sfu.ErrNotRecording) {
+		sendError(w, http.StatusConflict, "Kanał nie jest nagrywany")
+		return
+	}
+	if err != nil {
+		logError(r, "Błąd zapisu manifestu nagrania", err)
+		sendError(w, http.StatusInternalServerError, "Błąd serwera")
+		return
+	}
+	logging.FromContext(r.Context()).Info("Zatrzymano nagrywanie kanału", "channel_id", channelID, "recording_id", rec.ID)
+
+	sendJSON(w, http.StatusOK, rec)
+}
+
+// stopRecording — kończy nagranie, zapisuje manifest plików i powiadamia pokój.
+// stoppedBy == nil oznacza zakończenie automatyczne (pusty kanał).
+func (sh *SignalingHandler) stopRecording(ctx context.Context, channelID int, stoppedBy *int) (*models.VoiceRecording, error) {
+	sh.recMu.Lock()
+	rec, active := sh.recordings[channelID]
+	if !active {
+		sh.recMu.Unlock()
+		return nil, sfu.ErrNotRecording
+	}
+	delete(sh.recordings, channelID)
+	tracks, err := sh.sfu.StopRecording(channelID)
+	sh.recMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	sh.broadcastToRoom(channelID, 0, signaling.Message{
+		Type:      signaling.TypeRecordingState,
+		ChannelID: channelID,
+		Payload:   mustMarshal(models.RecordingState{Recording: false, RecordingID: rec.ID}),
+	})
+
+	tx, err := sh.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rec.Status = "completed"
+	rec.StoppedBy = stoppedBy
+	err = tx.QueryRowContext(ctx,
+		`UPDATE voice_recordings SET status = $1, stopped_by = $2, stopped_at = NOW()
+		 WHERE id = $3
+		 RETURNING stopped_at`,
+		rec.Status, stoppedBy, rec.ID,
+	).Scan(&rec.StoppedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rec.Tracks = []models.VoiceRecordingTrack{}
+	for _, t := range tracks {
+		userID := t.UserID
+		track := models.VoiceRecordingTrack{
+			UserID:    &userID,
+			SizeBytes: t.SizeBytes,
+			StartedAt: t.StartedAt,
+			EndedAt:   t.EndedAt,
+		}
+		err := tx.QueryRowContext(ctx,
+			`INSERT INTO voice_recording_tracks (recording_id, user_id, file_name, size_bytes, started_at, ended_at)
+			 VALUES ($1, $2, $3, $4, $5, $6)
+			 RETURNING id, COALESCE((SELECT username FROM users WHERE id = $2), '')`,
+			rec.ID, t.UserID, t.FileName, t.SizeBytes, t.StartedAt, t.EndedAt,
+		).Scan(&track.ID, &track.Username)
+		if err != nil {
+			return nil, err
+		}
+		rec.Tracks = append(rec.Tracks, track)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// stopRecordingIfEmpty — nagranie kończy się samo, gdy z kanału wyjdzie ostatnia osoba
+func (sh *SignalingHandler) stopRecordingIfEmpty(channelID int) {
+	if _, ok := sh.hub.getRoom(channelID); ok {
+		return
+	}
+	rec, err := sh.stopRecording(context.Background(), channelID, nil)
+	if errors.Is(err, sfu.ErrNotRecording) {
+		return
+	}
+	if err != nil {
+		slog.Error("Błąd zapisu manifestu nagrania", "channel_id", channelID, "error", err)
+		return
+	}
+	slog.Info("Nagrywanie zatrzymane — kanał jest pusty", "channel_id", channelID, "recording_id", rec.ID)
+}
+
+// recordingState — payload recording-state dla trwającego nagrania
+func recordingState(rec *models.VoiceRecording) models.RecordingState {
+	if rec == nil {
+		return models.RecordingState{}
+	}
+	state := models.RecordingState{Recording: true, RecordingID: rec.ID, StartedAt: &rec.StartedAt}
+	if rec.StartedBy != nil {
+		state.StartedBy = *rec.StartedBy
+	}
+	return state
+}
+
+// sendRecordingState — stan nagrywania dla klienta wchodzącego do pokoju
+// (dołączenie, wznowienie, przeniesienie)
+func (sh *SignalingHandler) sendRecordingState(client *VoiceClient, channelID int) {
+	sh.recMu.Lock()
+	state := recordingState(sh.recordings[channelID])
+	sh.recMu.Unlock()
+
+	client.send(signaling.Message{
+		Type:      signaling.TypeRecordingState,
+		ChannelID: channelID,
+		Payload:   mustMarshal(state),
+	})
+}
+
+// interruptRecordings — nagrania przerwane restartem serwera nie mają manifestu plików
+func (sh *SignalingHandler) interruptRecordings() {
+	res, err := sh.db.Exec(
+		`UPDATE voice_recordings SET status = 'interrupted', stopped_at = NOW() WHERE status = 'recording'`,
+	)
+	if err != nil {
+		slog.Error("Błąd oznaczania przerwanych nagrań", "error", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		slog.Info("Oznaczono przerwane nagrania", "count", n)
+	}
+}
+
+// ── Pobieranie nagrań ──
+
+// ListRecordings — GET /servers/{serverId}/channels/{channelId}/voice/recordings
+func (sh *SignalingHandler) ListRecordings(w http.ResponseWriter, r *http.Request) {
+	_, _, channelID, ok := sh.recordingTarget(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := sh.db.QueryContext(r.Context(),
+		`SELECT id, channel_id, server_id, started_by, stopped_by, status, started_at, stopped_at
+		 FROM voice_recordings
+		 WHERE channel_id = $1
+		 ORDER BY started_at DESC
+		 LIMIT 50`,
+		channelID,
+	)
+	if err != nil {
+		logError(r, "Błąd pobierania nagrań", err)
+		sendError(w, http.StatusInternalServerError, "Błąd serwera")
+		return
+	}
+	defer rows.Close()
+
+	recordings := []models.VoiceRecording{}
+	for rows.Next() {
+		rec, err := scanRecording(rows)
+		if err != nil {
+			logError(r, "Błąd odczytu nagrania", err)
+			continue
+		}
+		recordings = append(recordings, rec)
+	}
+
+	sendJSON(w, http.StatusOK, recordings)
+}
+
+// GetRecording — GET /servers/{serverId}/voice/recordings/{recordingId} (manifest z plikami)
+func (sh *SignalingHandler) GetRecording(w http.ResponseWriter, r *http.Request) {
+	rec, ok := sh.recordingForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := sh.db.QueryContext(r.Context(),
+		`SELECT t.id, t.user_id, COALESCE(u.username, ''), t.size_bytes, t.started_at, t.ended_at
+		 FROM voice_recording_tracks t
+		 LEFT JOIN users u ON u.id = t.user_id
+		 WHERE t.recording_id = $1
+		 ORDER BY t.started_at`,
+		rec.ID,
+	)
+	if err != nil {
+		logError(r, "Błąd pobierania plików nagrania", err)
+		sendError(w, http.StatusInternalServerError, "Błąd serwera")
+		return
+	}
+	defer rows.Close()
+
+	rec.Tracks = []models.VoiceRecordingTrack{}
+	for rows.Next() {
+		var t models.VoiceRecordingTrack
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Username, &t.SizeBytes, &t.StartedAt, &t.EndedAt); err != nil {
+			logError(r, "Błąd odczytu pliku nagrania", err)
+			continue
+		}
+		rec.Tracks = append(rec.Tracks, t)
+	}
+
+	sendJSON(w, http.StatusOK, rec)
+}
+
+// DownloadRecordingTrack — GET /servers/{serverId}/voice/recordings/{recordingId}/tracks/{trackId}
+func (sh *SignalingHandler) DownloadRecordingTrack(w http.ResponseWriter, r *http.Request) {
+	rec, ok := sh.recordingForRequest(w, r)
+	if !ok {
+		return
+	}
+	trackID, err := strconv.Atoi(mux.Vars(r)["trackId"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe ID pliku")
+		return
+	}
+
+	var fileName string
+	err = sh.db.QueryRowContext(r.Context(),
+		`SELECT file_name FROM voice_recording_tracks WHERE id = $1 AND recording_id = $2`,
+		trackID, rec.ID,
+	).Scan(&fileName)
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, "Plik nagrania nie znaleziony")
+		return
+	}
+	if err != nil {
+		logError(r, "Błąd pobierania pliku nagrania", err)
+		sendError(w, http.StatusInternalServerError, "Błąd serwera")
+		return
+	}
+
+	path := filepath.Join(sh.recordingsDir, fileName)
+	if _, err := os.Stat(path); err != nil {
+		sendError(w, http.StatusGone, "Plik nagrania został usunięty")
+		return
+	}
+
+	// Długie nagrania przy wolnym łączu — bez limitu czasu zapisu serwera HTTP
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "audio/ogg")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="recording-%d-%d.ogg"`, rec.ID, trackID))
+	http.ServeFile(w, r, path)
+}
+
+// recordingForRequest — nagranie z URL, o ile należy do serwera, a użytkownik ma uprawnienie
+func (sh *SignalingHandler) recordingForRequest(w http.ResponseWriter, r *http.Request) (models.VoiceRecording, bool) {
+	claims, ok := getClaims(r)
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Brak autoryzacji")
+		return models.VoiceRecording{}, false
+	}
+
+	vars := mux.Vars(r)
+	serverID, err := strconv.Atoi(vars["serverId"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe ID serwera")
+		return models.VoiceRecording{}, false
+	}
+	recordingID, err := strconv.Atoi(vars["recordingId"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe ID nagrania")
+		return models.VoiceRecording{}, false
+	}
+
+	if !hasPermission(r.Context(), sh.members, claims.UserID, serverID, PermRecordVoice) {
+		sendError(w, http.StatusForbidden, "Brak uprawnień do nagrań kanałów głosowych")
+		return models.VoiceRecording{}, false
+	}
+
+	rec, err := scanRecording(sh.db.QueryRowContext(r.Context(),
+		`SELECT id, channel_id, server_id, started_by, stopped_by, status, started_at, stopped_at
+		 FROM voice_recordings
+		 WHERE id = $1 AND server_id = $2`,
+		recordingID, serverID,
+	))
+	if err == sql.ErrNoRows {
+		sendError(w, http.StatusNotFound, "Nagranie nie znalezione")
+		return models.VoiceRecording{}, false
+	}
+	if err != nil {
+		logError(r, "Błąd pobierania nagrania", err)
+		sendError(w, http.StatusInternalServerError, "Błąd serwera")
+		return models.VoiceRecording{}, false
+	}
+	return rec, true
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRecording(row rowScanner) (models.VoiceRecording, error) {
+	var rec models.VoiceRecording
+	var startedBy, stoppedBy sql.NullInt64
+	var stoppedAt sql.NullTime
+	err := row.Scan(&rec.ID, &rec.ChannelID, &rec.ServerID, &startedBy, &stoppedBy, &rec.Status, &rec.StartedAt, &stoppedAt)
+	if startedBy.Valid {
+		id := int(startedBy.Int64)
+		rec.StartedBy = &id
+	}
+	if stoppedBy.Valid {
+		id := int(stoppedBy.Int64)
+		rec.StoppedBy = &id
+	}
+	if stoppedAt.Valid {
+		t := stoppedAt.Time
+		rec.StoppedAt = &t
+	}
+	return rec, err
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"kodama-backend/internal/models"
+	"kodama-backend/internal/repository"
+
+	"github.com/gorilla/mux"
+)
+
+// ──────────────────────────────────────────────
+// Wyszukiwanie wiadomości
+// ──────────────────────────────────────────────
+
+// parseSearchQuery rozdziela zapytanie na filtry (from:, in:, before:, after:, has:)
+// i tekst do wyszukiwania pełnotekstowego, np.
+// "raport from:jan in:ogólny after:2026-01-01 has:attachment"
+func parseSearchQuery(raw string) (repository.MessageSearch, error) {
+	var q repository.MessageSearch
+	var words []string
+
+	for _, token := range strings.Fields(raw) {
+		key, value, found := strings.Cut(token, ":")
+		if !found || value == "" {
+			words = append(words, token)
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "from":
+			q.From = value
+		case "in":
+			q.In = strings.TrimPrefix(value, "#")
+		case "before", "after":
+			day, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				return q, &validationError{fmt.Sprintf("Nieprawidłowa data w filtrze '%s' (oczekiwano RRRR-MM-DD)", key)}
+			}
+			if strings.ToLower(key) == "before" {
+				q.Before = &day
+			} else {
+				// after: oznacza wiadomości wysłane po podanym dniu
+				next := day.AddDate(0, 0, 1)
+				q.After = &next
+			}
+		case "has":
+			if strings.ToLower(value) != "attachment" {
+				return q, &validationError{fmt.Sprintf("Nieobsługiwany filtr 'has:%s'", value)}
+			}
+			q.HasAttachment = true
+		default:
+			words = append(words, token)
+		}
+	}
+
+	q.Text = strings.Join(words, " ")
+	return q, nil
+}
+
+// SearchMessages — wyszukiwanie wiadomości we wszystkich kanałach tekstowych serwera
+func (h *ChannelHandler) SearchMessages(w http.ResponseWriter, r *http.Request) {
+	claims, ok := getClaims(r)
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Brak autoryzacji")
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverID, err := strconv.Atoi(vars["serverId"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe ID serwera")
+		return
+	}
+
+	if !h.requireServerMembership(r.Context(), claims.UserID, serverID) {
+		sendError(w, http.StatusForbidden, "Nie jesteś członkiem tego serwera")
+		return
+	}
+
+	query, err := parseSearchQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if query.IsEmpty() {
+		sendError(w, http.StatusBadRequest, "Zapytanie wyszukiwania jest puste")
+		return
+	}
+
+	// Paginacja — limit i offset
+	limit := 25
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			limit = parsed
+		}
+	}
+	offset := 0
+	if o := r.URL.Query().Get("offset"); o != "" {
+		parsed, err := strconv.Atoi(o)
+		if err != nil || parsed < 0 {
+			sendError(w, http.StatusBadRequest, "Nieprawidłowy parametr 'offset'")
+			return
+		}
+		offset = parsed
+	}
+
+	// Widoczność — tylko kanały tekstowe serwera, którego użytkownik jest członkiem
+	results, total, err := h.messages.Search(r.Context(), serverID, query, limit, offset)
+	if err != nil {
+		logError(r, "Błąd wyszukiwania wiadomości", err)
+		sendError(w, http.StatusInternalServerError, "Błąd serwera")
+		return
+	}
+
+	sendJSON(w, http.StatusOK, models.MessageSearchResponse{
+		Results: results,
+		Total:   total,
+	})
+}
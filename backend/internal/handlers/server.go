@@ -2,25 +2,26 @@ package handlers
 
 import (
 	"crypto/rand"
-	"database/sql"
 	"encoding/hex"
-	"log"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"kodama-backend/internal/auth"
 	"kodama-backend/internal/models"
+	"kodama-backend/internal/repository"
 
 	"github.com/gorilla/mux"
 )
 
 type ServerHandler struct {
-	db *sql.DB
+	servers repository.ServerRepository
+	members repository.MemberRepository
 }
 
-func NewServerHandler(db *sql.DB) *ServerHandler {
-	return &ServerHandler{db: db}
+func NewServerHandler(store *repository.Store) *ServerHandler {
+	return &ServerHandler{servers: store.Servers, members: store.Members}
 }
 
 // generateInviteCode generuje losowy kod zaproszenia
@@ -64,54 +65,21 @@ func (h *ServerHandler) CreateServer(w http.ResponseWriter, r *http.Request) {
 
 	inviteCode, err := generateInviteCode()
 	if err != nil {
-		log.Printf("Błąd generowania kodu zaproszenia: %v", err)
+		logError(r, "Błąd generowania kodu zaproszenia", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
 
-	tx, err := h.db.Begin()
+	// Utwórz serwer — twórca zostaje członkiem z rolą "owner"
+	server, err := h.servers.Create(r.Context(), req.Name, claims.UserID, inviteCode)
 	if err != nil {
-		log.Printf("Błąd transakcji: %v", err)
-		sendError(w, http.StatusInternalServerError, "Błąd serwera")
-		return
-	}
-	defer tx.Rollback()
-
-	// Utwórz serwer
-	var server models.Server
-	err = tx.QueryRow(
-		`INSERT INTO servers (name, owner_id, invite_code)
-		 VALUES ($1, $2, $3)
-		 RETURNING id, name, owner_id, invite_code, created_at, updated_at`,
-		req.Name, claims.UserID, inviteCode,
-	).Scan(&server.ID, &server.Name, &server.OwnerID, &server.InviteCode, &server.CreatedAt, &server.UpdatedAt)
-
-	if err != nil {
-		log.Printf("Błąd tworzenia serwera: %v", err)
+		logError(r, "Błąd tworzenia serwera", err)
 		sendError(w, http.StatusInternalServerError, "Nie można utworzyć serwera")
 		return
 	}
 
-	// Dodaj twórcę jako członka z rolą "owner"
-	_, err = tx.Exec(
-		`INSERT INTO server_members (server_id, user_id, role)
-		 VALUES ($1, $2, 'owner')`,
-		server.ID, claims.UserID,
-	)
-	if err != nil {
-		log.Printf("Błąd dodawania właściciela do serwera: %v", err)
-		sendError(w, http.StatusInternalServerError, "Błąd serwera")
-		return
-	}
-
-	if err := tx.Commit(); err != nil {
-		log.Printf("Błąd commita transakcji: %v", err)
-		sendError(w, http.StatusInternalServerError, "Błąd serwera")
-		return
-	}
-
 	sendJSON(w, http.StatusCreated, models.ServerResponse{
-		Server:      server,
+		Server:      *server,
 		Role:        "owner",
 		MemberCount: 1,
 	})
@@ -125,36 +93,12 @@ func (h *ServerHandler) ListServers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := h.db.Query(
-		`SELECT s.id, s.name, s.owner_id, s.invite_code, s.created_at, s.updated_at,
-		        sm.role,
-		        (SELECT COUNT(*) FROM server_members WHERE server_id = s.id) as member_count
-		 FROM servers s
-		 JOIN server_members sm ON sm.server_id = s.id AND sm.user_id = $1
-		 ORDER BY s.created_at DESC`,
-		claims.UserID,
-	)
+	servers, err := h.servers.ListForUser(r.Context(), claims.UserID)
 	if err != nil {
-		log.Printf("Błąd pobierania serwerów: %v", err)
+		logError(r, "Błąd pobierania serwerów", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
-	defer rows.Close()
-
-	servers := []models.ServerResponse{}
-	for rows.Next() {
-		var resp models.ServerResponse
-		err := rows.Scan(
-			&resp.Server.ID, &resp.Server.Name, &resp.Server.OwnerID,
-			&resp.Server.InviteCode, &resp.Server.CreatedAt, &resp.Server.UpdatedAt,
-			&resp.Role, &resp.MemberCount,
-		)
-		if err != nil {
-			log.Printf("Błąd skanowania serwera: %v", err)
-			continue
-		}
-		servers = append(servers, resp)
-	}
 
 	sendJSON(w, http.StatusOK, servers)
 }
@@ -180,56 +124,34 @@ func (h *ServerHandler) JoinServer(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Znajdź serwer po kodzie zaproszenia
-	var server models.Server
-	err := h.db.QueryRow(
-		`SELECT id, name, owner_id, invite_code, created_at, updated_at
-		 FROM servers WHERE invite_code = $1`,
-		req.InviteCode,
-	).Scan(&server.ID, &server.Name, &server.OwnerID, &server.InviteCode, &server.CreatedAt, &server.UpdatedAt)
-
-	if err == sql.ErrNoRows {
+	server, err := h.servers.GetByInviteCode(r.Context(), req.InviteCode)
+	if errors.Is(err, repository.ErrNotFound) {
 		sendError(w, http.StatusNotFound, "Nie znaleziono serwera z tym kodem zaproszenia")
 		return
 	}
 	if err != nil {
-		log.Printf("Błąd szukania serwera: %v", err)
+		logError(r, "Błąd szukania serwera", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
 
-	// Sprawdź czy użytkownik jest już członkiem
-	var alreadyMember bool
-	err = h.db.QueryRow(
-		`SELECT EXISTS(SELECT 1 FROM server_members WHERE server_id = $1 AND user_id = $2)`,
-		server.ID, claims.UserID,
-	).Scan(&alreadyMember)
-	if err != nil {
-		log.Printf("Błąd sprawdzania członkostwa: %v", err)
-		sendError(w, http.StatusInternalServerError, "Błąd serwera")
-		return
-	}
-	if alreadyMember {
+	// Dołącz do serwera (unikalność członkostwa pilnuje repozytorium)
+	err = h.members.Add(r.Context(), server.ID, claims.UserID, "member")
+	if errors.Is(err, repository.ErrConflict) {
 		sendError(w, http.StatusConflict, "Jesteś już członkiem tego serwera")
 		return
 	}
-
-	// Dołącz do serwera
-	_, err = h.db.Exec(
-		`INSERT INTO server_members (server_id, user_id, role) VALUES ($1, $2, 'member')`,
-		server.ID, claims.UserID,
-	)
 	if err != nil {
-		log.Printf("Błąd dołączania do serwera: %v", err)
+		logError(r, "Błąd dołączania do serwera", err)
 		sendError(w, http.StatusInternalServerError, "Nie można dołączyć do serwera")
 		return
 	}
 
 	// Pobierz liczbę członków
-	var memberCount int
-	h.db.QueryRow(`SELECT COUNT(*) FROM server_members WHERE server_id = $1`, server.ID).Scan(&memberCount)
+	memberCount, _ := h.members.Count(r.Context(), server.ID)
 
 	sendJSON(w, http.StatusOK, models.ServerResponse{
-		Server:      server,
+		Server:      *server,
 		Role:        "member",
 		MemberCount: memberCount,
 	})
@@ -251,27 +173,13 @@ func (h *ServerHandler) GetServer(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Sprawdź członkostwo i pobierz dane
-	var resp models.ServerResponse
-	err = h.db.QueryRow(
-		`SELECT s.id, s.name, s.owner_id, s.invite_code, s.created_at, s.updated_at,
-		        sm.role,
-		        (SELECT COUNT(*) FROM server_members WHERE server_id = s.id) as member_count
-		 FROM servers s
-		 JOIN server_members sm ON sm.server_id = s.id AND sm.user_id = $1
-		 WHERE s.id = $2`,
-		claims.UserID, serverID,
-	).Scan(
-		&resp.Server.ID, &resp.Server.Name, &resp.Server.OwnerID,
-		&resp.Server.InviteCode, &resp.Server.CreatedAt, &resp.Server.UpdatedAt,
-		&resp.Role, &resp.MemberCount,
-	)
-
-	if err == sql.ErrNoRows {
+	resp, err := h.servers.GetForMember(r.Context(), serverID, claims.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
 		sendError(w, http.StatusNotFound, "Serwer nie znaleziony lub brak dostępu")
 		return
 	}
 	if err != nil {
-		log.Printf("Błąd pobierania serwera: %v", err)
+		logError(r, "Błąd pobierania serwera", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
@@ -295,49 +203,17 @@ func (h *ServerHandler) GetServerMembers(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Sprawdź czy użytkownik jest członkiem serwera
-	var isMember bool
-	h.db.QueryRow(
-		`SELECT EXISTS(SELECT 1 FROM server_members WHERE server_id = $1 AND user_id = $2)`,
-		serverID, claims.UserID,
-	).Scan(&isMember)
-
-	if !isMember {
+	if memberRole(r.Context(), h.members, claims.UserID, serverID) == "" {
 		sendError(w, http.StatusForbidden, "Nie jesteś członkiem tego serwera")
 		return
 	}
 
-	rows, err := h.db.Query(
-		`SELECT u.id, u.username, u.email, sm.role, sm.joined_at
-		 FROM server_members sm
-		 JOIN users u ON u.id = sm.user_id
-		 WHERE sm.server_id = $1
-		 ORDER BY sm.joined_at ASC`,
-		serverID,
-	)
+	members, err := h.members.List(r.Context(), serverID)
 	if err != nil {
-		log.Printf("Błąd pobierania członków: %v", err)
+		logError(r, "Błąd pobierania członków", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
-	defer rows.Close()
-
-	type MemberInfo struct {
-		ID       int    `json:"id"`
-		Username string `json:"username"`
-		Email    string `json:"email"`
-		Role     string `json:"role"`
-		JoinedAt string `json:"joined_at"`
-	}
-
-	members := []MemberInfo{}
-	for rows.Next() {
-		var m MemberInfo
-		if err := rows.Scan(&m.ID, &m.Username, &m.Email, &m.Role, &m.JoinedAt); err != nil {
-			log.Printf("Błąd skanowania członka: %v", err)
-			continue
-		}
-		members = append(members, m)
-	}
 
 	sendJSON(w, http.StatusOK, members)
 }
@@ -358,14 +234,13 @@ func (h *ServerHandler) LeaveServer(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Sprawdź czy użytkownik jest właścicielem
-	var ownerID int
-	err = h.db.QueryRow(`SELECT owner_id FROM servers WHERE id = $1`, serverID).Scan(&ownerID)
-	if err == sql.ErrNoRows {
+	ownerID, err := h.servers.OwnerID(r.Context(), serverID)
+	if errors.Is(err, repository.ErrNotFound) {
 		sendError(w, http.StatusNotFound, "Serwer nie znaleziony")
 		return
 	}
 	if err != nil {
-		log.Printf("Błąd sprawdzania właściciela: %v", err)
+		logError(r, "Błąd sprawdzania właściciela", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
@@ -375,19 +250,14 @@ func (h *ServerHandler) LeaveServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := h.db.Exec(
-		`DELETE FROM server_members WHERE server_id = $1 AND user_id = $2`,
-		serverID, claims.UserID,
-	)
-	if err != nil {
-		log.Printf("Błąd opuszczania serwera: %v", err)
-		sendError(w, http.StatusInternalServerError, "Błąd serwera")
+	err = h.members.Remove(r.Context(), serverID, claims.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		sendError(w, http.StatusNotFound, "Nie jesteś członkiem tego serwera")
 		return
 	}
-
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		sendError(w, http.StatusNotFound, "Nie jesteś członkiem tego serwera")
+	if err != nil {
+		logError(r, "Błąd opuszczania serwera", err)
+		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
 
@@ -410,14 +280,13 @@ func (h *ServerHandler) DeleteServer(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Sprawdź czy użytkownik jest właścicielem
-	var ownerID int
-	err = h.db.QueryRow(`SELECT owner_id FROM servers WHERE id = $1`, serverID).Scan(&ownerID)
-	if err == sql.ErrNoRows {
+	ownerID, err := h.servers.OwnerID(r.Context(), serverID)
+	if errors.Is(err, repository.ErrNotFound) {
 		sendError(w, http.StatusNotFound, "Serwer nie znaleziony")
 		return
 	}
 	if err != nil {
-		log.Printf("Błąd sprawdzania właściciela: %v", err)
+		logError(r, "Błąd sprawdzania właściciela", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
@@ -427,9 +296,8 @@ func (h *ServerHandler) DeleteServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = h.db.Exec(`DELETE FROM servers WHERE id = $1`, serverID)
-	if err != nil {
-		log.Printf("Błąd usuwania serwera: %v", err)
+	if err := h.servers.Delete(r.Context(), serverID); err != nil {
+		logError(r, "Błąd usuwania serwera", err)
 		sendError(w, http.StatusInternalServerError, "Nie można usunąć serwera")
 		return
 	}
@@ -453,9 +321,8 @@ func (h *ServerHandler) RegenerateInvite(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Sprawdź czy użytkownik jest właścicielem
-	var ownerID int
-	err = h.db.QueryRow(`SELECT owner_id FROM servers WHERE id = $1`, serverID).Scan(&ownerID)
-	if err == sql.ErrNoRows {
+	ownerID, err := h.servers.OwnerID(r.Context(), serverID)
+	if errors.Is(err, repository.ErrNotFound) {
 		sendError(w, http.StatusNotFound, "Serwer nie znaleziony")
 		return
 	}
@@ -466,17 +333,13 @@ func (h *ServerHandler) RegenerateInvite(w http.ResponseWriter, r *http.Request)
 
 	newCode, err := generateInviteCode()
 	if err != nil {
-		log.Printf("Błąd generowania kodu: %v", err)
+		logError(r, "Błąd generowania kodu", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
 
-	_, err = h.db.Exec(
-		`UPDATE servers SET invite_code = $1, updated_at = NOW() WHERE id = $2`,
-		newCode, serverID,
-	)
-	if err != nil {
-		log.Printf("Błąd aktualizacji kodu zaproszenia: %v", err)
+	if err := h.servers.UpdateInviteCode(r.Context(), serverID, newCode); err != nil {
+		logError(r, "Błąd aktualizacji kodu zaproszenia", err)
 		sendError(w, http.StatusInternalServerError, "Błąd serwera")
 		return
 	}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+
+	"kodama-backend/internal/signaling"
+)
+
+// ──────────────────────────────────────────────
+// Zamykanie serwera (SIGTERM)
+// ──────────────────────────────────────────────
+
+// allClients — migawka wszystkich klientów ze wszystkich pokojów
+func (h *SignalingHub) allClients() []*VoiceClient {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var clients []*VoiceClient
+	for _, room := range h.rooms {
+		room.mu.RLock()
+		for _, c := range room.clients {
+			clients = append(clients, c)
+		}
+		room.mu.RUnlock()
+	}
+	return clients
+}
+
+// trackConn rejestruje nowe połączenie WebSocket (false = serwer jest zamykany)
+func (sh *SignalingHandler) trackConn() bool {
+	sh.connMu.Lock()
+	defer sh.connMu.Unlock()
+	if sh.shuttingDown {
+		return false
+	}
+	sh.conns.Add(1)
+	return true
+}
+
+// Shutdown — przestaje przyjmować połączenia signaling, wysyła każdemu klientowi
+// ramkę server-shutdown, zamyka połączenia i opróżnia pokoje (trwające nagrania
+// kończą się z manifestem). Czeka na zakończenie obsługi połączeń albo na ctx.
+func (sh *SignalingHandler) Shutdown(ctx context.Context) error {
+	sh.connMu.Lock()
+	if sh.shuttingDown {
+		sh.connMu.Unlock()
+		return nil
+	}
+	sh.shuttingDown = true
+	sh.connMu.Unlock()
+
+	clients := sh.hub.allClients()
+	slog.Info("Zamykanie signaling", "connections", len(clients))
+	for _, client := range clients {
+		client.send(signaling.Message{Type: signaling.TypeServerShutdown})
+		client.closeAfterFlush(CloseShutdown, "Serwer jest zamykany")
+		// Także klienci czekający na wznowienie — ich pętla odczytu już nie działa
+		sh.leaveRoom(client)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		sh.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
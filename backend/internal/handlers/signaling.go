@@ -1,53 +1,272 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"kodama-backend/internal/auth"
+	"kodama-backend/internal/config"
+	"kodama-backend/internal/logging"
+	"kodama-backend/internal/metrics"
 	"kodama-backend/internal/models"
+	"kodama-backend/internal/ratelimit"
+	"kodama-backend/internal/repository"
+	"kodama-backend/internal/sfu"
+	"kodama-backend/internal/signaling"
+	"kodama-backend/internal/tracing"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ──────────────────────────────────────────────
 // WebSocket signaling server for WebRTC voice
 // ──────────────────────────────────────────────
 
+// Kody zamknięcia WebSocket (zakres 4000–4999 jest zarezerwowany dla aplikacji)
+const (
+	CloseChannelFull  = 4003 // kanał głosowy osiągnął user_limit
+	CloseDisconnected = 4004 // rozłączony przez moderatora
+	CloseSlowConsumer = 4005 // klient nie nadąża z odbiorem wiadomości
+	CloseShutdown     = 4006 // serwer się zamyka (poprzedzone ramką server-shutdown)
+	CloseRateLimited  = 4007 // klient uporczywie przekracza limit wiadomości
+)
+
+// Heartbeat, kolejka wysyłki i wznawianie sesji
+const (
+	writeWait         = 10 * time.Second  // maksymalny czas zapisu pojedynczej ramki
+	sendQueueSize     = 256               // limit wiadomości czekających na wysłanie do klienta
+	pongWait          = 60 * time.Second  // brak ponga przez ten czas = martwe połączenie
+	pingPeriod        = pongWait * 9 / 10 // częstotliwość pingów (musi być < pongWait)
+	resumeGracePeriod = 15 * time.Second  // czas na wznowienie sesji po zerwaniu połączenia
+)
+
 var upgrader = websocket.Upgrader{
+	Subprotocols: signaling.Subprotocols(),
 	CheckOrigin: func(r *http.Request) bool {
 		return true // CORS handled at HTTP level
 	},
 }
 
-// SignalMessage — wiadomość sygnalizacyjna WebRTC
-type SignalMessage struct {
-	Type      string          `json:"type"`       // "offer", "answer", "ice-candidate", "join", "leave", "peer-joined", "peer-left", "mute-state"
-	From      int             `json:"from"`       // user ID nadawcy
-	To        int             `json:"to"`         // user ID odbiorcy (0 = broadcast)
-	FromName  string          `json:"from_name"`  // username nadawcy
-	Payload   json.RawMessage `json:"payload"`    // SDP offer/answer lub ICE candidate
-	ChannelID int             `json:"channel_id"` // ID kanału głosowego
-	Muted     bool            `json:"muted"`      // stan mikrofonu
-}
+var (
+	errChannelFull  = errors.New("Kanał głosowy jest pełny")
+	errSlowConsumer = errors.New("Klient nie nadąża z odbiorem wiadomości")
+)
 
 // VoiceRoom — pokój głosowy (kanał)
 type VoiceRoom struct {
 	mu      sync.RWMutex
 	clients map[int]*VoiceClient // userID -> client
+	mode    string               // "mesh" lub "sfu" — ustalany przy tworzeniu pokoju
 }
 
-// VoiceClient — klient podłączony do pokoju głosowego
+// VoiceClient — klient podłączony do pokoju głosowego.
+// Flagi stanu chroni room.mu pokoju, w którym klient aktualnie jest.
 type VoiceClient struct {
-	UserID   int
-	Username string
-	Conn     *websocket.Conn
-	Muted    bool
-	mu       sync.Mutex
+	UserID         int
+	Username       string
+	Conn           *websocket.Conn
+	Muted          bool
+	Deafened       bool // wygłuszony przez siebie
+	ServerMuted    bool // wyciszony przez moderatora
+	ServerDeafened bool // wygłuszony przez moderatora
+	Speaking       bool // aktywność głosowa
+	Video          bool
+	ScreenShare    bool
+	Tracks         []models.VoiceTrack // ogłoszone ścieżki (track-add)
+	StageRole      string              // kanał sceniczny: "speaker" lub "audience" ("" poza sceną)
+	HandRaised     bool                // słuchacz sceny prosi o głos
+	JoinedAt       time.Time           // dołączenie do obecnego pokoju
+	channelID      int                 // aktualny pokój (chroniony przez SignalingHub.mu)
+	resumeToken    string
+	closed         bool        // połączenie zamknięte przez serwer — bez wznawiania (chronione przez mu)
+	out            chan []byte // kolejka wysyłki obsługiwana przez writePump
+	closeFrame     []byte      // ramka zamknięcia dla closeAfterFlush (chroniona przez mu)
+	speaking       speakingLimiter
+	session        atomic.Pointer[clientSession] // bieżące połączenie (podmieniane przy wznowieniu)
+	mu             sync.Mutex
+}
+
+// clientSession — kontekst korelacyjny połączenia WebSocket klienta
+type clientSession struct {
+	logger *slog.Logger      // pola request_id i user_id
+	span   trace.SpanContext // span żądania upgrade — rodzic spanów przekazywania wiadomości
+}
+
+// attachSession zapamiętuje logger i span żądania, które otworzyło połączenie
+func (c *VoiceClient) attachSession(ctx context.Context) {
+	c.session.Store(&clientSession{
+		logger: logging.FromContext(ctx),
+		span:   trace.SpanContextFromContext(ctx),
+	})
+}
+
+// log — logger zdarzeń signaling klienta; po wznowieniu ma request_id nowego połączenia
+func (c *VoiceClient) log() *slog.Logger {
+	if session := c.session.Load(); session != nil {
+		return session.logger
+	}
+	return slog.Default().With("user_id", c.UserID)
+}
+
+// traceContext — kontekst ze spanem połączenia klienta (bez anulowania)
+func (c *VoiceClient) traceContext() context.Context {
+	if session := c.session.Load(); session != nil {
+		return trace.ContextWithSpanContext(context.Background(), session.span)
+	}
+	return context.Background()
+}
+
+// generateResumeToken generuje losowy token wznowienia sesji signaling
+func generateResumeToken() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// participant — stan klienta w formacie REST/signaling
+func (c *VoiceClient) participant() models.VoiceParticipant {
+	return models.VoiceParticipant{
+		UserID:         c.UserID,
+		Username:       c.Username,
+		Muted:          c.Muted,
+		Deafened:       c.Deafened,
+		ServerMuted:    c.ServerMuted,
+		ServerDeafened: c.ServerDeafened,
+		Speaking:       c.Speaking,
+		Video:          c.Video,
+		ScreenShare:    c.ScreenShare,
+		JoinedAt:       c.JoinedAt,
+		Tracks:         append([]models.VoiceTrack{}, c.Tracks...),
+		StageRole:      c.StageRole,
+		HandRaised:     c.HandRaised,
+	}
+}
+
+// writeRaw — kolejkuje gotową wiadomość bez blokowania. Klient z pełną kolejką
+// nie nadąża z odbiorem i zostaje rozłączony, żeby nie spowalniać pokoju.
+func (c *VoiceClient) writeRaw(data []byte) error {
+	select {
+	case c.out <- data:
+		return nil
+	default:
+		if c.closeWith(CloseSlowConsumer, "Zbyt wolne połączenie") {
+			c.log().Warn("Zbyt wolny odbiorca signaling — rozłączanie")
+		}
+		return errSlowConsumer
+	}
+}
+
+// send — serializuje i wysyła wiadomość do klienta
+func (c *VoiceClient) send(msg signaling.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.writeRaw(data)
+}
+
+// closeWith — natychmiast zamyka połączenie z kodem aplikacji
+// (false gdy zostało już wcześniej zamknięte przez serwer)
+func (c *VoiceClient) closeWith(code int, reason string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.closed = true
+	c.Conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(time.Second),
+	)
+	c.Conn.Close()
+	return true
+}
+
+// closeAfterFlush — zamyka połączenie z kodem aplikacji po wysłaniu wiadomości
+// czekających w kolejce (znacznik nil w kolejce obsługuje writePump)
+func (c *VoiceClient) closeAfterFlush(code int, reason string) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.closeFrame = websocket.FormatCloseMessage(code, reason)
+	conn := c.Conn
+	c.mu.Unlock()
+
+	select {
+	case c.out <- nil:
+	default:
+		// Pełna kolejka — zamykamy od razu
+		conn.WriteControl(websocket.CloseMessage, c.closeFrame, time.Now().Add(time.Second))
+		conn.Close()
+	}
+}
+
+// connState — czy conn jest nadal aktualnym połączeniem klienta i czy serwer je zamknął
+func (c *VoiceClient) connState(conn *websocket.Conn) (current, closed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn == conn, c.closed
+}
+
+// writePump — jedyny zapisujący do conn: wysyła wiadomości z kolejki i pingi,
+// dopóki conn jest aktualnym połączeniem klienta. Przy resume kolejka przechodzi
+// na nowe połączenie; wiadomości z czasu przerwy czekają w niej. Strony odczytu
+// (deadline, pong handler) nie dotyka — należy do pętli odczytu w serve.
+func (c *VoiceClient) writePump(conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+
+		case data := <-c.out:
+			if data == nil {
+				c.mu.Lock()
+				frame := c.closeFrame
+				c.mu.Unlock()
+				conn.WriteControl(websocket.CloseMessage, frame, time.Now().Add(writeWait))
+				conn.Close()
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				// Zamknięcie kończy pętlę odczytu, która zdecyduje o wznowieniu lub wyjściu
+				c.log().Warn("Błąd zapisu signaling", "error", err)
+				conn.Close()
+				return
+			}
+
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
 }
 
 // SignalingHub — zarządza wszystkimi pokojami głosowymi
@@ -62,47 +281,199 @@ func NewSignalingHub() *SignalingHub {
 	}
 }
 
-// getOrCreateRoom — pobiera lub tworzy pokój głosowy
-func (h *SignalingHub) getOrCreateRoom(channelID int) *VoiceRoom {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
+// getOrCreateRoomLocked — pobiera lub tworzy pokój głosowy, wywoływać pod h.mu.
+// Tryb istniejącego pokoju się nie zmienia — mieszanie mesh i SFU w jednym pokoju nie działa.
+func (h *SignalingHub) getOrCreateRoomLocked(channelID int, mode string) *VoiceRoom {
 	room, ok := h.rooms[channelID]
 	if !ok {
 		room = &VoiceRoom{
 			clients: make(map[int]*VoiceClient),
+			mode:    mode,
 		}
 		h.rooms[channelID] = room
 	}
 	return room
 }
 
-// removeFromRoom — usuwa klienta z pokoju
-func (h *SignalingHub) removeFromRoom(channelID, userID int) {
+// getRoom — pobiera istniejący pokój
+func (h *SignalingHub) getRoom(channelID int) (*VoiceRoom, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	room, ok := h.rooms[channelID]
+	return room, ok
+}
+
+// joinResult — wynik dodania klienta do pokoju
+type joinResult struct {
+	existing []models.VoiceParticipant // pozostali uczestnicy pokoju
+	previous *VoiceClient              // wcześniejsze połączenie użytkownika (do zamknięcia)
+	mode     string                    // tryb pokoju
+}
+
+// addClient — dodaje klienta do pokoju z kontrolą user_limit (0 = bez limitu).
+// Wcześniejsze połączenie tego samego użytkownika (z dowolnego pokoju) zostaje odłączone.
+func (h *SignalingHub) addClient(channelID int, client *VoiceClient, userLimit int, mode string) (joinResult, error) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	var previous *VoiceClient
+	for _, r := range h.rooms {
+		r.mu.RLock()
+		if c, ok := r.clients[client.UserID]; ok {
+			previous = c
+		}
+		r.mu.RUnlock()
+	}
+
+	room := h.getOrCreateRoomLocked(channelID, mode)
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	others := len(room.clients)
+	if previous != nil && previous.channelID == channelID {
+		others--
+	}
+	if userLimit > 0 && others >= userLimit {
+		if len(room.clients) == 0 {
+			delete(h.rooms, channelID)
+		}
+		return joinResult{}, errChannelFull
+	}
+
+	if previous != nil && previous.channelID != channelID {
+		h.removeClientLocked(previous.channelID, previous)
+	}
+
+	existing := []models.VoiceParticipant{}
+	for uid, c := range room.clients {
+		if uid != client.UserID {
+			existing = append(existing, c.participant())
+		}
+	}
+
+	if previous == nil || previous.channelID != channelID {
+		client.JoinedAt = time.Now().UTC()
+	} else {
+		// Ponowne połączenie z tym samym kanałem — zatwierdzony mówca nie traci głosu
+		client.JoinedAt = previous.JoinedAt
+		if previous.StageRole != "" {
+			client.setStageRole(previous.StageRole)
+			client.HandRaised = previous.HandRaised
+		}
+	}
+	client.channelID = channelID
+	room.clients[client.UserID] = client
+	return joinResult{existing: existing, previous: previous, mode: room.mode}, nil
+}
+
+// removeClient — usuwa klienta z pokoju, o ile nie został już zastąpiony
+// nowszym połączeniem. Zwraca true gdy klient faktycznie został usunięty.
+func (h *SignalingHub) removeClient(channelID int, client *VoiceClient) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.removeClientLocked(channelID, client)
+}
+
+func (h *SignalingHub) removeClientLocked(channelID int, client *VoiceClient) bool {
 	room, ok := h.rooms[channelID]
 	if !ok {
-		return
+		return false
 	}
 	room.mu.Lock()
-	delete(room.clients, userID)
-	empty := len(room.clients) == 0
-	room.mu.Unlock()
+	defer room.mu.Unlock()
 
-	if empty {
+	if room.clients[client.UserID] != client {
+		return false
+	}
+	delete(room.clients, client.UserID)
+	if len(room.clients) == 0 {
 		delete(h.rooms, channelID)
 	}
+	return true
 }
 
-// GetRoomParticipants — zwraca listę uczestników pokoju (dla REST API)
-func (h *SignalingHub) GetRoomParticipants(channelID int) []map[string]interface{} {
+// moveClient — przenosi klienta do innego pokoju z zachowaniem jego stanu.
+// Zwraca kanał źródłowy oraz uczestników i tryb pokoju docelowego.
+// stageRole to rola w pokoju docelowym ("" gdy nie jest sceną).
+func (h *SignalingHub) moveClient(client *VoiceClient, toChannelID int, mode, stageRole string) (int, joinResult, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fromChannelID := client.channelID
+	if fromChannelID == toChannelID || !h.removeClientLocked(fromChannelID, client) {
+		return fromChannelID, joinResult{}, false
+	}
+
+	room := h.getOrCreateRoomLocked(toChannelID, mode)
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	existing := []models.VoiceParticipant{}
+	for _, c := range room.clients {
+		existing = append(existing, c.participant())
+	}
+	client.channelID = toChannelID
+	client.JoinedAt = time.Now().UTC()
+	client.clearTracks() // połączenia mediów z poprzednim pokojem zostają zamknięte
+	client.setStageRole(stageRole)
+	room.clients[client.UserID] = client
+	return fromChannelID, joinResult{existing: existing, mode: room.mode}, true
+}
+
+// resumeClient — podpina nowe połączenie do klienta, który zachował miejsce w pokoju
+// po zerwaniu połączenia. Zwraca klienta i jego poprzednie połączenie (do zamknięcia).
+func (h *SignalingHub) resumeClient(channelID, userID int, token string, conn *websocket.Conn) (*VoiceClient, *websocket.Conn, bool) {
+	room, ok := h.getRoom(channelID)
+	if !ok {
+		return nil, nil, false
+	}
+	room.mu.RLock()
+	client, ok := room.clients[userID]
+	room.mu.RUnlock()
+	if !ok || subtle.ConstantTimeCompare([]byte(client.resumeToken), []byte(token)) != 1 {
+		return nil, nil, false
+	}
+	if h.clientChannel(client) != channelID {
+		return nil, nil, false
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.closed {
+		return nil, nil, false
+	}
+	old := client.Conn
+	client.Conn = conn
+	return client, old, true
+}
+
+// clientChannel — aktualny kanał klienta
+func (h *SignalingHub) clientChannel(client *VoiceClient) int {
 	h.mu.RLock()
-	room, ok := h.rooms[channelID]
-	h.mu.RUnlock()
+	defer h.mu.RUnlock()
+	return client.channelID
+}
+
+// findClient — wyszukuje połączonego klienta po ID użytkownika
+func (h *SignalingHub) findClient(userID int) (*VoiceClient, int, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 
-	participants := []map[string]interface{}{}
+	for channelID, room := range h.rooms {
+		room.mu.RLock()
+		c, ok := room.clients[userID]
+		room.mu.RUnlock()
+		if ok {
+			return c, channelID, true
+		}
+	}
+	return nil, 0, false
+}
+
+// GetRoomParticipants — zwraca listę uczestników pokoju (dla REST API)
+func (h *SignalingHub) GetRoomParticipants(channelID int) []models.VoiceParticipant {
+	participants := []models.VoiceParticipant{}
+	room, ok := h.getRoom(channelID)
 	if !ok {
 		return participants
 	}
@@ -111,29 +482,27 @@ func (h *SignalingHub) GetRoomParticipants(channelID int) []map[string]interface
 	defer room.mu.RUnlock()
 
 	for _, c := range room.clients {
-		participants = append(participants, map[string]interface{}{
-			"user_id":  c.UserID,
-			"username": c.Username,
-			"muted":    c.Muted,
-		})
+		participants = append(participants, c.participant())
 	}
 	return participants
 }
 
 // IsUserInChannel — sprawdza czy użytkownik jest w danym kanale
 func (h *SignalingHub) IsUserInChannel(userID int) (int, bool) {
+	_, channelID, ok := h.findClient(userID)
+	return channelID, ok
+}
+
+// Stats — liczba aktywnych pokojów i ich uczestników (także czekających na wznowienie)
+func (h *SignalingHub) Stats() (rooms, participants int) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-
-	for channelID, room := range h.rooms {
+	for _, room := range h.rooms {
 		room.mu.RLock()
-		_, exists := room.clients[userID]
+		participants += len(room.clients)
 		room.mu.RUnlock()
-		if exists {
-			return channelID, true
-		}
 	}
-	return 0, false
+	return len(h.rooms), participants
 }
 
 // ──────────────────────────────────────────────
@@ -141,12 +510,38 @@ func (h *SignalingHub) IsUserInChannel(userID int) (int, bool) {
 // ──────────────────────────────────────────────
 
 type SignalingHandler struct {
-	hub   *SignalingHub
-	voice *VoiceState // stary VoiceState — zsynchronizujemy go
+	cfg      *config.Config
+	db       *sql.DB // nagrania
+	members  repository.MemberRepository
+	channels repository.ChannelRepository
+	hub      *SignalingHub
+	voice    *VoiceState // stary VoiceState — zsynchronizujemy go
+	sfu      *sfu.SFU    // przekazywanie mediów dla kanałów w trybie "sfu"
+
+	recordingsDir string
+	recMu         sync.Mutex
+	recordings    map[int]*models.VoiceRecording // channelID -> trwające nagranie
+
+	connMu       sync.Mutex
+	conns        sync.WaitGroup // obsługiwane połączenia WebSocket
+	shuttingDown bool           // chronione przez connMu
 }
 
-func NewSignalingHandler(hub *SignalingHub, voice *VoiceState) *SignalingHandler {
-	return &SignalingHandler{hub: hub, voice: voice}
+func NewSignalingHandler(cfg *config.Config, db *sql.DB, store *repository.Store, hub *SignalingHub, voice *VoiceState, sfuServer *sfu.SFU) *SignalingHandler {
+	sh := &SignalingHandler{
+		cfg:           cfg,
+		db:            db,
+		members:       store.Members,
+		channels:      store.Channels,
+		hub:           hub,
+		voice:         voice,
+		sfu:           sfuServer,
+		recordingsDir: cfg.RecordingsDir,
+		recordings:    make(map[int]*models.VoiceRecording),
+	}
+	sfuServer.OnSpeaking(sh.sfuSpeaking)
+	sh.interruptRecordings()
+	return sh
 }
 
 // HandleWebSocket — endpoint /api/ws/voice/{channelId}
@@ -163,6 +558,8 @@ func (sh *SignalingHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reque
 		http.Error(w, "Nieprawidłowy token", http.StatusUnauthorized)
 		return
 	}
+	logging.SetUserID(r.Context(), claims.UserID)
+	logger := logging.FromContext(r.Context())
 
 	vars := mux.Vars(r)
 	channelID, err := strconv.Atoi(vars["channelId"])
@@ -171,216 +568,481 @@ func (sh *SignalingHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Kanał musi być głosowy, a użytkownik członkiem jego serwera
+	channel, err := sh.channels.GetByID(r.Context(), channelID)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Kanał nie znaleziony", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logError(r, "Błąd pobierania kanału", err)
+		http.Error(w, "Błąd serwera", http.StatusInternalServerError)
+		return
+	}
+	serverID, userLimit, chType, voiceMode := channel.ServerID, channel.UserLimit, channel.Type, channel.VoiceMode
+	limits := mediaLimits(channel)
+	role := memberRole(r.Context(), sh.members, claims.UserID, serverID)
+	if role == "" {
+		http.Error(w, "Nie jesteś członkiem tego serwera", http.StatusForbidden)
+		return
+	}
+	if !isVoiceChannelType(chType) {
+		http.Error(w, "To nie jest kanał głosowy", http.StatusBadRequest)
+		return
+	}
+
+	// Wersja protokołu — klient bez subprotokołu dostaje wersję 1
+	if !signaling.Supports(websocket.Subprotocols(r)) {
+		http.Error(w, "Nieobsługiwana wersja protokołu signaling", http.StatusBadRequest)
+		return
+	}
+
+	// Serwer w trakcie zamykania nie przyjmuje nowych połączeń
+	if !sh.trackConn() {
+		http.Error(w, "Serwer jest zamykany", http.StatusServiceUnavailable)
+		return
+	}
+	defer sh.conns.Done()
+
 	// Upgrade HTTP → WebSocket
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		logger.Warn("Błąd upgrade WebSocket", "error", err)
 		return
 	}
 	defer conn.Close()
+	// Span żądania kończy się na upgrade, a nie po rozłączeniu — spany przekazywania
+	// wiadomości zostają jego potomkami (attachSession zapamiętuje kontekst)
+	if span := trace.SpanFromContext(r.Context()); span.IsRecording() {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(http.StatusSwitchingProtocols))
+		span.End()
+	}
+	metrics.WebSocketConnections.Inc()
+	defer metrics.WebSocketConnections.Dec()
+	conn.SetReadLimit(signaling.MaxMessageSize)
+	version, _ := signaling.ParseSubprotocol(conn.Subprotocol())
 
-	client := &VoiceClient{
-		UserID:   claims.UserID,
-		Username: claims.Username,
-		Conn:     conn,
-		Muted:    false,
+	// Wznowienie sesji po chwilowym zerwaniu — pozostali uczestnicy niczego nie zauważają
+	if token := r.URL.Query().Get("resume"); token != "" {
+		if client, old, ok := sh.hub.resumeClient(channelID, claims.UserID, token, conn); ok {
+			client.attachSession(r.Context())
+			old.Close()
+			sh.resumeSession(client)
+			client.log().Info("Wznowiono sesję głosową", "channel_id", channelID, "protocol", version)
+			sh.serve(client, conn)
+			return
+		}
 	}
 
-	room := sh.hub.getOrCreateRoom(channelID)
+	resumeToken, err := generateResumeToken()
+	if err != nil {
+		logger.Error("Błąd generowania tokenu wznowienia", "error", err)
+		return
+	}
 
-	// Usuń z poprzedniego pokoju jeśli był
-	if oldChannelID, exists := sh.hub.IsUserInChannel(claims.UserID); exists && oldChannelID != channelID {
-		sh.removeClientFromRoom(oldChannelID, claims.UserID)
+	client := &VoiceClient{
+		UserID:      claims.UserID,
+		Username:    claims.Username,
+		Conn:        conn,
+		Muted:       false,
+		resumeToken: resumeToken,
+		out:         make(chan []byte, sendQueueSize),
 	}
+	client.attachSession(r.Context())
+	client.setStageRole(initialStageRole(chType, role))
 
-	// Pobierz listę istniejących peerów PRZED dodaniem nowego
-	room.mu.RLock()
-	existingPeers := []map[string]interface{}{}
-	for _, c := range room.clients {
-		existingPeers = append(existingPeers, map[string]interface{}{
-			"user_id":  c.UserID,
-			"username": c.Username,
-			"muted":    c.Muted,
-		})
+	limit := userLimit
+	if roleHasPermission(role, PermMoveMembers) {
+		limit = 0 // uprawnienie "move members" omija limit
 	}
-	room.mu.RUnlock()
 
-	// Dodaj klienta do pokoju
-	room.mu.Lock()
-	room.clients[claims.UserID] = client
-	room.mu.Unlock()
+	joined, err := sh.hub.addClient(channelID, client, limit, voiceMode)
+	if err == errChannelFull {
+		client.closeWith(CloseChannelFull, err.Error())
+		return
+	}
+
+	// Poprzednie połączenie tego użytkownika zostaje zamknięte
+	if previous := joined.previous; previous != nil {
+		sh.sfu.Leave(previous.channelID, claims.UserID)
+		if previous.channelID != channelID {
+			sh.syncLeave(previous.channelID, claims.UserID)
+			sh.broadcastToRoom(previous.channelID, claims.UserID, signaling.Message{
+				Type:      signaling.TypePeerLeft,
+				From:      claims.UserID,
+				FromName:  claims.Username,
+				ChannelID: previous.channelID,
+			})
+			sh.stopRecordingIfEmpty(previous.channelID)
+		}
+		previous.closeWith(websocket.CloseNormalClosure, "Połączono z innego miejsca")
+	}
 
 	// Synchronizuj stary VoiceState
-	sh.syncVoiceState(channelID, claims.UserID, claims.Username, true)
+	joinedState := client.participant()
+	sh.syncParticipant(channelID, joinedState)
 
-	// Wyślij nowemu klientowi listę istniejących peerów
-	peersMsg, _ := json.Marshal(SignalMessage{
-		Type:      "room-peers",
+	// Wyślij nowemu klientowi listę istniejących peerów, konfigurację ICE, limity mediów i token wznowienia
+	ice := iceServersForUser(sh.cfg, claims.UserID)
+	client.send(signaling.Message{
+		Type:      signaling.TypeRoomPeers,
 		ChannelID: channelID,
-		Payload:   mustMarshal(existingPeers),
+		Payload:   mustMarshal(joined.existing),
+		Mode:      joined.mode,
+		ICE:       &ice,
+		Resume:    resumeToken,
+		Limits:    &limits,
+		StageRole: joinedState.StageRole,
 	})
-	client.mu.Lock()
-	client.Conn.WriteMessage(websocket.TextMessage, peersMsg)
-	client.mu.Unlock()
+	sh.sendRecordingState(client, channelID)
 
-	// Powiadom istniejących uczestników o nowym peerze
-	sh.broadcastToRoom(channelID, claims.UserID, SignalMessage{
-		Type:      "peer-joined",
+	// W trybie SFU serwer od razu wysyła klientowi pierwszą ofertę
+	if joined.mode == "sfu" {
+		if err := sh.sfu.Join(channelID, claims.UserID, sh.sfuSignal(client)); err != nil {
+			client.log().Error("Błąd dołączania do SFU", "channel_id", channelID, "error", err)
+		}
+		sh.applySFUState(channelID, client)
+	}
+
+	// Powiadom istniejących uczestników o nowym peerze (payload: jego pełny stan)
+	sh.broadcastToRoom(channelID, claims.UserID, signaling.Message{
+		Type:      signaling.TypePeerJoined,
 		From:      claims.UserID,
 		FromName:  claims.Username,
 		ChannelID: channelID,
+		Payload:   mustMarshal(joinedState),
 	})
 
-	log.Printf("User %s (%d) joined voice channel %d", claims.Username, claims.UserID, channelID)
+	client.log().Info("Dołączono do kanału głosowego", "channel_id", channelID, "protocol", version, "mode", joined.mode)
+
+	sh.serve(client, conn)
+}
+
+// resumeSession — po wznowieniu klient dostaje aktualny stan pokoju (mógł przegapić
+// peer-joined/peer-left), a w trybie SFU serwer ponawia negocjację
+func (sh *SignalingHandler) resumeSession(client *VoiceClient) {
+	channelID := sh.hub.clientChannel(client)
+
+	mode := "mesh"
+	stageRole := ""
+	existing := []models.VoiceParticipant{}
+	if room, ok := sh.hub.getRoom(channelID); ok {
+		room.mu.RLock()
+		mode = room.mode
+		stageRole = client.StageRole
+		for uid, c := range room.clients {
+			if uid != client.UserID {
+				existing = append(existing, c.participant())
+			}
+		}
+		room.mu.RUnlock()
+	}
+
+	ice := iceServersForUser(sh.cfg, client.UserID)
+	limits := sh.channelLimits(channelID)
+	client.send(signaling.Message{
+		Type:      signaling.TypeResumed,
+		ChannelID: channelID,
+		Payload:   mustMarshal(existing),
+		Mode:      mode,
+		ICE:       &ice,
+		Resume:    client.resumeToken,
+		Limits:    &limits,
+		StageRole: stageRole,
+	})
+	sh.sendRecordingState(client, channelID)
+
+	if mode == "sfu" {
+		if err := sh.sfu.Resume(channelID, client.UserID); err != nil {
+			client.log().Error("Błąd wznawiania SFU", "channel_id", channelID, "error", err)
+		}
+	}
+}
+
+// serve — pętla odczytu wiadomości jednego połączenia klienta
+func (sh *SignalingHandler) serve(client *VoiceClient, conn *websocket.Conn) {
+	// Strona odczytu — tylko z tej goroutine (gorilla/websocket: jeden czytający).
+	// Pong handler wywołuje ReadMessage, więc też działa w tej goroutine.
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+	go client.writePump(conn, done)
+
+	// Limit wiadomości per połączenie: odrzucone wiadomości są pomijane, a klient
+	// dostaje jeden błąd na serię; seria dłuższa niż limit zamyka połączenie
+	limiter := ratelimit.NewBucket(ratelimit.Rule(sh.cfg.RateLimitSignaling))
+	rejected := 0
 
-	// Pętla odczytu wiadomości
+	var readErr error
 	for {
 		_, rawMsg, err := conn.ReadMessage()
 		if err != nil {
+			readErr = err
 			break
 		}
 
-		var msg SignalMessage
-		if err := json.Unmarshal(rawMsg, &msg); err != nil {
+		if sh.cfg.RateLimitEnabled && !limiter.Allow() {
+			rejected++
+			switch {
+			case rejected >= sh.cfg.RateLimitSignaling.Limit:
+				client.log().Warn("Zamknięto połączenie signaling po przekroczeniu limitu wiadomości", "limit", sh.cfg.RateLimitSignaling.String())
+				client.closeAfterFlush(CloseRateLimited, "rate limited") // błąd rate_limited z kolejki dochodzi przed zamknięciem
+			case rejected == 1:
+				client.send((&signaling.Error{
+					Code:    signaling.ErrCodeRateLimited,
+					Message: "Zbyt wiele wiadomości — zwolnij",
+				}).Frame())
+			}
+			continue
+		}
+		rejected = 0
+
+		msg, err := signaling.DecodeClientMessage(rawMsg)
+		if err != nil {
+			client.send(err.(*signaling.Error).Frame())
 			continue
 		}
 
-		msg.From = claims.UserID
-		msg.FromName = claims.Username
-		msg.ChannelID = channelID
+		// Moderator mógł przenieść klienta do innego kanału
+		currentChannelID := sh.hub.clientChannel(client)
+
+		msg.From = client.UserID
+		msg.FromName = client.Username
+		msg.ChannelID = currentChannelID
 
 		switch msg.Type {
-		case "offer", "answer", "ice-candidate":
+		case signaling.TypeOffer, signaling.TypeAnswer, signaling.TypeICECandidate:
 			// Wyślij do konkretnego peera
-			sh.sendToPeer(channelID, msg.To, msg)
+			if !sh.sendToPeer(client.traceContext(), currentChannelID, msg.To, *msg) {
+				client.send((&signaling.Error{
+					Code:    signaling.ErrCodeInvalidTarget,
+					Message: "Odbiorca nie jest w tym pokoju",
+					RefType: msg.Type,
+				}).Frame())
+			}
+
+		case signaling.TypeMuteState:
+			// Zaktualizuj stan mute (i stary VoiceState), potem broadcast do wszystkich
+			if !sh.updateMediaState(currentChannelID, client, !msg.Muted, func(c *VoiceClient) { c.Muted = msg.Muted }) {
+				sendNotSpeaker(client, msg.Type)
+				continue
+			}
+			sh.broadcastToRoom(currentChannelID, 0, *msg)
+
+			if msg.Muted {
+				sh.setSpeaking(client, false)
+			}
+
+		case signaling.TypeDeafenState:
+			sh.updateClientState(currentChannelID, client, func(c *VoiceClient) { c.Deafened = msg.Deafened })
+			sh.broadcastToRoom(currentChannelID, 0, *msg)
+
+		case signaling.TypeVideoState:
+			if !sh.updateMediaState(currentChannelID, client, msg.Video, func(c *VoiceClient) { c.Video = msg.Video }) {
+				sendNotSpeaker(client, msg.Type)
+				continue
+			}
+			sh.broadcastToRoom(currentChannelID, 0, *msg)
+
+		case signaling.TypeScreenShareState:
+			if !sh.updateMediaState(currentChannelID, client, msg.ScreenShare, func(c *VoiceClient) { c.ScreenShare = msg.ScreenShare }) {
+				sendNotSpeaker(client, msg.Type)
+				continue
+			}
+			sh.broadcastToRoom(currentChannelID, 0, *msg)
 
-		case "mute-state":
-			// Zaktualizuj stan mute
-			room.mu.Lock()
-			if c, ok := room.clients[claims.UserID]; ok {
-				c.Muted = msg.Muted
+		case signaling.TypeSpeaking:
+			// W trybie SFU mowę wykrywa serwer z poziomów audio — deklaracje klienta pomijamy
+			room, ok := sh.hub.getRoom(currentChannelID)
+			if !ok || room.mode == "sfu" {
+				continue
 			}
-			room.mu.Unlock()
+			sh.setSpeaking(client, msg.Speaking)
 
-			// Synchronizuj stary VoiceState
-			sh.syncMuteState(channelID, claims.UserID, msg.Muted)
+		case signaling.TypeTrackAdd:
+			sh.handleTrackAdd(client, currentChannelID, msg)
 
-			// Broadcast do wszystkich
-			sh.broadcastToRoom(channelID, 0, msg)
+		case signaling.TypeTrackRemove:
+			sh.handleTrackRemove(client, currentChannelID, msg)
+
+		case signaling.TypeTrackMute:
+			sh.handleTrackMute(client, currentChannelID, msg)
+
+		case signaling.TypeRaiseHand:
+			sh.handleRaiseHand(client, currentChannelID, msg)
+
+		case signaling.TypeStageSpeaker:
+			sh.handleStageSpeaker(client, currentChannelID, msg)
+
+		case signaling.TypeSFUAnswer:
+			var answer webrtc.SessionDescription
+			if err := json.Unmarshal(msg.Payload, &answer); err != nil {
+				continue
+			}
+			if err := sh.sfu.HandleAnswer(currentChannelID, client.UserID, answer); err != nil {
+				client.log().Error("Błąd odpowiedzi SFU", "error", err)
+			}
+
+		case signaling.TypeSFUICECandidate:
+			var candidate webrtc.ICECandidateInit
+			if err := json.Unmarshal(msg.Payload, &candidate); err != nil {
+				continue
+			}
+			sh.sfu.AddICECandidate(currentChannelID, client.UserID, candidate)
 		}
 	}
 
-	// Klient się rozłączył
-	log.Printf("User %s (%d) left voice channel %d", claims.Username, claims.UserID, channelID)
-	sh.removeClientFromRoom(channelID, claims.UserID)
+	current, closed := client.connState(conn)
+	if !current {
+		// Sesję wznowiono na nowym połączeniu
+		return
+	}
+
+	// Świadome rozłączenie, zamknięcie przez serwer lub zbyt duża wiadomość — od razu opuszczamy pokój
+	if closed || errors.Is(readErr, websocket.ErrReadLimit) ||
+		websocket.IsCloseError(readErr, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		sh.leaveRoom(client)
+		return
+	}
+
+	// Zerwane połączenie — miejsce w pokoju czeka resumeGracePeriod na wznowienie
+	client.log().Info("Utracono połączenie głosowe — oczekiwanie na wznowienie", "error", readErr)
+	time.AfterFunc(resumeGracePeriod, func() {
+		if current, _ := client.connState(conn); current {
+			sh.leaveRoom(client)
+		}
+	})
+}
+
+// leaveRoom — usuwa klienta z pokoju i powiadamia pozostałych
+func (sh *SignalingHandler) leaveRoom(client *VoiceClient) {
+	channelID := sh.hub.clientChannel(client)
+	if !sh.hub.removeClient(channelID, client) {
+		// Połączenie zostało już zastąpione nowszym — nic do sprzątania
+		return
+	}
+	client.log().Info("Opuszczono kanał głosowy", "channel_id", channelID)
+	sh.sfu.Leave(channelID, client.UserID)
+	sh.syncLeave(channelID, client.UserID)
 
 	// Powiadom pozostałych
-	sh.broadcastToRoom(channelID, claims.UserID, SignalMessage{
-		Type:      "peer-left",
-		From:      claims.UserID,
-		FromName:  claims.Username,
+	sh.broadcastToRoom(channelID, client.UserID, signaling.Message{
+		Type:      signaling.TypePeerLeft,
+		From:      client.UserID,
+		FromName:  client.Username,
 		ChannelID: channelID,
 	})
+	sh.stopRecordingIfEmpty(channelID)
 }
 
-// removeClientFromRoom — usuwa klienta i synchronizuje stan
-func (sh *SignalingHandler) removeClientFromRoom(channelID, userID int) {
-	sh.hub.removeFromRoom(channelID, userID)
-	sh.syncVoiceState(channelID, userID, "", false)
+// sfuSignal — wiadomości SFU (oferty, kandydaci ICE) trafiają do klienta przez jego WebSocket
+func (sh *SignalingHandler) sfuSignal(client *VoiceClient) sfu.SignalFunc {
+	return func(msgType string, payload interface{}) error {
+		return client.send(signaling.Message{
+			Type:      msgType,
+			ChannelID: sh.hub.clientChannel(client),
+			Payload:   mustMarshal(payload),
+		})
+	}
 }
 
 // broadcastToRoom — wyślij wiadomość do wszystkich w pokoju (oprócz excludeUserID)
-func (sh *SignalingHandler) broadcastToRoom(channelID, excludeUserID int, msg SignalMessage) {
-	sh.hub.mu.RLock()
-	room, ok := sh.hub.rooms[channelID]
-	sh.hub.mu.RUnlock()
+func (sh *SignalingHandler) broadcastToRoom(channelID, excludeUserID int, msg signaling.Message) {
+	room, ok := sh.hub.getRoom(channelID)
 	if !ok {
 		return
 	}
 
 	data, _ := json.Marshal(msg)
 
+	// Migawka odbiorców — kolejkowanie (i ewentualne rozłączanie wolnych klientów) poza blokadą pokoju
 	room.mu.RLock()
-	defer room.mu.RUnlock()
-
+	recipients := make([]*VoiceClient, 0, len(room.clients))
 	for userID, client := range room.clients {
-		if userID == excludeUserID {
-			continue
+		if userID != excludeUserID {
+			recipients = append(recipients, client)
 		}
-		client.mu.Lock()
-		client.Conn.WriteMessage(websocket.TextMessage, data)
-		client.mu.Unlock()
+	}
+	room.mu.RUnlock()
+
+	for _, client := range recipients {
+		client.writeRaw(data)
 	}
 }
 
-// sendToPeer — wyślij wiadomość do konkretnego peera
-func (sh *SignalingHandler) sendToPeer(channelID, toUserID int, msg SignalMessage) {
-	sh.hub.mu.RLock()
-	room, ok := sh.hub.rooms[channelID]
-	sh.hub.mu.RUnlock()
+// sendToPeer — wyślij wiadomość do konkretnego peera (false gdy nie ma go w pokoju).
+// ctx niesie span połączenia nadawcy; przekazanie dostaje span potomny.
+func (sh *SignalingHandler) sendToPeer(ctx context.Context, channelID, toUserID int, msg signaling.Message) bool {
+	_, span := tracing.Tracer().Start(ctx, "signaling.sendToPeer", trace.WithAttributes(
+		attribute.String("signaling.type", msg.Type),
+		attribute.Int("signaling.channel_id", channelID),
+		attribute.Int("signaling.from_user_id", msg.From),
+		attribute.Int("signaling.to_user_id", toUserID),
+	))
+	defer span.End()
+
+	room, ok := sh.hub.getRoom(channelID)
 	if !ok {
-		return
+		span.SetStatus(codes.Error, "pokój nie istnieje")
+		return false
 	}
 
 	room.mu.RLock()
 	client, ok := room.clients[toUserID]
 	room.mu.RUnlock()
 	if !ok {
-		return
+		span.SetStatus(codes.Error, "odbiorca nie jest w pokoju")
+		return false
 	}
 
-	data, _ := json.Marshal(msg)
-	client.mu.Lock()
-	client.Conn.WriteMessage(websocket.TextMessage, data)
-	client.mu.Unlock()
+	if err := client.send(msg); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "nie udało się zakolejkować wiadomości")
+	}
+	return true
 }
 
-// syncVoiceState — synchronizuj stary VoiceState REST API ze stanem WebSocket
-func (sh *SignalingHandler) syncVoiceState(channelID, userID int, username string, joining bool) {
+// syncParticipant — zapisz stan uczestnika w starym VoiceState REST API
+// (dołączenie, przeniesienie lub zmiana stanu)
+func (sh *SignalingHandler) syncParticipant(channelID int, p models.VoiceParticipant) {
 	sh.voice.mu.Lock()
 	defer sh.voice.mu.Unlock()
 
-	if joining {
-		// Usuń z poprzedniego kanału
-		if oldChannelID, exists := sh.voice.userChannel[userID]; exists {
-			if participants, ok := sh.voice.channels[oldChannelID]; ok {
-				delete(participants, userID)
-				if len(participants) == 0 {
-					delete(sh.voice.channels, oldChannelID)
-				}
-			}
-		}
-
-		if sh.voice.channels[channelID] == nil {
-			sh.voice.channels[channelID] = make(map[int]*models.VoiceParticipant)
-		}
-		sh.voice.channels[channelID][userID] = &models.VoiceParticipant{
-			UserID:   userID,
-			Username: username,
-			Muted:    false,
-		}
-		sh.voice.userChannel[userID] = channelID
-	} else {
-		if participants, ok := sh.voice.channels[channelID]; ok {
-			delete(participants, userID)
+	// Usuń z poprzedniego kanału
+	if oldChannelID, exists := sh.voice.userChannel[p.UserID]; exists && oldChannelID != channelID {
+		if participants, ok := sh.voice.channels[oldChannelID]; ok {
+			delete(participants, p.UserID)
 			if len(participants) == 0 {
-				delete(sh.voice.channels, channelID)
+				delete(sh.voice.channels, oldChannelID)
 			}
 		}
-		delete(sh.voice.userChannel, userID)
 	}
+
+	if sh.voice.channels[channelID] == nil {
+		sh.voice.channels[channelID] = make(map[int]*models.VoiceParticipant)
+	}
+	sh.voice.channels[channelID][p.UserID] = &p
+	sh.voice.userChannel[p.UserID] = channelID
 }
 
-// syncMuteState — synchronizuj stan mute
-func (sh *SignalingHandler) syncMuteState(channelID, userID int, muted bool) {
+// syncLeave — usuń uczestnika ze starego VoiceState
+func (sh *SignalingHandler) syncLeave(channelID, userID int) {
 	sh.voice.mu.Lock()
 	defer sh.voice.mu.Unlock()
 
 	if participants, ok := sh.voice.channels[channelID]; ok {
-		if p, ok := participants[userID]; ok {
-			p.Muted = muted
+		delete(participants, userID)
+		if len(participants) == 0 {
+			delete(sh.voice.channels, channelID)
 		}
 	}
+	if sh.voice.userChannel[userID] == channelID {
+		delete(sh.voice.userChannel, userID)
+	}
 }
 
 func mustMarshal(v interface{}) json.RawMessage {
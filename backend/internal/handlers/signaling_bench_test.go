@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kodama-backend/internal/signaling"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsConn — połączenie WebSocket po stronie serwera (klient trzymany do końca testu)
+func wsConn(tb testing.TB) *websocket.Conn {
+	tb.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			tb.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	tb.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { client.Close() })
+	return <-conns
+}
+
+// benchRoom — pokój z peers szybkimi klientami (kolejki opróżnia drain) i
+// opcjonalnie jednym wolnym, który nie odbiera nic i ma pełną kolejkę
+func benchRoom(b *testing.B, peers int, slow bool) (sh *SignalingHandler, fast []*VoiceClient, slowClient *VoiceClient) {
+	b.Helper()
+	hub := NewSignalingHub()
+	room := &VoiceRoom{clients: make(map[int]*VoiceClient), mode: "mesh"}
+	hub.rooms[1] = room
+
+	for id := 1; id <= peers; id++ {
+		c := &VoiceClient{UserID: id, out: make(chan []byte, sendQueueSize)}
+		room.clients[id] = c
+		fast = append(fast, c)
+	}
+	if slow {
+		// Pierwsza wiadomość ponad limit kolejki rozłącza klienta (potrzebne prawdziwe połączenie)
+		slowClient = &VoiceClient{UserID: peers + 1, Conn: wsConn(b), out: make(chan []byte, sendQueueSize)}
+		for range sendQueueSize {
+			slowClient.out <- []byte("{}")
+		}
+		room.clients[slowClient.UserID] = slowClient
+	}
+	return &SignalingHandler{hub: hub}, fast, slowClient
+}
+
+// drain — opróżnia kolejki szybkich klientów (rola writePump)
+func drain(clients []*VoiceClient) {
+	for _, c := range clients {
+		for len(c.out) > 0 {
+			<-c.out
+		}
+	}
+}
+
+// BenchmarkBroadcastToRoom — czas rozesłania jednej wiadomości do pokoju.
+// Wariant slow-consumer ma być porównywalny z fast: wolny klient jest
+// rozłączany zamiast blokować pozostałych.
+func BenchmarkBroadcastToRoom(b *testing.B) {
+	// Ostrzeżenia o rozłączeniu wolnego klienta zaciemniałyby wyniki
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	msg := signaling.Message{Type: signaling.TypeMuteState, From: 1, Muted: true}
+	for _, peers := range []int{10, 100, 1000} {
+		for _, slow := range []bool{false, true} {
+			name := fmt.Sprintf("peers=%d/fast", peers)
+			if slow {
+				name = fmt.Sprintf("peers=%d/slow-consumer", peers)
+			}
+			b.Run(name, func(b *testing.B) {
+				sh, fast, slowClient := benchRoom(b, peers, slow)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := range b.N {
+					if i%(sendQueueSize/2) == 0 {
+						b.StopTimer()
+						drain(fast)
+						b.StartTimer()
+					}
+					sh.broadcastToRoom(1, 0, msg)
+				}
+				b.StopTimer()
+				for _, c := range fast {
+					if _, closed := c.connState(nil); closed {
+						b.Fatalf("szybki klient %d został rozłączony", c.UserID)
+					}
+				}
+				if slowClient != nil {
+					if _, closed := slowClient.connState(slowClient.Conn); !closed {
+						b.Fatal("wolny klient nie został rozłączony")
+					}
+				}
+			})
+		}
+	}
+}
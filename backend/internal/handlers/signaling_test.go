@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kodama-backend/internal/auth"
+	"kodama-backend/internal/config"
+	"kodama-backend/internal/database"
+	"kodama-backend/internal/middleware"
+	"kodama-backend/internal/models"
+	"kodama-backend/internal/repository"
+	"kodama-backend/internal/sfu"
+	"kodama-backend/internal/signaling"
+	"kodama-backend/internal/signaling/client"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// newSignalingServer — SignalingHandler z trasami jak w cmd/server (za podanymi
+// middleware) na repozytorium w pamięci
+func newSignalingServer(t *testing.T, cfg *config.Config, mws ...mux.MiddlewareFunc) (*httptest.Server, *repository.Store) {
+	t.Helper()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	auth.Configure("test-secret", time.Hour)
+
+	db, err := database.Open("nop", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	voiceSFU, err := sfu.New(sfu.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := repository.NewMemory()
+	cfg.RecordingsDir = t.TempDir()
+	sh := NewSignalingHandler(cfg, db, store, NewSignalingHub(), NewVoiceState(), voiceSFU)
+
+	r := mux.NewRouter()
+	r.Use(mws...)
+	protected := r.PathPrefix("/api").Subrouter()
+	protected.Use(middleware.AuthMiddleware)
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/channels/{channelId:[0-9]+}/voice/recordings", sh.ListRecordings).Methods("GET")
+	protected.HandleFunc("/voice/mute", sh.ToggleMute).Methods("POST")
+	protected.HandleFunc("/voice/deafen", sh.ToggleDeafen).Methods("POST")
+	protected.HandleFunc("/servers/{serverId:[0-9]+}/voice/members/{userId:[0-9]+}/mute", sh.ServerMuteMember).Methods("POST")
+	r.HandleFunc("/api/ws/voice/{channelId:[0-9]+}", sh.HandleWebSocket)
+
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv, store
+}
+
+// dialVoiceErr łączy użytkownika z kanałem głosowym przez klienta signaling
+func dialVoiceErr(t *testing.T, srv *httptest.Server, user *models.User, channelID int, opts client.Options) (*client.Client, error) {
+	t.Helper()
+	token, err := auth.GenerateToken(user.ID, user.Email, user.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Token = token
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := client.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/api", channelID, opts)
+	if err == nil {
+		t.Cleanup(func() { c.Close() })
+	}
+	return c, err
+}
+
+func dialVoice(t *testing.T, srv *httptest.Server, user *models.User, channelID int, opts client.Options) *client.Client {
+	t.Helper()
+	c, err := dialVoiceErr(t, srv, user, channelID, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// waitFrame odczytuje ramki do pierwszej danego typu
+func waitFrame(ctx context.Context, t *testing.T, c *client.Client, msgType string) *signaling.Message {
+	t.Helper()
+	msg, err := c.WaitFor(ctx, msgType)
+	if err != nil {
+		t.Fatalf("oczekiwano %s: %v", msgType, err)
+	}
+	return msg
+}
+
+// newVoiceChannel — właściciel serwera i jego kanał głosowy w trybie mesh
+func newVoiceChannel(t *testing.T, store *repository.Store) (*models.User, *models.Channel) {
+	t.Helper()
+	ctx := context.Background()
+	owner, err := store.Users.Create(ctx, "wlasciciel@example.com", "wlasciciel", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := store.Servers.Create(ctx, "Serwer", owner.ID, "kod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel, err := store.Channels.Create(ctx, server.ID, "głosowy", "voice", nil, "mesh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return owner, channel
+}
+
+// TestSignalingSubprotocol — nieznana wersja protokołu jest odrzucana przed
+// upgrade, obsługiwana zostaje wynegocjowana
+func TestSignalingSubprotocol(t *testing.T) {
+	srv, store := newSignalingServer(t, &config.Config{})
+	owner, channel := newVoiceChannel(t, store)
+
+	if _, err := dialVoiceErr(t, srv, owner, channel.ID, client.Options{Version: 99}); err == nil || !strings.Contains(err.Error(), "HTTP 400") {
+		t.Fatalf("Dial(wersja 99) = %v, oczekiwano odrzucenia z HTTP 400", err)
+	}
+
+	c := dialVoice(t, srv, owner, channel.ID, client.Options{})
+	if c.Version() != signaling.CurrentVersion {
+		t.Errorf("wersja = %d, oczekiwano %d", c.Version(), signaling.CurrentVersion)
+	}
+}
+
+// TestSignalingErrorFrames — błędna wiadomość dostaje ramkę error z kodem,
+// a połączenie trwa dalej
+func TestSignalingErrorFrames(t *testing.T) {
+	srv, store := newSignalingServer(t, &config.Config{})
+	owner, channel := newVoiceChannel(t, store)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := dialVoice(t, srv, owner, channel.ID, client.Options{})
+	waitFrame(ctx, t, c, signaling.TypeRoomPeers)
+
+	cases := []struct {
+		name string
+		send func() error
+		code string
+	}{
+		{"nieznany typ", func() error { return c.Send(signaling.Message{Type: "teleport"}) }, signaling.ErrCodeUnknownType},
+		{"puste SDP", func() error { return c.Offer(owner.ID+1, "") }, signaling.ErrCodeInvalidPayload},
+		{"payload nie jest SDP", func() error {
+			return c.Send(signaling.Message{Type: signaling.TypeOffer, To: owner.ID + 1, Payload: []byte(`[1,2]`)})
+		}, signaling.ErrCodeInvalidPayload},
+	}
+	for _, tc := range cases {
+		if err := tc.send(); err != nil {
+			t.Fatalf("%s: wysyłanie: %v", tc.name, err)
+		}
+		_, err := c.WaitFor(ctx, signaling.TypeError)
+		var protoErr *signaling.Error
+		if !errors.As(err, &protoErr) {
+			t.Fatalf("%s: oczekiwano ramki error, otrzymano %v", tc.name, err)
+		}
+		if protoErr.Code != tc.code {
+			t.Errorf("%s: kod = %q, oczekiwano %q", tc.name, protoErr.Code, tc.code)
+		}
+	}
+}
+
+// TestSignalingOversizedFrame — wiadomość ponad MaxMessageSize zamyka połączenie
+// kodem 1009
+func TestSignalingOversizedFrame(t *testing.T) {
+	srv, store := newSignalingServer(t, &config.Config{})
+	owner, channel := newVoiceChannel(t, store)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := dialVoice(t, srv, owner, channel.ID, client.Options{})
+	waitFrame(ctx, t, c, signaling.TypeRoomPeers)
+
+	if err := c.Offer(owner.ID+1, strings.Repeat("a", signaling.MaxMessageSize)); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err := c.Recv(ctx)
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+			t.Fatalf("oczekiwano zamknięcia 1009, otrzymano %v", err)
+		}
+		return
+	}
+}
+
+// TestSignalingRESTMute — mute/deafen przez REST zmienia stan w pokoju i trafia do
+// pozostałych uczestników; wyciszony przez moderatora nie odcisza się sam
+func TestSignalingRESTMute(t *testing.T) {
+	srv, store := newSignalingServer(t, &config.Config{})
+	owner, channel := newVoiceChannel(t, store)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	member, err := store.Users.Create(ctx, "czlonek@example.com", "czlonek", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Members.Add(ctx, channel.ServerID, member.ID, "member")
+
+	o := dialVoice(t, srv, owner, channel.ID, client.Options{})
+	waitFrame(ctx, t, o, signaling.TypeRoomPeers)
+	m := dialVoice(t, srv, member, channel.ID, client.Options{})
+	waitFrame(ctx, t, m, signaling.TypeRoomPeers)
+	waitFrame(ctx, t, o, signaling.TypePeerJoined)
+
+	post := func(user *models.User, path, body string) int {
+		t.Helper()
+		token, err := auth.GenerateToken(user.ID, user.Email, user.Username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest("POST", srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := post(member, "/api/voice/mute", `{"muted":true}`); code != http.StatusOK {
+		t.Fatalf("mute: status %d", code)
+	}
+	if msg := waitFrame(ctx, t, o, signaling.TypeMuteState); msg.From != member.ID || !msg.Muted {
+		t.Errorf("mute-state = %+v, oczekiwano wyciszenia %d", msg, member.ID)
+	}
+	if code := post(member, "/api/voice/deafen", `{"deafened":true}`); code != http.StatusOK {
+		t.Fatalf("deafen: status %d", code)
+	}
+	if msg := waitFrame(ctx, t, o, signaling.TypeDeafenState); msg.From != member.ID || !msg.Deafened {
+		t.Errorf("deafen-state = %+v, oczekiwano wygłuszenia %d", msg, member.ID)
+	}
+
+	path := fmt.Sprintf("/api/servers/%d/voice/members/%d/mute", channel.ServerID, member.ID)
+	if code := post(owner, path, `{"muted":true}`); code != http.StatusOK {
+		t.Fatalf("server mute: status %d", code)
+	}
+	if code := post(member, "/api/voice/mute", `{"muted":false}`); code != http.StatusForbidden {
+		t.Errorf("odciszenie po server mute: status %d, oczekiwano 403", code)
+	}
+	if code := post(owner, "/api/voice/mute", `{"muted":true}`); code != http.StatusOK {
+		t.Errorf("mute właściciela: status %d", code)
+	}
+	// Do mute-state właściciela członek nie dostaje rozgłoszonego odciszenia
+	for {
+		msg := waitFrame(ctx, t, m, signaling.TypeMuteState)
+		if msg.From == owner.ID {
+			break
+		}
+		if !msg.Muted {
+			t.Fatalf("odrzucone odciszenie %d zostało rozgłoszone", msg.From)
+		}
+	}
+}
+
+// TestSignalingRateLimit — po przekroczeniu limitu klient dostaje jeden błąd
+// rate_limited, a seria odrzuconych wiadomości równa limitowi zamyka połączenie 4007
+func TestSignalingRateLimit(t *testing.T) {
+	srv, store := newSignalingServer(t, &config.Config{
+		RateLimitEnabled:   true,
+		RateLimitSignaling: config.RateLimit{Limit: 3, Period: time.Minute},
+	})
+	owner, channel := newVoiceChannel(t, store)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := dialVoice(t, srv, owner, channel.ID, client.Options{})
+	waitFrame(ctx, t, c, signaling.TypeRoomPeers)
+
+	// 3 przepuszczone, 3 odrzucone — ostatnia odrzucona zamyka połączenie
+	for i := 0; i < 6; i++ {
+		if err := c.SetMuted(i%2 == 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var rateLimited int
+	for {
+		_, err := c.Recv(ctx)
+		var protoErr *signaling.Error
+		switch {
+		case err == nil:
+			continue
+		case errors.As(err, &protoErr):
+			if protoErr.Code != signaling.ErrCodeRateLimited {
+				t.Fatalf("błąd %q, oczekiwano %q", protoErr.Code, signaling.ErrCodeRateLimited)
+			}
+			rateLimited++
+			continue
+		case !websocket.IsCloseError(err, CloseRateLimited):
+			t.Fatalf("oczekiwano zamknięcia %d, otrzymano %v", CloseRateLimited, err)
+		}
+		break
+	}
+	if rateLimited != 1 {
+		t.Errorf("błędów rate_limited: %d, oczekiwano 1 na serię", rateLimited)
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"kodama-backend/internal/signaling"
+)
+
+// ──────────────────────────────────────────────
+// Wskaźniki mówienia (speaking)
+// ──────────────────────────────────────────────
+
+// speakingMinInterval — minimalny odstęp między rozgłoszeniami "speaking" jednego klienta
+const speakingMinInterval = 250 * time.Millisecond
+
+// speakingLimiter — ogranicza rozgłoszenia "speaking". Zmiany w oknie są łączone:
+// po jego upływie wysyłany jest tylko aktualny stan (o ile różni się od ostatnio wysłanego).
+type speakingLimiter struct {
+	mu      sync.Mutex
+	sent    bool // ostatnio rozgłoszony stan
+	sentAt  time.Time
+	pending bool // zaplanowane opóźnione rozgłoszenie
+}
+
+// setSpeaking — aktualizuje stan mówienia klienta (wyciszony nie mówi) i rozgłasza zmianę
+func (sh *SignalingHandler) setSpeaking(client *VoiceClient, speaking bool) {
+	channelID := sh.hub.clientChannel(client)
+	room, ok := sh.hub.getRoom(channelID)
+	if !ok {
+		return
+	}
+
+	room.mu.Lock()
+	if client.Muted || client.ServerMuted {
+		speaking = false
+	}
+	changed := client.Speaking != speaking
+	client.Speaking = speaking
+	p := client.participant()
+	room.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	sh.syncParticipant(channelID, p)
+
+	l := &client.speaking
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pending {
+		return
+	}
+	if wait := speakingMinInterval - time.Since(l.sentAt); wait > 0 {
+		l.pending = true
+		time.AfterFunc(wait, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.pending = false
+			sh.flushSpeakingLocked(client)
+		})
+		return
+	}
+	sh.flushSpeakingLocked(client)
+}
+
+// flushSpeakingLocked — rozgłasza aktualny stan mówienia, wywoływać pod client.speaking.mu
+func (sh *SignalingHandler) flushSpeakingLocked(client *VoiceClient) {
+	channelID := sh.hub.clientChannel(client)
+	room, ok := sh.hub.getRoom(channelID)
+	if !ok {
+		return
+	}
+	room.mu.RLock()
+	speaking := client.Speaking
+	room.mu.RUnlock()
+
+	l := &client.speaking
+	if speaking == l.sent {
+		return
+	}
+	l.sent = speaking
+	l.sentAt = time.Now()
+
+	sh.broadcastToRoom(channelID, 0, signaling.Message{
+		Type:      signaling.TypeSpeaking,
+		From:      client.UserID,
+		FromName:  client.Username,
+		ChannelID: channelID,
+		Speaking:  speaking,
+	})
+}
+
+// sfuSpeaking — zmiana aktywności głosowej wykryta przez SFU
+func (sh *SignalingHandler) sfuSpeaking(channelID, userID int, speaking bool) {
+	room, ok := sh.hub.getRoom(channelID)
+	if !ok {
+		return
+	}
+	room.mu.RLock()
+	client, ok := room.clients[userID]
+	room.mu.RUnlock()
+	if ok {
+		sh.setSpeaking(client, speaking)
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+
+	"kodama-backend/internal/signaling"
+)
+
+// ──────────────────────────────────────────────
+// Kanały sceniczne (stage): mówcy i słuchacze
+// ──────────────────────────────────────────────
+
+// isVoiceChannelType — czy kanał ma pokój głosowy (zwykły lub sceniczny)
+func isVoiceChannelType(chType string) bool {
+	return chType == "voice" || chType == "stage"
+}
+
+// initialStageRole — rola przy wejściu na kanał ("" poza sceną).
+// Moderatorzy sceny od razu mówią, pozostali słuchają.
+func initialStageRole(chType, memberRole string) string {
+	if chType != "stage" {
+		return ""
+	}
+	if roleHasPermission(memberRole, PermManageStage) {
+		return signaling.StageSpeaker
+	}
+	return signaling.StageAudience
+}
+
+// setStageRole — zmienia rolę klienta i opuszcza jego rękę (wywoływać pod room.mu).
+// Słuchacz jest wyciszony i traci ogłoszone ścieżki.
+func (c *VoiceClient) setStageRole(role string) {
+	c.StageRole = role
+	c.HandRaised = false
+	if role == signaling.StageAudience {
+		c.Muted = true
+		c.clearTracks()
+	}
+}
+
+// updateMediaState — zmiana stanu mediów zgłoszona przez klienta. Słuchacz sceny
+// nie może włączyć mikrofonu, kamery ani ekranu (false = zmiana odrzucona).
+func (sh *SignalingHandler) updateMediaState(channelID int, client *VoiceClient, enabling bool, update func(c *VoiceClient)) bool {
+	allowed := true
+	sh.updateClientState(channelID, client, func(c *VoiceClient) {
+		if enabling && c.StageRole == signaling.StageAudience {
+			allowed = false
+			return
+		}
+		update(c)
+	})
+	return allowed
+}
+
+// applySFUState — przekazywanie mediów klienta w SFU według jego stanu: wyciszony
+// przez moderatora nie nadaje dźwięku, słuchacz sceny nie nadaje nic, wygłuszony
+// przez moderatora nie dostaje dźwięku. Wywoływać po sfu.Join i po każdej zmianie tych flag.
+func (sh *SignalingHandler) applySFUState(channelID int, client *VoiceClient) {
+	room, ok := sh.hub.getRoom(channelID)
+	if !ok || room.mode != "sfu" {
+		return
+	}
+	room.mu.RLock()
+	muted := client.ServerMuted
+	silenced := client.StageRole == signaling.StageAudience
+	deafened := client.ServerDeafened
+	room.mu.RUnlock()
+
+	if err := sh.sfu.SetMuted(channelID, client.UserID, muted); err != nil {
+		slog.Error("Błąd wyciszania w SFU", "channel_id", channelID, "user_id", client.UserID, "error", err)
+	}
+	if err := sh.sfu.SetSilenced(channelID, client.UserID, silenced); err != nil {
+		slog.Error("Błąd blokowania mediów słuchacza w SFU", "channel_id", channelID, "user_id", client.UserID, "error", err)
+	}
+	if err := sh.sfu.SetDeafened(channelID, client.UserID, deafened); err != nil {
+		slog.Error("Błąd wygłuszania w SFU", "channel_id", channelID, "user_id", client.UserID, "error", err)
+	}
+}
+
+// handleRaiseHand — słuchacz podnosi lub opuszcza rękę; cały pokój widzi prośbę
+func (sh *SignalingHandler) handleRaiseHand(client *VoiceClient, channelID int, msg *signaling.Message) {
+	allowed := true
+	sh.updateClientState(channelID, client, func(c *VoiceClient) {
+		if c.StageRole != signaling.StageAudience {
+			allowed = false
+			return
+		}
+		c.HandRaised = msg.HandRaised
+	})
+	if !allowed {
+		client.send((&signaling.Error{
+			Code:    signaling.ErrCodeNotAllowed,
+			Message: "Tylko słuchacze sceny mogą prosić o głos",
+			RefType: msg.Type,
+		}).Frame())
+		return
+	}
+	sh.broadcastToRoom(channelID, 0, *msg)
+}
+
+// handleStageSpeaker — moderator zatwierdza lub odbiera głos uczestnikowi sceny.
+// Mówca może też sam zejść ze sceny (to = własne ID, speaker = false).
+func (sh *SignalingHandler) handleStageSpeaker(client *VoiceClient, channelID int, msg *signaling.Message) {
+	fail := func(code, message string) {
+		client.send((&signaling.Error{Code: code, Message: message, RefType: msg.Type}).Frame())
+	}
+
+	room, ok := sh.hub.getRoom(channelID)
+	if !ok {
+		fail(signaling.ErrCodeInvalidTarget, "Nie jesteś w pokoju głosowym")
+		return
+	}
+	room.mu.RLock()
+	target, ok := room.clients[msg.To]
+	room.mu.RUnlock()
+	if !ok {
+		fail(signaling.ErrCodeInvalidTarget, "Odbiorca nie jest w tym pokoju")
+		return
+	}
+
+	stepDown := msg.To == client.UserID && !msg.Speaker
+	if !stepDown {
+		ch, err := sh.channels.GetByID(context.Background(), channelID)
+		if err != nil || !hasPermission(context.Background(), sh.members, client.UserID, ch.ServerID, PermManageStage) {
+			fail(signaling.ErrCodeNotAllowed, "Brak uprawnień do zarządzania sceną")
+			return
+		}
+	}
+
+	role := signaling.StageAudience
+	if msg.Speaker {
+		role = signaling.StageSpeaker
+	}
+	onStage := true
+	p := sh.updateClientState(channelID, target, func(c *VoiceClient) {
+		if c.StageRole == "" {
+			onStage = false
+			return
+		}
+		c.setStageRole(role)
+	})
+	if !onStage {
+		fail(signaling.ErrCodeNotAllowed, "To nie jest kanał sceniczny")
+		return
+	}
+
+	sh.applySFUState(channelID, target)
+	if role == signaling.StageAudience {
+		sh.setSpeaking(target, false)
+	}
+
+	// Payload to pełny stan uczestnika — odebranie głosu usuwa też jego ścieżki
+	sh.broadcastToRoom(channelID, 0, signaling.Message{
+		Type:      signaling.TypeStageRole,
+		From:      client.UserID,
+		FromName:  client.Username,
+		To:        target.UserID,
+		ChannelID: channelID,
+		StageRole: role,
+		Muted:     p.Muted,
+		Payload:   mustMarshal(p),
+	})
+
+	client.log().Info("Zmiana roli na scenie", "channel_id", channelID, "target_user_id", target.UserID, "stage_role", role)
+}
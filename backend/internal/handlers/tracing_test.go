@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"kodama-backend/internal/auth"
+	"kodama-backend/internal/config"
+	"kodama-backend/internal/repository"
+	"kodama-backend/internal/signaling"
+	"kodama-backend/internal/signaling/client"
+	"kodama-backend/internal/tracing"
+
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ── Sterownik SQL bez bazy: każde zapytanie zwraca zero wierszy ──
+
+type nopDriver struct{}
+
+func (nopDriver) Open(string) (driver.Conn, error) { return nopConn{}, nil }
+
+type nopConn struct{}
+
+func (nopConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (nopConn) Close() error                        { return nil }
+func (nopConn) Begin() (driver.Tx, error)           { return nopTx{}, nil }
+
+func (nopConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return nopRows{}, nil
+}
+
+func (nopConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+type nopTx struct{}
+
+func (nopTx) Commit() error   { return nil }
+func (nopTx) Rollback() error { return nil }
+
+type nopRows struct{}
+
+func (nopRows) Columns() []string         { return nil }
+func (nopRows) Close() error              { return nil }
+func (nopRows) Next([]driver.Value) error { return io.EOF }
+
+func init() {
+	sql.Register("nop", nopDriver{})
+}
+
+var (
+	spansOnce sync.Once
+	spans     *tracetest.InMemoryExporter
+)
+
+// recordSpans instaluje globalny TracerProvider z eksporterem w pamięci (raz na
+// proces — globalny provider można podmienić tylko raz) i czyści zebrane spany
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	spansOnce.Do(func() {
+		spans = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)))
+		tracing.Setup(context.Background(), tracing.Options{}) // propagator W3C
+	})
+	spans.Reset()
+	return spans
+}
+
+// waitSpan czeka na zakończony span o nazwie spełniającej match
+func waitSpan(t *testing.T, exp *tracetest.InMemoryExporter, match func(name string) bool) tracetest.SpanStub {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, s := range exp.GetSpans() {
+			if match(s.Name) {
+				return s
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var names []string
+	for _, s := range exp.GetSpans() {
+		names = append(names, s.Name)
+	}
+	t.Fatalf("brak oczekiwanego spanu; zebrane: %v", names)
+	return tracetest.SpanStub{}
+}
+
+func named(want string) func(string) bool {
+	return func(name string) bool { return name == want }
+}
+
+// newTracedSignaling — serwer signaling za QueryParamPropagation i otelmux
+func newTracedSignaling(t *testing.T) (*httptest.Server, *repository.Store) {
+	return newSignalingServer(t, &config.Config{}, tracing.QueryParamPropagation, otelmux.Middleware("kodama-test"))
+}
+
+// TestTracingRouteAndSQLSpans — span żądania nazwany szablonem trasy, zapytanie
+// SQL handlera jako jego span potomny
+func TestTracingRouteAndSQLSpans(t *testing.T) {
+	exp := recordSpans(t)
+	srv, store := newTracedSignaling(t)
+	ctx := context.Background()
+
+	owner, _ := store.Users.Create(ctx, "wlasciciel@example.com", "wlasciciel", "x")
+	server, _ := store.Servers.Create(ctx, "Serwer", owner.ID, "kod")
+	channel, _ := store.Channels.Create(ctx, server.ID, "głosowy", "voice", nil, "mesh")
+	token, err := auth.GenerateToken(owner.ID, owner.Email, owner.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/api/servers/%d/channels/%d/voice/recordings", srv.URL, server.ID, channel.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	route := waitSpan(t, exp, named("/api/servers/{serverId:[0-9]+}/channels/{channelId:[0-9]+}/voice/recordings"))
+	if route.SpanKind != trace.SpanKindServer {
+		t.Errorf("rodzaj spanu trasy = %s, oczekiwano server", route.SpanKind)
+	}
+	query := waitSpan(t, exp, func(name string) bool { return strings.HasPrefix(name, "sql.") })
+	if query.Parent.SpanID() != route.SpanContext.SpanID() || query.SpanContext.TraceID() != route.SpanContext.TraceID() {
+		t.Errorf("span %s nie jest potomkiem spanu trasy (rodzic %s, trasa %s)",
+			query.Name, query.Parent.SpanID(), route.SpanContext.SpanID())
+	}
+}
+
+// TestTracingWebSocketRelay — traceparent z parametru URL połączenia WebSocket
+// jest rodzicem spanu upgrade, a przekazanie oferty do peera jego potomkiem
+func TestTracingWebSocketRelay(t *testing.T) {
+	exp := recordSpans(t)
+	srv, store := newTracedSignaling(t)
+	ctx := context.Background()
+
+	alice, _ := store.Users.Create(ctx, "alice@example.com", "alice", "x")
+	bob, _ := store.Users.Create(ctx, "bob@example.com", "bob", "x")
+	server, _ := store.Servers.Create(ctx, "Serwer", alice.ID, "kod")
+	store.Members.Add(ctx, server.ID, bob.ID, "member")
+	channel, _ := store.Channels.Create(ctx, server.ID, "głosowy", "voice", nil, "mesh")
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	a := dialVoice(t, srv, alice, channel.ID, client.Options{
+		Query: url.Values{"traceparent": {"00-" + traceID + "-" + spanID + "-01"}},
+	})
+	waitFrame(ctx, t, a, signaling.TypeRoomPeers)
+	b := dialVoice(t, srv, bob, channel.ID, client.Options{})
+	waitFrame(ctx, t, b, signaling.TypeRoomPeers)
+	waitFrame(ctx, t, a, signaling.TypePeerJoined)
+
+	if err := a.Offer(bob.ID, "v=0"); err != nil {
+		t.Fatal(err)
+	}
+	if offer := waitFrame(ctx, t, b, signaling.TypeOffer); offer.From != alice.ID {
+		t.Fatalf("oferta od %d, oczekiwano %d", offer.From, alice.ID)
+	}
+
+	relay := waitSpan(t, exp, named("signaling.sendToPeer"))
+	if got := relay.SpanContext.TraceID().String(); got != traceID {
+		t.Errorf("trace ID przekazania = %s, oczekiwano %s (z traceparent połączenia)", got, traceID)
+	}
+	// Span upgrade jest zakończony, choć połączenie trwa
+	var upgrade tracetest.SpanStub
+	for _, s := range exp.GetSpans() {
+		if s.SpanContext.SpanID() == relay.Parent.SpanID() {
+			upgrade = s
+		}
+	}
+	if upgrade.Name != "/api/ws/voice/{channelId:[0-9]+}" {
+		t.Fatalf("rodzic przekazania (%s) nie jest zakończonym spanem upgrade, znaleziono %q", relay.Parent.SpanID(), upgrade.Name)
+	}
+	if got := upgrade.Parent.SpanID().String(); got != spanID || !upgrade.Parent.IsRemote() {
+		t.Errorf("rodzic spanu upgrade = %s (zdalny: %t), oczekiwano %s", got, upgrade.Parent.IsRemote(), spanID)
+	}
+}
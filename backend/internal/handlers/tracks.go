@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"kodama-backend/internal/models"
+	"kodama-backend/internal/signaling"
+)
+
+// ──────────────────────────────────────────────
+// Negocjacja ścieżek kamery i ekranu (track-add, track-remove, track-mute)
+// ──────────────────────────────────────────────
+
+// maxTracksPerClient — mikrofon, kamera, ekran i dźwięk ekranu
+const maxTracksPerClient = 4
+
+// clearTracks — usuwa ogłoszone ścieżki klienta (wywoływać pod room.mu)
+func (c *VoiceClient) clearTracks() {
+	c.Tracks = nil
+	c.Video = false
+	c.ScreenShare = false
+}
+
+// sendsVideo — czy klient nadaje jakąkolwiek ścieżkę wideo (wywoływać pod room.mu)
+func (c *VoiceClient) sendsVideo() bool {
+	for _, t := range c.Tracks {
+		if t.Kind == "video" {
+			return true
+		}
+	}
+	return false
+}
+
+// trackIndex — pozycja ścieżki w c.Tracks (-1 gdy brak)
+func (c *VoiceClient) trackIndex(trackID string) int {
+	for i, t := range c.Tracks {
+		if t.TrackID == trackID {
+			return i
+		}
+	}
+	return -1
+}
+
+// syncMediaFlags — flagi video/screen_share wynikają z ogłoszonych, niewyciszonych ścieżek
+func (c *VoiceClient) syncMediaFlags() {
+	c.Video, c.ScreenShare = false, false
+	for _, t := range c.Tracks {
+		if t.Muted {
+			continue
+		}
+		switch t.Source {
+		case signaling.SourceCamera:
+			c.Video = true
+		case signaling.SourceScreen:
+			c.ScreenShare = true
+		}
+	}
+}
+
+// addTrack — rejestruje ścieżkę klienta z kontrolą limitu nadających wideo
+// (0 = bez limitu). Ponowne ogłoszenie tej samej ścieżki aktualizuje jej opis.
+func (h *SignalingHub) addTrack(channelID int, client *VoiceClient, track models.VoiceTrack, videoLimit int) (models.VoiceParticipant, *signaling.Error) {
+	room, ok := h.getRoom(channelID)
+	if !ok {
+		return client.participant(), &signaling.Error{Code: signaling.ErrCodeInvalidTarget, Message: "Nie jesteś w pokoju głosowym"}
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if client.StageRole == signaling.StageAudience {
+		return client.participant(), &signaling.Error{Code: signaling.ErrCodeNotSpeaker, Message: "Słuchacze sceny nie mogą nadawać"}
+	}
+
+	if i := client.trackIndex(track.TrackID); i >= 0 {
+		if client.Tracks[i].Kind != track.Kind {
+			return client.participant(), &signaling.Error{Code: signaling.ErrCodeInvalidPayload, Message: "Nie można zmienić typu ogłoszonej ścieżki"}
+		}
+		client.Tracks[i] = track
+		client.syncMediaFlags()
+		return client.participant(), nil
+	}
+
+	if len(client.Tracks) >= maxTracksPerClient {
+		return client.participant(), &signaling.Error{Code: signaling.ErrCodeTrackLimit, Message: "Osiągnięto limit ścieżek"}
+	}
+
+	// Limit dotyczy nadawców, nie ścieżek — kamera i ekran jednej osoby liczą się raz
+	if track.Kind == "video" && videoLimit > 0 && !client.sendsVideo() {
+		senders := 0
+		for _, c := range room.clients {
+			if c.sendsVideo() {
+				senders++
+			}
+		}
+		if senders >= videoLimit {
+			return client.participant(), &signaling.Error{Code: signaling.ErrCodeVideoLimit, Message: "Kanał osiągnął limit osób nadających wideo"}
+		}
+	}
+
+	client.Tracks = append(client.Tracks, track)
+	client.syncMediaFlags()
+	return client.participant(), nil
+}
+
+// updateTrack — usuwa lub zmienia ogłoszoną ścieżkę klienta (false gdy jej nie ma)
+func (h *SignalingHub) updateTrack(channelID int, client *VoiceClient, trackID string, update func(c *VoiceClient, i int)) (models.VoiceParticipant, bool) {
+	room, ok := h.getRoom(channelID)
+	if !ok {
+		return client.participant(), false
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	i := client.trackIndex(trackID)
+	if i < 0 {
+		return client.participant(), false
+	}
+	update(client, i)
+	client.syncMediaFlags()
+	return client.participant(), true
+}
+
+// receiverCounts — liczba ścieżek audio i wideo, które klient chce wysyłać
+func receiverCounts(tracks []models.VoiceTrack) (audio, video int) {
+	for _, t := range tracks {
+		if t.Kind == "video" {
+			video++
+		} else {
+			audio++
+		}
+	}
+	return audio, video
+}
+
+// mediaLimits — limity mediów zapisane w ustawieniach kanału
+func mediaLimits(ch *models.Channel) models.MediaLimits {
+	return models.MediaLimits{AudioBitrate: ch.Bitrate, VideoLimit: ch.VideoLimit, VideoBitrate: ch.VideoBitrate}
+}
+
+// channelLimits — limity mediów kanału z bazy (przy błędzie wartości domyślne)
+func (sh *SignalingHandler) channelLimits(channelID int) models.MediaLimits {
+	ch, err := sh.channels.GetByID(context.Background(), channelID)
+	if err != nil {
+		slog.Error("Błąd pobierania limitów kanału", "channel_id", channelID, "error", err)
+		return models.MediaLimits{AudioBitrate: 64000, VideoBitrate: 1000000, VideoLimit: 4}
+	}
+	return mediaLimits(ch)
+}
+
+// handleTrackAdd — ogłoszenie nowej ścieżki. Potwierdzeniem dla nadawcy jest
+// echo track-add (dopiero wtedy klient zaczyna wysyłać media), odmową ramka "error".
+func (sh *SignalingHandler) handleTrackAdd(client *VoiceClient, channelID int, msg *signaling.Message) {
+	var track models.VoiceTrack
+	json.Unmarshal(msg.Payload, &track)
+
+	limits := sh.channelLimits(channelID)
+	p, perr := sh.hub.addTrack(channelID, client, track, limits.VideoLimit)
+	if perr != nil {
+		perr.RefType = msg.Type
+		client.send(perr.Frame())
+		return
+	}
+	sh.syncParticipant(channelID, p)
+
+	msg.Payload = mustMarshal(track)
+	sh.broadcastToRoom(channelID, 0, *msg)
+
+	// W trybie SFU serwer musi mieć transceiver odbiorczy dla każdej ogłoszonej ścieżki
+	if room, ok := sh.hub.getRoom(channelID); ok && room.mode == "sfu" {
+		audio, video := receiverCounts(p.Tracks)
+		if err := sh.sfu.EnsureReceivers(channelID, client.UserID, audio, video); err != nil {
+			client.log().Error("Błąd odbiorników SFU", "channel_id", channelID, "error", err)
+		}
+	}
+}
+
+// handleTrackRemove — nadawca przestał wysyłać ścieżkę (zwalnia miejsce w limicie wideo)
+func (sh *SignalingHandler) handleTrackRemove(client *VoiceClient, channelID int, msg *signaling.Message) {
+	var track models.VoiceTrack
+	json.Unmarshal(msg.Payload, &track)
+
+	p, ok := sh.hub.updateTrack(channelID, client, track.TrackID, func(c *VoiceClient, i int) {
+		track = c.Tracks[i]
+		c.Tracks = append(c.Tracks[:i], c.Tracks[i+1:]...)
+	})
+	if !ok {
+		sendUnknownTrack(client, msg.Type)
+		return
+	}
+	sh.syncParticipant(channelID, p)
+
+	msg.Payload = mustMarshal(track)
+	sh.broadcastToRoom(channelID, 0, *msg)
+}
+
+// handleTrackMute — wyciszenie pojedynczej ścieżki bez jej usuwania
+func (sh *SignalingHandler) handleTrackMute(client *VoiceClient, channelID int, msg *signaling.Message) {
+	var track models.VoiceTrack
+	json.Unmarshal(msg.Payload, &track)
+	muted := track.Muted
+
+	p, ok := sh.hub.updateTrack(channelID, client, track.TrackID, func(c *VoiceClient, i int) {
+		c.Tracks[i].Muted = muted
+		track = c.Tracks[i]
+	})
+	if !ok {
+		sendUnknownTrack(client, msg.Type)
+		return
+	}
+	sh.syncParticipant(channelID, p)
+
+	msg.Payload = mustMarshal(track)
+	msg.Muted = muted
+	sh.broadcastToRoom(channelID, 0, *msg)
+}
+
+func sendNotSpeaker(client *VoiceClient, refType string) {
+	client.send((&signaling.Error{
+		Code:    signaling.ErrCodeNotSpeaker,
+		Message: "Słuchacze sceny nie mogą nadawać",
+		RefType: refType,
+	}).Frame())
+}
+
+func sendUnknownTrack(client *VoiceClient, refType string) {
+	client.send((&signaling.Error{
+		Code:    signaling.ErrCodeUnknownTrack,
+		Message: "Ścieżka nie została ogłoszona",
+		RefType: refType,
+	}).Frame())
+}
@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"kodama-backend/internal/auth"
+	"kodama-backend/internal/logging"
+	"kodama-backend/internal/models"
+	"kodama-backend/internal/repository"
+	"kodama-backend/internal/signaling"
+
+	"github.com/gorilla/mux"
+)
+
+// ──────────────────────────────────────────────
+// Moderacja kanałów głosowych (server mute/deafen, przenoszenie, rozłączanie)
+// ──────────────────────────────────────────────
+
+// moderationTarget — wspólna część endpointów moderacji: autoryzacja, uprawnienie
+// moderatora i odnalezienie połączonego użytkownika na kanale głosowym serwera
+func (sh *SignalingHandler) moderationTarget(w http.ResponseWriter, r *http.Request, perm Permission) (*auth.Claims, int, *VoiceClient, int, bool) {
+	claims, ok := getClaims(r)
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Brak autoryzacji")
+		return nil, 0, nil, 0, false
+	}
+
+	vars := mux.Vars(r)
+	serverID, err := strconv.Atoi(vars["serverId"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe ID serwera")
+		return nil, 0, nil, 0, false
+	}
+	targetID, err := strconv.Atoi(vars["userId"])
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe ID użytkownika")
+		return nil, 0, nil, 0, false
+	}
+
+	if !hasPermission(r.Context(), sh.members, claims.UserID, serverID, perm) {
+		sendError(w, http.StatusForbidden, "Brak uprawnień do moderacji kanałów głosowych")
+		return nil, 0, nil, 0, false
+	}
+
+	target, channelID, ok := sh.hub.findClient(targetID)
+	if !ok || !sh.channelBelongsToServer(r.Context(), channelID, serverID) {
+		sendError(w, http.StatusNotFound, "Użytkownik nie jest połączony z kanałem głosowym tego serwera")
+		return nil, 0, nil, 0, false
+	}
+
+	return claims, serverID, target, channelID, true
+}
+
+// channelBelongsToServer sprawdza czy kanał należy do serwera
+func (sh *SignalingHandler) channelBelongsToServer(ctx context.Context, channelID, serverID int) bool {
+	_, err := sh.channels.Get(ctx, serverID, channelID)
+	return err == nil
+}
+
+// updateClientState — zmienia flagi klienta pod blokadą pokoju i synchronizuje VoiceState
+func (sh *SignalingHandler) updateClientState(channelID int, client *VoiceClient, update func(c *VoiceClient)) models.VoiceParticipant {
+	room, ok := sh.hub.getRoom(channelID)
+	if !ok {
+		return client.participant()
+	}
+	room.mu.Lock()
+	update(client)
+	p := client.participant()
+	room.mu.Unlock()
+
+	sh.syncParticipant(channelID, p)
+	return p
+}
+
+// ServerMuteMember — wyciszenie użytkownika przez moderatora
+func (sh *SignalingHandler) ServerMuteMember(w http.ResponseWriter, r *http.Request) {
+	claims, _, target, channelID, ok := sh.moderationTarget(w, r, PermMuteMembers)
+	if !ok {
+		return
+	}
+
+	var req models.MuteRequest
+	if err := decodeJSON(r, &req); err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe dane wejściowe")
+		return
+	}
+
+	p := sh.updateClientState(channelID, target, func(c *VoiceClient) {
+		c.ServerMuted = req.Muted
+	})
+	sh.applySFUState(channelID, target)
+	if req.Muted {
+		sh.setSpeaking(target, false)
+	}
+
+	// Cały pokój (łącznie z wyciszonym) dostaje nowy stan
+	sh.broadcastToRoom(channelID, 0, signaling.Message{
+		Type:      signaling.TypeServerMuteState,
+		From:      claims.UserID,
+		FromName:  claims.Username,
+		To:        target.UserID,
+		ChannelID: channelID,
+		Muted:     req.Muted,
+	})
+
+	sendJSON(w, http.StatusOK, p)
+}
+
+// ServerDeafenMember — wygłuszenie użytkownika przez moderatora
+func (sh *SignalingHandler) ServerDeafenMember(w http.ResponseWriter, r *http.Request) {
+	claims, _, target, channelID, ok := sh.moderationTarget(w, r, PermDeafenMembers)
+	if !ok {
+		return
+	}
+
+	var req models.DeafenRequest
+	if err := decodeJSON(r, &req); err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe dane wejściowe")
+		return
+	}
+
+	p := sh.updateClientState(channelID, target, func(c *VoiceClient) {
+		c.ServerDeafened = req.Deafened
+	})
+	sh.applySFUState(channelID, target)
+
+	sh.broadcastToRoom(channelID, 0, signaling.Message{
+		Type:      signaling.TypeServerDeafenState,
+		From:      claims.UserID,
+		FromName:  claims.Username,
+		To:        target.UserID,
+		ChannelID: channelID,
+		Deafened:  req.Deafened,
+	})
+
+	sendJSON(w, http.StatusOK, p)
+}
+
+// MoveMember — przeniesienie użytkownika na inny kanał głosowy tego samego serwera.
+// Połączenie WebSocket zostaje, zmienia się tylko pokój; user_limit nie obowiązuje.
+func (sh *SignalingHandler) MoveMember(w http.ResponseWriter, r *http.Request) {
+	claims, serverID, target, _, ok := sh.moderationTarget(w, r, PermMoveMembers)
+	if !ok {
+		return
+	}
+
+	var req models.MoveMemberRequest
+	if err := decodeJSON(r, &req); err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe dane wejściowe")
+		return
+	}
+
+	channel, err := sh.channels.Get(r.Context(), serverID, req.ChannelID)
+	if errors.Is(err, repository.ErrNotFound) {
+		sendError(w, http.StatusNotFound, "Kanał nie znaleziony")
+		return
+	}
+	if err != nil {
+		logError(r, "Błąd pobierania kanału", err)
+		sendError(w, http.StatusInternalServerError, "Błąd serwera")
+		return
+	}
+	if !isVoiceChannelType(channel.Type) {
+		sendError(w, http.StatusBadRequest, "To nie jest kanał głosowy")
+		return
+	}
+
+	limits := mediaLimits(channel)
+	stageRole := initialStageRole(channel.Type, memberRole(r.Context(), sh.members, target.UserID, serverID))
+	fromChannelID, joined, moved := sh.hub.moveClient(target, req.ChannelID, channel.VoiceMode, stageRole)
+	if !moved {
+		sendError(w, http.StatusConflict, "Użytkownik jest już na tym kanale lub się rozłączył")
+		return
+	}
+	sh.sfu.Leave(fromChannelID, target.UserID)
+
+	p := sh.updateClientState(req.ChannelID, target, func(c *VoiceClient) {})
+
+	sh.broadcastToRoom(fromChannelID, target.UserID, signaling.Message{
+		Type:      signaling.TypePeerLeft,
+		From:      target.UserID,
+		FromName:  target.Username,
+		ChannelID: fromChannelID,
+	})
+	sh.stopRecordingIfEmpty(fromChannelID)
+
+	// Przeniesiony klient zamyka stare połączenia i negocjuje z nowym pokojem
+	target.send(signaling.Message{
+		Type:      signaling.TypeMoved,
+		From:      claims.UserID,
+		FromName:  claims.Username,
+		To:        target.UserID,
+		ChannelID: req.ChannelID,
+		Payload:   mustMarshal(joined.existing),
+		Mode:      joined.mode,
+		Limits:    &limits,
+		StageRole: stageRole,
+	})
+	sh.sendRecordingState(target, req.ChannelID)
+
+	if joined.mode == "sfu" {
+		if err := sh.sfu.Join(req.ChannelID, target.UserID, sh.sfuSignal(target)); err != nil {
+			logging.FromContext(r.Context()).Error("Błąd dołączania do SFU", "channel_id", req.ChannelID, "target_user_id", target.UserID, "error", err)
+		}
+		sh.applySFUState(req.ChannelID, target)
+	}
+
+	sh.broadcastToRoom(req.ChannelID, target.UserID, signaling.Message{
+		Type:      signaling.TypePeerJoined,
+		From:      target.UserID,
+		FromName:  target.Username,
+		ChannelID: req.ChannelID,
+		Payload:   mustMarshal(p),
+	})
+
+	logging.FromContext(r.Context()).Info("Przeniesiono użytkownika", "target_user_id", target.UserID, "from_channel_id", fromChannelID, "channel_id", req.ChannelID)
+
+	sendJSON(w, http.StatusOK, p)
+}
+
+// DisconnectMember — rozłączenie użytkownika z kanału głosowego
+func (sh *SignalingHandler) DisconnectMember(w http.ResponseWriter, r *http.Request) {
+	claims, _, target, channelID, ok := sh.moderationTarget(w, r, PermMoveMembers)
+	if !ok {
+		return
+	}
+
+	target.send(signaling.Message{
+		Type:      signaling.TypeDisconnected,
+		From:      claims.UserID,
+		FromName:  claims.Username,
+		To:        target.UserID,
+		ChannelID: channelID,
+	})
+
+	// Zamknięcie połączenia kończy pętlę odczytu, która sprząta stan i wysyła peer-left
+	target.closeAfterFlush(CloseDisconnected, "Rozłączony przez moderatora")
+
+	logging.FromContext(r.Context()).Info("Rozłączono użytkownika", "target_user_id", target.UserID, "channel_id", channelID)
+
+	sendJSON(w, http.StatusOK, map[string]string{"message": "Użytkownik został rozłączony"})
+}
+
+// ──────────────────────────────────────────────
+// Własny mikrofon i dźwięk przez REST — jak mute-state/deafen-state z WebSocket
+// ──────────────────────────────────────────────
+
+// ownVoiceClient — połączony klient signaling zalogowanego użytkownika
+func (sh *SignalingHandler) ownVoiceClient(w http.ResponseWriter, r *http.Request) (*auth.Claims, *VoiceClient, int, bool) {
+	claims, ok := getClaims(r)
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Brak autoryzacji")
+		return nil, nil, 0, false
+	}
+	client, channelID, ok := sh.hub.findClient(claims.UserID)
+	if !ok {
+		sendError(w, http.StatusBadRequest, "Nie jesteś połączony z kanałem głosowym")
+		return nil, nil, 0, false
+	}
+	return claims, client, channelID, true
+}
+
+// ToggleMute — wycisz/odcisz własny mikrofon. Wyciszony przez moderatora
+// i słuchacz sceny nie mogą się odciszyć.
+func (sh *SignalingHandler) ToggleMute(w http.ResponseWriter, r *http.Request) {
+	var req models.MuteRequest
+	if err := decodeJSON(r, &req); err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe dane wejściowe")
+		return
+	}
+	claims, client, channelID, ok := sh.ownVoiceClient(w, r)
+	if !ok {
+		return
+	}
+
+	refused := ""
+	sh.updateClientState(channelID, client, func(c *VoiceClient) {
+		switch {
+		case req.Muted:
+		case c.ServerMuted:
+			refused = "Wyciszył cię moderator"
+		case c.StageRole == signaling.StageAudience:
+			refused = "Słuchacze sceny nie mogą nadawać"
+		}
+		if refused == "" {
+			c.Muted = req.Muted
+		}
+	})
+	if refused != "" {
+		sendError(w, http.StatusForbidden, refused)
+		return
+	}
+	if req.Muted {
+		sh.setSpeaking(client, false)
+	}
+
+	sh.broadcastToRoom(channelID, 0, signaling.Message{
+		Type:      signaling.TypeMuteState,
+		From:      claims.UserID,
+		FromName:  claims.Username,
+		ChannelID: channelID,
+		Muted:     req.Muted,
+	})
+
+	sendJSON(w, http.StatusOK, sh.hub.GetRoomParticipants(channelID))
+}
+
+// ToggleDeafen — wygłusz/przywróć dźwięk (własne wygłuszenie)
+func (sh *SignalingHandler) ToggleDeafen(w http.ResponseWriter, r *http.Request) {
+	var req models.DeafenRequest
+	if err := decodeJSON(r, &req); err != nil {
+		sendError(w, http.StatusBadRequest, "Nieprawidłowe dane wejściowe")
+		return
+	}
+	claims, client, channelID, ok := sh.ownVoiceClient(w, r)
+	if !ok {
+		return
+	}
+
+	sh.updateClientState(channelID, client, func(c *VoiceClient) {
+		c.Deafened = req.Deafened
+	})
+
+	sh.broadcastToRoom(channelID, 0, signaling.Message{
+		Type:      signaling.TypeDeafenState,
+		From:      claims.UserID,
+		FromName:  claims.Username,
+		ChannelID: channelID,
+		Deafened:  req.Deafened,
+	})
+
+	sendJSON(w, http.StatusOK, sh.hub.GetRoomParticipants(channelID))
+}
@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Setup ustawia domyślny logger procesu (slog). Wywołania pakietu log również
+// trafiają do niego jako wpisy poziomu INFO.
+func Setup(format, level string) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	if format == "text" {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// ──────────────────────────────────────────────
+// Kontekst żądania
+// ──────────────────────────────────────────────
+
+type ctxKey struct{}
+
+// request — dane korelacyjne jednego żądania HTTP (także upgrade'u WebSocket)
+type request struct {
+	id string
+
+	mu     sync.Mutex
+	userID int
+	logger *slog.Logger
+}
+
+// NewContext dołącza do kontekstu identyfikator żądania i logger z polem
+// request_id (oraz trace_id, gdy żądanie ma span OpenTelemetry)
+func NewContext(ctx context.Context, requestID string) context.Context {
+	logger := slog.Default().With("request_id", requestID)
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		logger = logger.With("trace_id", span.TraceID().String())
+	}
+	return context.WithValue(ctx, ctxKey{}, &request{
+		id:     requestID,
+		logger: logger,
+	})
+}
+
+func fromContext(ctx context.Context) *request {
+	req, _ := ctx.Value(ctxKey{}).(*request)
+	return req
+}
+
+// FromContext — logger żądania z polami korelacyjnymi (poza żądaniem: domyślny)
+func FromContext(ctx context.Context) *slog.Logger {
+	req := fromContext(ctx)
+	if req == nil {
+		return slog.Default()
+	}
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	return req.logger
+}
+
+// SetUserID zapisuje uwierzytelnionego użytkownika — kolejne wpisy loggera
+// żądania i jego podsumowanie zawierają user_id
+func SetUserID(ctx context.Context, userID int) {
+	req := fromContext(ctx)
+	if req == nil {
+		return
+	}
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	if req.userID == userID {
+		return
+	}
+	req.userID = userID
+	req.logger = req.logger.With("user_id", userID)
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "kodama"
+
+// registry — własny rejestr zamiast globalnego (bez metryk z zależności)
+var registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests — liczba żądań per metoda, szablon trasy gorilla/mux i status
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Liczba obsłużonych żądań HTTP.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPDuration — czas obsługi żądań per metoda i szablon trasy
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Czas obsługi żądań HTTP.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// WebSocketConnections — otwarte połączenia WebSocket signaling
+	WebSocketConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_connections",
+		Help:      "Otwarte połączenia WebSocket signaling.",
+	})
+
+	// MessagesSent — wysłane wiadomości tekstowe; na minutę: rate(...[1m]) * 60
+	MessagesSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_sent_total",
+		Help:      "Liczba wysłanych wiadomości tekstowych.",
+	})
+
+	// RateLimited — żądania odrzucone przez limit per grupa tras (auth, api, messages)
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Liczba żądań odrzuconych przez limit.",
+	}, []string{"group"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		WebSocketConnections,
+		MessagesSent,
+		RateLimited,
+	)
+}
+
+// RegisterDB — statystyki puli połączeń database/sql (otwarte, zajęte, oczekiwania)
+func RegisterDB(db *sql.DB) {
+	registry.MustRegister(collectors.NewDBStatsCollector(db, "postgres"))
+}
+
+// RegisterVoice — pokoje głosowe i uczestnicy odczytywani przy każdym scrapie
+func RegisterVoice(stats func() (rooms, participants int)) {
+	registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "voice_rooms",
+			Help:      "Aktywne pokoje kanałów głosowych.",
+		}, func() float64 {
+			rooms, _ := stats()
+			return float64(rooms)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "voice_participants",
+			Help:      "Uczestnicy kanałów głosowych.",
+		}, func() float64 {
+			_, participants := stats()
+			return float64(participants)
+		}),
+	)
+}
+
+// Handler — endpoint /metrics w formacie Prometheus
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
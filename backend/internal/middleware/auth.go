@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"kodama-backend/internal/auth"
+	"kodama-backend/internal/logging"
 )
 
 func AuthMiddleware(next http.Handler) http.Handler {
@@ -28,6 +29,7 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		logging.SetUserID(r.Context(), claims.UserID)
 		ctx := context.WithValue(r.Context(), "claims", claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -0,0 +1,15 @@
+package middleware
+
+import "net/http"
+
+// MaxBodySize ogranicza rozmiar ciała żądania — dekodowanie większego zwraca błąd
+func MaxBodySize(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
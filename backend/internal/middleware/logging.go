@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"kodama-backend/internal/logging"
+
+	"github.com/gorilla/mux"
+)
+
+// RequestIDHeader — nagłówek z identyfikatorem żądania (przyjmowany od proxy i zwracany klientowi)
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID nadaje żądaniu identyfikator — przekazany przez klienta/proxy albo
+// losowy — i dołącza do kontekstu logger z polem request_id
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.NewContext(r.Context(), id)))
+	})
+}
+
+// validRequestID — obce identyfikatory trafiają do logów, więc tylko krótkie i bezpieczne znaki
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, ch := range id {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		case ch == '-', ch == '_', ch == '.', ch == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// probePaths — sondy odpytywane co kilka sekund; ich żądania nie trafiają do logu
+// (niegotowość loguje sam handler /readyz)
+var probePaths = map[string]bool{"/healthz": true, "/readyz": true, "/api/health": true}
+
+// RequestLogger zapisuje podsumowanie każdego żądania poza sondami: metoda, szablon
+// trasy, status, czas obsługi, adres klienta i użytkownik. Musi działać po RequestID
+// (i po RealIP, żeby adres uwzględniał zaufane proxy).
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probePaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		route := routeTemplate(r)
+		status := rec.statusCode()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		attrs := []any{
+			"method", r.Method,
+			"route", route,
+			"status", status,
+			"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"bytes", rec.bytes,
+			"client_ip", ClientIP(r).String(),
+		}
+		// Logger żądania ma już request_id i — po uwierzytelnieniu — user_id
+		logging.FromContext(r.Context()).Log(r.Context(), level, "Żądanie HTTP", attrs...)
+	})
+}
+
+// routeTemplate — szablon trasy gorilla/mux (np. /api/servers/{id:[0-9]+}),
+// a poza trasą surowa ścieżka
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tpl, err := current.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return r.URL.Path
+}
+
+// statusRecorder — zapamiętuje status i rozmiar odpowiedzi; przepuszcza Hijack
+// (upgrade WebSocket) i Flush
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+func (s *statusRecorder) statusCode() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(s.ResponseWriter).Hijack()
+	if err == nil {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+func (s *statusRecorder) Flush() {
+	http.NewResponseController(s.ResponseWriter).Flush()
+}
+
+// Unwrap — dla http.ResponseController (np. zmiana limitu czasu zapisu)
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
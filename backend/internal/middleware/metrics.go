@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"kodama-backend/internal/metrics"
+)
+
+// Metrics zlicza żądania i mierzy czas ich obsługi per szablon trasy gorilla/mux.
+// Działa tylko dla dopasowanych tras, więc etykiety mają ograniczoną liczność.
+// Połączenia po upgrade (WebSocket) są liczone, ale nie trafiają do histogramu —
+// handler wraca dopiero po rozłączeniu, więc czas nie mówi nic o obsłudze żądania.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		route := routeTemplate(r)
+		status := rec.statusCode()
+		metrics.HTTPRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		if status != http.StatusSwitchingProtocols {
+			metrics.HTTPDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+		}
+	})
+}
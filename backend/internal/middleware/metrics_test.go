@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kodama-backend/internal/metrics"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestMetricsSkipsUpgradeDuration — upgrade WebSocket jest liczony z kodem 101,
+// ale nie trafia do histogramu czasu obsługi
+func TestMetricsSkipsUpgradeDuration(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	r := mux.NewRouter()
+	r.Use(Metrics)
+	r.HandleFunc("/test/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	})
+	r.HandleFunc("/test/plain", func(w http.ResponseWriter, r *http.Request) {})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	upgrades := metrics.HTTPRequests.WithLabelValues("GET", "/test/ws", "101")
+	before := testutil.ToFloat64(upgrades)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/test/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.ReadMessage() // czeka na zamknięcie przez serwer — handler już wrócił
+	conn.Close()
+	resp, err := http.Get(srv.URL + "/test/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := testutil.ToFloat64(upgrades) - before; got != 1 {
+		t.Errorf("żądania upgrade z kodem 101 = %v, oczekiwano 1", got)
+	}
+	if got := testutil.CollectAndCount(metrics.HTTPDuration, "kodama_http_request_duration_seconds"); got != 1 {
+		t.Errorf("serie histogramu = %d, oczekiwano 1 (tylko /test/plain)", got)
+	}
+}
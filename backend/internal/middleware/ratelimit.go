@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"kodama-backend/internal/auth"
+	"kodama-backend/internal/logging"
+	"kodama-backend/internal/metrics"
+	"kodama-backend/internal/ratelimit"
+)
+
+// RateLimit ogranicza żądania grupy tras wiadrem tokenów — per użytkownik, gdy
+// działa po AuthMiddleware, a w przeciwnym razie per adres IP (ClientIP).
+// Odpowiedzi niosą nagłówki X-RateLimit-*, odrzucone — 429 i Retry-After.
+// Awaria magazynu nie blokuje ruchu: żądanie przechodzi, a błąd trafia do logu.
+func RateLimit(store ratelimit.Store, group string, rule ratelimit.Rule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := group + ":ip:" + ClientIP(r).String()
+			if claims, ok := r.Context().Value("claims").(*auth.Claims); ok {
+				key = group + ":user:" + strconv.Itoa(claims.UserID)
+			}
+
+			res, err := store.Take(r.Context(), key, rule)
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("Błąd magazynu limitów — żądanie przepuszczone", "group", group, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+			h.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("X-RateLimit-Reset", seconds(res.Reset))
+			if !res.Allowed {
+				metrics.RateLimited.WithLabelValues(group).Inc()
+				h.Set("Retry-After", seconds(res.RetryAfter))
+				h.Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"error":"Zbyt wiele żądań — spróbuj ponownie później"}`))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// seconds — czas w pełnych sekundach zaokrąglony w górę (format Retry-After)
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
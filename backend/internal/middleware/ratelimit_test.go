@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kodama-backend/internal/auth"
+	"kodama-backend/internal/ratelimit"
+)
+
+// TestRateLimitHeaders — nagłówki X-RateLimit-* przy każdej odpowiedzi, po
+// wyczerpaniu limitu 429 z Retry-After; osobne wiadra per adres i per użytkownik
+func TestRateLimitHeaders(t *testing.T) {
+	rule := ratelimit.Rule{Limit: 2, Period: time.Minute}
+	h := RateLimit(ratelimit.NewMemoryStore(), "test", rule)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(remoteAddr string, claims *auth.Claims) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		if claims != nil {
+			req = req.WithContext(context.WithValue(req.Context(), "claims", claims))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		claims     *auth.Claims
+		status     int
+		remaining  string
+		reset      string // pełne sekundy w górę: 30 s na token
+		retryAfter string
+	}{
+		{"pierwsze żądanie", "192.0.2.1:1000", nil, http.StatusOK, "1", "30", ""},
+		{"drugie żądanie", "192.0.2.1:1001", nil, http.StatusOK, "0", "60", ""},
+		{"ponad limit", "192.0.2.1:1002", nil, http.StatusTooManyRequests, "0", "60", "30"},
+		{"inny adres", "192.0.2.2:1000", nil, http.StatusOK, "1", "30", ""},
+		{"zalogowany z tego samego adresu", "192.0.2.1:1003", &auth.Claims{UserID: 7}, http.StatusOK, "1", "30", ""},
+	}
+	for _, tc := range cases {
+		rec := request(tc.remoteAddr, tc.claims)
+		if rec.Code != tc.status {
+			t.Errorf("%s: status %d, oczekiwano %d", tc.name, rec.Code, tc.status)
+		}
+		got := rec.Header()
+		if got.Get("X-RateLimit-Limit") != "2" || got.Get("X-RateLimit-Remaining") != tc.remaining ||
+			got.Get("X-RateLimit-Reset") != tc.reset || got.Get("Retry-After") != tc.retryAfter {
+			t.Errorf("%s: nagłówki Limit=%q Remaining=%q Reset=%q Retry-After=%q, oczekiwano 2/%s/%s/%q", tc.name,
+				got.Get("X-RateLimit-Limit"), got.Get("X-RateLimit-Remaining"), got.Get("X-RateLimit-Reset"), got.Get("Retry-After"),
+				tc.remaining, tc.reset, tc.retryAfter)
+		}
+		if tc.status == http.StatusTooManyRequests && got.Get("Content-Type") != "application/json" {
+			t.Errorf("%s: Content-Type %q", tc.name, got.Get("Content-Type"))
+		}
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, ratelimit.Rule) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("magazyn niedostępny")
+}
+
+// TestRateLimitStoreFailure — awaria magazynu przepuszcza żądanie bez nagłówków limitu
+func TestRateLimitStoreFailure(t *testing.T) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h := RateLimit(failingStore{}, "test", ratelimit.Rule{Limit: 1, Period: time.Minute})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("X-RateLimit-Limit") != "" {
+		t.Errorf("status %d, nagłówki %v", rec.Code, rec.Header())
+	}
+}
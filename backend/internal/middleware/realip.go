@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// RealIP ustala adres klienta. Gdy połączenie przychodzi z zaufanego proxy,
+// X-Forwarded-For jest czytany od prawej — pierwszy niezaufany adres to klient
+// (wcześniejsze wpisy mógł dopisać on sam). Bez zaufanych proxy nagłówki są ignorowane.
+func RealIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	isTrusted := func(addr netip.Addr) bool {
+		for _, prefix := range trusted {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := remoteAddr(r)
+			if ip.IsValid() && isTrusted(ip) {
+				hops := r.Header.Values("X-Forwarded-For")
+			walk:
+				for i := len(hops) - 1; i >= 0; i-- {
+					parts := strings.Split(hops[i], ",")
+					for j := len(parts) - 1; j >= 0; j-- {
+						hop, err := netip.ParseAddr(strings.TrimSpace(parts[j]))
+						if err != nil {
+							break walk
+						}
+						ip = hop.Unmap()
+						if !isTrusted(ip) {
+							break walk
+						}
+					}
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+		})
+	}
+}
+
+// ClientIP — adres klienta ustalony przez RealIP (bez niego: adres połączenia)
+func ClientIP(r *http.Request) netip.Addr {
+	if ip, ok := r.Context().Value(clientIPKey{}).(netip.Addr); ok {
+		return ip
+	}
+	return remoteAddr(r)
+}
+
+func remoteAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, _ := netip.ParseAddr(host)
+	return addr.Unmap()
+}
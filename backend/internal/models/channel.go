@@ -2,42 +2,169 @@ package models
 
 import "time"
 
-// Channel — kanał tekstowy lub głosowy w serwerze
+// Channel — kanał tekstowy, głosowy, sceniczny lub kategoria grupująca kanały w serwerze.
+// Kanał sceniczny (stage) to kanał głosowy, w którym mówią tylko zatwierdzeni mówcy.
 type Channel struct {
-	ID        int       `json:"id"`
-	ServerID  int       `json:"server_id"`
-	Name      string    `json:"name"`
-	Type      string    `json:"type"` // "text", "voice"
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID           int       `json:"id"`
+	ServerID     int       `json:"server_id"`
+	Name         string    `json:"name"`
+	Type         string    `json:"type"`      // "text", "voice", "stage", "category"
+	ParentID     *int      `json:"parent_id"` // ID kategorii (nil = poza kategorią)
+	Position     int       `json:"position"`
+	Topic        string    `json:"topic"`
+	Slowmode     int       `json:"slowmode_seconds"` // minimalny odstęp między wiadomościami użytkownika
+	NSFW         bool      `json:"nsfw"`
+	Bitrate      int       `json:"bitrate"`       // tylko kanały głosowe, w b/s
+	UserLimit    int       `json:"user_limit"`    // tylko kanały głosowe, 0 = bez limitu
+	VoiceMode    string    `json:"voice_mode"`    // tylko kanały głosowe: "mesh" lub "sfu" (sceniczne zawsze "sfu")
+	VideoLimit   int       `json:"video_limit"`   // tylko kanały głosowe: maks. liczba nadających wideo, 0 = bez limitu
+	VideoBitrate int       `json:"video_bitrate"` // tylko kanały głosowe: maks. bitrate wideo na nadawcę, w b/s
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
 // Message — wiadomość w kanale tekstowym
 type Message struct {
-	ID        int       `json:"id"`
-	ChannelID int       `json:"channel_id"`
-	UserID    int       `json:"user_id"`
-	Username  string    `json:"username"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          int          `json:"id"`
+	ChannelID   int          `json:"channel_id"`
+	UserID      int          `json:"user_id"`
+	Username    string       `json:"username"`
+	Content     string       `json:"content"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+// Attachment — plik dołączony do wiadomości (przechowywany poza serwerem, pod URL)
+type Attachment struct {
+	ID          int    `json:"id"`
+	Filename    string `json:"filename"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size_bytes"`
+}
+
+// MessageSearchResult — wiadomość znaleziona przez wyszukiwarkę
+type MessageSearchResult struct {
+	Message
+	ChannelName   string `json:"channel_name"`
+	HasAttachment bool   `json:"has_attachment"`
+}
+
+// MessageSearchResponse — wyniki wyszukiwania wiadomości w serwerze
+type MessageSearchResponse struct {
+	Results []MessageSearchResult `json:"results"`
+	Total   int                   `json:"total"`
 }
 
 // VoiceParticipant — uczestnik kanału głosowego (stan w pamięci, nie w DB)
 type VoiceParticipant struct {
-	UserID   int    `json:"user_id"`
-	Username string `json:"username"`
+	UserID         int          `json:"user_id"`
+	Username       string       `json:"username"`
+	Muted          bool         `json:"muted"`
+	Deafened       bool         `json:"deafened"`        // wygłuszony przez siebie
+	ServerMuted    bool         `json:"server_muted"`    // wyciszony przez moderatora
+	ServerDeafened bool         `json:"server_deafened"` // wygłuszony przez moderatora
+	Speaking       bool         `json:"speaking"`
+	Video          bool         `json:"video"`                 // kamera włączona
+	ScreenShare    bool         `json:"screen_share"`          // udostępnianie ekranu
+	JoinedAt       time.Time    `json:"joined_at"`             // dołączenie do obecnego kanału
+	Tracks         []VoiceTrack `json:"tracks"`                // ogłoszone ścieżki mikrofonu, kamery i ekranu
+	StageRole      string       `json:"stage_role,omitempty"`  // kanał sceniczny: "speaker" lub "audience"
+	HandRaised     bool         `json:"hand_raised,omitempty"` // słuchacz prosi o głos
+}
+
+// VoiceTrack — ścieżka mediów ogłoszona przez uczestnika (track-add)
+type VoiceTrack struct {
+	TrackID  string `json:"track_id"`  // id MediaStreamTrack nadawcy
+	StreamID string `json:"stream_id"` // id MediaStream nadawcy
+	Kind     string `json:"kind"`      // "audio" lub "video"
+	Source   string `json:"source"`    // "microphone", "camera", "screen", "screen-audio"
 	Muted    bool   `json:"muted"`
 }
 
+// MediaLimits — limity mediów kanału przekazywane klientom
+type MediaLimits struct {
+	AudioBitrate int `json:"audio_bitrate"` // b/s
+	VideoBitrate int `json:"video_bitrate"` // b/s na nadawcę
+	VideoLimit   int `json:"video_limit"`   // maks. liczba nadających wideo, 0 = bez limitu
+}
+
+// VoiceRecording — manifest nagrania kanału głosowego
+type VoiceRecording struct {
+	ID        int                   `json:"id"`
+	ChannelID int                   `json:"channel_id"`
+	ServerID  int                   `json:"server_id"`
+	StartedBy *int                  `json:"started_by"`
+	StoppedBy *int                  `json:"stopped_by"`
+	Status    string                `json:"status"` // "recording", "completed", "interrupted"
+	StartedAt time.Time             `json:"started_at"`
+	StoppedAt *time.Time            `json:"stopped_at"`
+	Tracks    []VoiceRecordingTrack `json:"tracks,omitempty"`
+}
+
+// VoiceRecordingTrack — plik Ogg/Opus z mikrofonem jednego uczestnika
+type VoiceRecordingTrack struct {
+	ID        int       `json:"id"`
+	UserID    *int      `json:"user_id"`
+	Username  string    `json:"username"`
+	SizeBytes int64     `json:"size_bytes"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+}
+
+// RecordingState — payload ramki recording-state
+type RecordingState struct {
+	Recording   bool       `json:"recording"`
+	RecordingID int        `json:"recording_id,omitempty"`
+	StartedBy   int        `json:"started_by,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+}
+
+// ICEServer — serwer STUN/TURN dla klienta (format RTCIceServer)
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// ICEServersResponse — konfiguracja ICE; ExpiresAt dotyczy danych TURN
+type ICEServersResponse struct {
+	ICEServers []ICEServer `json:"ice_servers"`
+	ExpiresAt  *time.Time  `json:"expires_at,omitempty"`
+}
+
 // Requesty
 
 type CreateChannelRequest struct {
-	Name string `json:"name"`
-	Type string `json:"type"` // "text" lub "voice"
+	Name     string `json:"name"`
+	Type     string `json:"type"`      // "text", "voice", "stage" lub "category"
+	ParentID *int   `json:"parent_id"` // opcjonalna kategoria nadrzędna
+}
+
+// UpdateChannelRequest — częściowa aktualizacja kanału (nil = bez zmian)
+type UpdateChannelRequest struct {
+	Name         *string `json:"name"`
+	Topic        *string `json:"topic"`
+	Slowmode     *int    `json:"slowmode_seconds"`
+	NSFW         *bool   `json:"nsfw"`
+	Bitrate      *int    `json:"bitrate"`
+	UserLimit    *int    `json:"user_limit"`
+	VoiceMode    *string `json:"voice_mode"`
+	VideoLimit   *int    `json:"video_limit"`
+	VideoBitrate *int    `json:"video_bitrate"`
+}
+
+// ChannelPosition — nowa pozycja kanału (element PATCH .../channels/positions).
+// ParentID == nil przenosi kanał poza kategorie.
+type ChannelPosition struct {
+	ID       int  `json:"id"`
+	Position int  `json:"position"`
+	ParentID *int `json:"parent_id"`
 }
 
 type SendMessageRequest struct {
-	Content string `json:"content"`
+	Content     string       `json:"content"`
+	Attachments []Attachment `json:"attachments"` // ID jest ignorowane
 }
 
 type JoinVoiceRequest struct {
@@ -47,3 +174,18 @@ type JoinVoiceRequest struct {
 type MuteRequest struct {
 	Muted bool `json:"muted"`
 }
+
+type DeafenRequest struct {
+	Deafened bool `json:"deafened"`
+}
+
+// MyVoiceStateResponse — stan głosowy zalogowanego użytkownika (/voice/state)
+type MyVoiceStateResponse struct {
+	InChannel bool `json:"in_channel"`
+	ChannelID int  `json:"channel_id,omitempty"`
+	*VoiceParticipant
+}
+
+type MoveMemberRequest struct {
+	ChannelID int `json:"channel_id"`
+}
@@ -15,7 +15,7 @@ type ServerMember struct {
 	ID       int       `json:"id"`
 	ServerID int       `json:"server_id"`
 	UserID   int       `json:"user_id"`
-	Role     string    `json:"role"` // "owner", "member"
+	Role     string    `json:"role"` // "owner", "moderator", "member"
 	JoinedAt time.Time `json:"joined_at"`
 }
 
@@ -26,6 +26,15 @@ type ServerWithRole struct {
 	MemberCount int    `json:"member_count"`
 }
 
+// MemberInfo — członek serwera na liście członków
+type MemberInfo struct {
+	ID       int       `json:"id"`
+	Username string    `json:"username"`
+	Email    string    `json:"email"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
 // Requesty
 
 type CreateServerRequest struct {
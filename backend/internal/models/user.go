@@ -30,3 +30,33 @@ type AuthResponse struct {
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
+
+// Powody wpisów historii logowań
+const (
+	LoginOK             = "ok"
+	LoginRegistered     = "registered" // konto utworzone — urządzenie rejestracji jest znane
+	LoginBadPassword    = "bad_password"
+	LoginUnknownAccount = "unknown_account"
+	LoginLocked         = "locked" // próba w trakcie blokady (hasło nie było sprawdzane)
+)
+
+// LoginEvent — wpis historii logowań
+type LoginEvent struct {
+	ID        int       `json:"id"`
+	UserID    *int      `json:"-"`
+	Email     string    `json:"-"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason"`
+	NewDevice bool      `json:"new_device"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginAttempts — licznik nieudanych logowań jednego konta albo adresu IP
+type LoginAttempts struct {
+	Failures      int
+	Lockouts      int
+	LockedUntil   time.Time // zero = brak blokady
+	LastFailureAt time.Time
+}
@@ -0,0 +1,90 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mail — wiadomość tekstowa do jednego odbiorcy
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer — wysyłka emaili; implementację wybiera konfiguracja (SMTP albo log),
+// można też podać własną (np. klienta API dostawcy)
+type Mailer interface {
+	Send(ctx context.Context, msg Mail) error
+}
+
+// LogMailer — zamiast wysyłki zapisuje wiadomość w logu (środowisko deweloperskie)
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Mail) error {
+	slog.InfoContext(ctx, "Email (bez wysyłki — brak SMTP_HOST)", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+// SMTPMailer — wysyłka przez serwer SMTP (STARTTLS, gdy serwer go oferuje)
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string // pusty = bez uwierzytelniania
+	Password string
+	From     string // nagłówek From, np. "Kodama <no-reply@example.com>"
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Mail) error {
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("nieprawidłowy adres nadawcy: %w", err)
+	}
+
+	// net/smtp nie przyjmuje kontekstu — wysyłka w tle, oczekiwanie do końca ctx
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, from.Address, []string{msg.To}, m.message(msg))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("wysyłka emaila przez %s: %w", addr, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *SMTPMailer) message(msg Mail) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + header(m.From) + "\r\n")
+	b.WriteString("To: " + header(msg.To) + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// header — wartość nagłówka bez znaków nowej linii (wstrzyknięcie nagłówków)
+func header(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
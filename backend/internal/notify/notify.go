@@ -0,0 +1,40 @@
+// Package notify — powiadomienia użytkowników o zdarzeniach bezpieczeństwa konta
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"kodama-backend/internal/models"
+)
+
+// Notifier — hook wywoływany po zdarzeniach wymagających uwagi użytkownika
+type Notifier interface {
+	// NewDeviceLogin — udane logowanie z urządzenia (user agenta), z którego
+	// użytkownik wcześniej się nie logował
+	NewDeviceLogin(ctx context.Context, user models.User, event models.LoginEvent) error
+}
+
+// MailNotifier — powiadomienia emailem przez dowolny Mailer
+type MailNotifier struct {
+	Mailer Mailer
+}
+
+func (n *MailNotifier) NewDeviceLogin(ctx context.Context, user models.User, event models.LoginEvent) error {
+	userAgent := event.UserAgent
+	if userAgent == "" {
+		userAgent = "nieznane"
+	}
+	return n.Mailer.Send(ctx, Mail{
+		To:      user.Email,
+		Subject: "Kodama: logowanie z nowego urządzenia",
+		Body: fmt.Sprintf(
+			"Cześć %s,\n\n"+
+				"ktoś zalogował się na Twoje konto z urządzenia, z którego konto nie było wcześniej używane.\n\n"+
+				"Czas: %s\nAdres IP: %s\nUrządzenie: %s\n\n"+
+				"Jeśli to nie Ty, zmień hasło.\n",
+			user.Username, event.CreatedAt.UTC().Format(time.RFC1123), event.IPAddress, userAgent,
+		),
+	})
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Rule — wiadro tokenów: Limit żądań naraz, uzupełniane w tempie Limit na Period
+type Rule struct {
+	Limit  int
+	Period time.Duration
+}
+
+// Result — wynik pobrania tokenu z wiadra
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // do pełnego wiadra
+	RetryAfter time.Duration // do następnego tokenu (gdy !Allowed)
+}
+
+// Store — stan wiader. Pamięć procesu wystarcza dla jednej instancji; przy kilku
+// replikach potrzebny jest wspólny magazyn (RedisStore).
+type Store interface {
+	Take(ctx context.Context, key string, rule Rule) (Result, error)
+}
+
+// bucket — stan wiadra w chwili updated
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take uzupełnia wiadro o tokeny narosłe od ostatniej zmiany i próbuje pobrać jeden
+func (b *bucket) take(rule Rule, now time.Time) Result {
+	rate := float64(rule.Limit) / float64(rule.Period)
+	if b.updated.IsZero() {
+		b.tokens = float64(rule.Limit)
+	} else if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(rule.Limit), b.tokens+float64(elapsed)*rate)
+	}
+	b.updated = now
+
+	res := Result{Limit: rule.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration(math.Ceil((1 - b.tokens) / rate))
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = time.Duration(math.Ceil((float64(rule.Limit) - b.tokens) / rate))
+	return res
+}
+
+// Bucket — pojedyncze wiadro bez magazynu, np. limit wiadomości jednego
+// połączenia WebSocket. Nie jest bezpieczne dla wielu goroutine.
+type Bucket struct {
+	rule  Rule
+	state bucket
+}
+
+func NewBucket(rule Rule) *Bucket {
+	return &Bucket{rule: rule}
+}
+
+// Allow pobiera token; false = limit wyczerpany
+func (b *Bucket) Allow() bool {
+	return b.state.take(b.rule, time.Now()).Allowed
+}
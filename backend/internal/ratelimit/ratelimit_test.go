@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketTake(t *testing.T) {
+	rule := Rule{Limit: 10, Period: 10 * time.Second} // 1 token na sekundę
+	t0 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name  string
+		state bucket
+		now   time.Time
+		want  Result
+	}{
+		{
+			name: "pierwsze pobranie z pustego wiadra",
+			now:  t0,
+			want: Result{Allowed: true, Limit: 10, Remaining: 9, Reset: time.Second},
+		},
+		{
+			name:  "wyczerpane wiadro",
+			state: bucket{tokens: 0, updated: t0},
+			now:   t0,
+			want:  Result{Limit: 10, Remaining: 0, Reset: 10 * time.Second, RetryAfter: time.Second},
+		},
+		{
+			name:  "uzupełnienie od ostatniej zmiany",
+			state: bucket{tokens: 0, updated: t0},
+			now:   t0.Add(2500 * time.Millisecond),
+			want:  Result{Allowed: true, Limit: 10, Remaining: 1, Reset: 8500 * time.Millisecond},
+		},
+		{
+			name:  "uzupełnienie najwyżej do Limit",
+			state: bucket{tokens: 5, updated: t0},
+			now:   t0.Add(time.Hour),
+			want:  Result{Allowed: true, Limit: 10, Remaining: 9, Reset: time.Second},
+		},
+		{
+			name:  "ułamek tokenu — RetryAfter zaokrąglone w górę",
+			state: bucket{tokens: 0.25, updated: t0},
+			now:   t0,
+			want:  Result{Limit: 10, Remaining: 0, Reset: 9750 * time.Millisecond, RetryAfter: 750 * time.Millisecond},
+		},
+		{
+			name:  "zegar cofnięty — bez uzupełnienia",
+			state: bucket{tokens: 3, updated: t0},
+			now:   t0.Add(-time.Minute),
+			want:  Result{Allowed: true, Limit: 10, Remaining: 2, Reset: 8 * time.Second},
+		},
+	}
+
+	// Czasy są zaokrąglane w górę do nanosekund — nigdy krótsze od dokładnych
+	near := func(got, want time.Duration) bool {
+		return got >= want && got-want < time.Microsecond
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := tc.state
+			got := b.take(rule, tc.now)
+			if got.Allowed != tc.want.Allowed || got.Limit != tc.want.Limit || got.Remaining != tc.want.Remaining ||
+				!near(got.Reset, tc.want.Reset) || !near(got.RetryAfter, tc.want.RetryAfter) {
+				t.Errorf("take = %+v, oczekiwano %+v", got, tc.want)
+			}
+			if !b.updated.Equal(tc.now) {
+				t.Errorf("updated = %v, oczekiwano %v", b.updated, tc.now)
+			}
+		})
+	}
+}
+
+// TestBucketDrain — Limit pobrań naraz, kolejne odrzucane do uzupełnienia tokenu
+func TestBucketDrain(t *testing.T) {
+	rule := Rule{Limit: 3, Period: time.Minute}
+	t0 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var b bucket
+	for i := range rule.Limit {
+		if res := b.take(rule, t0); !res.Allowed || res.Remaining != rule.Limit-1-i {
+			t.Fatalf("pobranie %d: %+v", i+1, res)
+		}
+	}
+	if res := b.take(rule, t0); res.Allowed {
+		t.Fatalf("pobranie ponad limit przepuszczone: %+v", res)
+	}
+	if res := b.take(rule, t0.Add(20*time.Second)); !res.Allowed {
+		t.Errorf("po uzupełnieniu tokenu: %+v", res)
+	}
+}
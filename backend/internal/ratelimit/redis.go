@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore — wiadra współdzielone przez wszystkie instancje serwera (Redis
+// albo zgodny: Valkey, KeyDB, Dragonfly). Cała operacja to jeden skrypt Lua,
+// więc jest atomowa; czas bierze się z serwera Redis, żeby różnice zegarów
+// instancji nie miały znaczenia.
+type RedisStore struct {
+	client redis.Scripter
+	prefix string
+}
+
+// NewRedisStore — client to redis.Client, ClusterClient albo Ring; prefix
+// oddziela klucze limitów od innych danych w tej samej bazie
+func NewRedisStore(client redis.Scripter, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+// tokenBucketScript — KEYS[1] = klucz wiadra, ARGV = limit, okres w ms.
+// Zwraca {dozwolone, pozostałe, ms do pełnego wiadra, ms do następnego tokenu}.
+var tokenBucketScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local rate = limit / period
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1])
+local updated = tonumber(state[2])
+if tokens == nil then
+	tokens = limit
+elseif now > updated then
+	tokens = math.min(limit, tokens + (now - updated) * rate)
+end
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now)
+redis.call('PEXPIRE', KEYS[1], period)
+return {allowed, math.floor(tokens), math.ceil((limit - tokens) / rate), retry}
+`)
+
+func (s *RedisStore) Take(ctx context.Context, key string, rule Rule) (Result, error) {
+	nums, err := tokenBucketScript.Run(ctx, s.client, []string{s.prefix + key}, rule.Limit, rule.Period.Milliseconds()).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(nums) != 4 {
+		return Result{}, fmt.Errorf("nieoczekiwana odpowiedź skryptu limitu: %v", nums)
+	}
+	return Result{
+		Allowed:    nums[0] == 1,
+		Limit:      rule.Limit,
+		Remaining:  int(nums[1]),
+		Reset:      time.Duration(nums[2]) * time.Millisecond,
+		RetryAfter: time.Duration(nums[3]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,718 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kodama-backend/internal/models"
+)
+
+// NewMemory — repozytoria w pamięci procesu (testy, uruchomienia bez bazy).
+// Odwzorowują ograniczenia schematu: unikalny email i kod zaproszenia, kaskadowe
+// usuwanie oraz SET NULL dla parent_id kanałów usuniętej kategorii.
+func NewMemory() *Store {
+	m := &memoryDB{
+		users:    make(map[int]*models.User),
+		servers:  make(map[int]*models.Server),
+		members:  make(map[memberKey]*models.ServerMember),
+		channels: make(map[int]*models.Channel),
+		messages: make(map[int]*models.Message),
+		attempts: make(map[attemptKey]models.LoginAttempts),
+	}
+	return &Store{
+		Users:    &memUsers{m},
+		Servers:  &memServers{m},
+		Members:  &memMembers{m},
+		Channels: &memChannels{m},
+		Messages: &memMessages{m},
+		Logins:   &memLogins{m},
+	}
+}
+
+type memberKey struct {
+	serverID, userID int
+}
+
+// memoryDB — wspólny stan wszystkich repozytoriów (złączenia jak w SQL)
+type memoryDB struct {
+	mu       sync.RWMutex
+	lastID   int
+	users    map[int]*models.User
+	servers  map[int]*models.Server
+	members  map[memberKey]*models.ServerMember
+	channels map[int]*models.Channel
+	messages map[int]*models.Message
+	logins   []models.LoginEvent
+	attempts map[attemptKey]models.LoginAttempts
+}
+
+// nextID — identyfikatory rosną globalnie, jak sekwencje SERIAL
+func (m *memoryDB) nextID() int {
+	m.lastID++
+	return m.lastID
+}
+
+// now — przy remisach znaczników czasu o kolejności rozstrzyga ID
+func (m *memoryDB) now() time.Time {
+	return time.Now().UTC()
+}
+
+// ──────────────────────────────────────────────
+// Użytkownicy
+// ──────────────────────────────────────────────
+
+type memUsers struct{ m *memoryDB }
+
+func (r *memUsers) findByEmail(email string) *models.User {
+	for _, u := range r.m.users {
+		if u.Email == email {
+			return u
+		}
+	}
+	return nil
+}
+
+func (r *memUsers) EmailExists(_ context.Context, email string) (bool, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	return r.findByEmail(email) != nil, nil
+}
+
+func (r *memUsers) Create(_ context.Context, email, username, passwordHash string) (*models.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if r.findByEmail(email) != nil {
+		return nil, ErrConflict
+	}
+	now := r.m.now()
+	u := &models.User{ID: r.m.nextID(), Email: email, Username: username, PasswordHash: passwordHash, CreatedAt: now, UpdatedAt: now}
+	r.m.users[u.ID] = u
+	user := *u
+	user.PasswordHash = ""
+	return &user, nil
+}
+
+func (r *memUsers) GetByEmail(_ context.Context, email string) (*models.User, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	u := r.findByEmail(email)
+	if u == nil {
+		return nil, ErrNotFound
+	}
+	user := *u
+	return &user, nil
+}
+
+func (r *memUsers) GetByID(_ context.Context, id int) (*models.User, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	u, ok := r.m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	user := *u
+	user.PasswordHash = ""
+	return &user, nil
+}
+
+// ──────────────────────────────────────────────
+// Serwery
+// ──────────────────────────────────────────────
+
+type memServers struct{ m *memoryDB }
+
+func (m *memoryDB) memberCount(serverID int) int {
+	count := 0
+	for k := range m.members {
+		if k.serverID == serverID {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *memServers) Create(_ context.Context, name string, ownerID int, inviteCode string) (*models.Server, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for _, s := range r.m.servers {
+		if s.InviteCode == inviteCode {
+			return nil, ErrConflict
+		}
+	}
+	now := r.m.now()
+	s := &models.Server{ID: r.m.nextID(), Name: name, OwnerID: ownerID, InviteCode: inviteCode, CreatedAt: now, UpdatedAt: now}
+	r.m.servers[s.ID] = s
+	r.m.members[memberKey{s.ID, ownerID}] = &models.ServerMember{
+		ID: r.m.nextID(), ServerID: s.ID, UserID: ownerID, Role: "owner", JoinedAt: now,
+	}
+	server := *s
+	return &server, nil
+}
+
+func (r *memServers) GetByInviteCode(_ context.Context, inviteCode string) (*models.Server, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	for _, s := range r.m.servers {
+		if s.InviteCode == inviteCode {
+			server := *s
+			return &server, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memServers) OwnerID(_ context.Context, serverID int) (int, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	s, ok := r.m.servers[serverID]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return s.OwnerID, nil
+}
+
+func (r *memServers) ListForUser(_ context.Context, userID int) ([]models.ServerResponse, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	servers := []models.ServerResponse{}
+	for k, member := range r.m.members {
+		if k.userID != userID {
+			continue
+		}
+		servers = append(servers, models.ServerResponse{
+			Server:      *r.m.servers[k.serverID],
+			Role:        member.Role,
+			MemberCount: r.m.memberCount(k.serverID),
+		})
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		a, b := servers[i].Server, servers[j].Server
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+	return servers, nil
+}
+
+func (r *memServers) GetForMember(_ context.Context, serverID, userID int) (*models.ServerResponse, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	member, ok := r.m.members[memberKey{serverID, userID}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &models.ServerResponse{
+		Server:      *r.m.servers[serverID],
+		Role:        member.Role,
+		MemberCount: r.m.memberCount(serverID),
+	}, nil
+}
+
+func (r *memServers) UpdateInviteCode(_ context.Context, serverID int, inviteCode string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	s, ok := r.m.servers[serverID]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, other := range r.m.servers {
+		if other.ID != serverID && other.InviteCode == inviteCode {
+			return ErrConflict
+		}
+	}
+	s.InviteCode = inviteCode
+	s.UpdatedAt = r.m.now()
+	return nil
+}
+
+func (r *memServers) Delete(_ context.Context, serverID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.servers[serverID]; !ok {
+		return ErrNotFound
+	}
+	delete(r.m.servers, serverID)
+	for k := range r.m.members {
+		if k.serverID == serverID {
+			delete(r.m.members, k)
+		}
+	}
+	for id, ch := range r.m.channels {
+		if ch.ServerID == serverID {
+			r.m.deleteChannel(id)
+		}
+	}
+	return nil
+}
+
+// ──────────────────────────────────────────────
+// Członkowie
+// ──────────────────────────────────────────────
+
+type memMembers struct{ m *memoryDB }
+
+func (r *memMembers) Role(_ context.Context, serverID, userID int) (string, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	member, ok := r.m.members[memberKey{serverID, userID}]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return member.Role, nil
+}
+
+func (r *memMembers) Add(_ context.Context, serverID, userID int, role string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	key := memberKey{serverID, userID}
+	if _, ok := r.m.members[key]; ok {
+		return ErrConflict
+	}
+	if _, ok := r.m.servers[serverID]; !ok {
+		return ErrNotFound
+	}
+	r.m.members[key] = &models.ServerMember{
+		ID: r.m.nextID(), ServerID: serverID, UserID: userID, Role: role, JoinedAt: r.m.now(),
+	}
+	return nil
+}
+
+func (r *memMembers) Remove(_ context.Context, serverID, userID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	key := memberKey{serverID, userID}
+	if _, ok := r.m.members[key]; !ok {
+		return ErrNotFound
+	}
+	delete(r.m.members, key)
+	return nil
+}
+
+func (r *memMembers) Count(_ context.Context, serverID int) (int, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	return r.m.memberCount(serverID), nil
+}
+
+func (r *memMembers) List(_ context.Context, serverID int) ([]models.MemberInfo, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	var rows []*models.ServerMember
+	for k, member := range r.m.members {
+		if k.serverID == serverID {
+			rows = append(rows, member)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if !rows[i].JoinedAt.Equal(rows[j].JoinedAt) {
+			return rows[i].JoinedAt.Before(rows[j].JoinedAt)
+		}
+		return rows[i].ID < rows[j].ID
+	})
+
+	members := []models.MemberInfo{}
+	for _, member := range rows {
+		info := models.MemberInfo{ID: member.UserID, Role: member.Role, JoinedAt: member.JoinedAt}
+		if u, ok := r.m.users[member.UserID]; ok {
+			info.Username, info.Email = u.Username, u.Email
+		}
+		members = append(members, info)
+	}
+	return members, nil
+}
+
+// ──────────────────────────────────────────────
+// Kanały
+// ──────────────────────────────────────────────
+
+type memChannels struct{ m *memoryDB }
+
+// deleteChannel usuwa kanał z wiadomościami i odpina kanały podrzędne (wymaga blokady zapisu)
+func (m *memoryDB) deleteChannel(channelID int) {
+	delete(m.channels, channelID)
+	for id, msg := range m.messages {
+		if msg.ChannelID == channelID {
+			delete(m.messages, id)
+		}
+	}
+	for _, ch := range m.channels {
+		if ch.ParentID != nil && *ch.ParentID == channelID {
+			ch.ParentID = nil
+		}
+	}
+}
+
+func copyChannel(ch *models.Channel) *models.Channel {
+	c := *ch
+	if ch.ParentID != nil {
+		parent := *ch.ParentID
+		c.ParentID = &parent
+	}
+	return &c
+}
+
+func (r *memChannels) Create(_ context.Context, serverID int, name, chType string, parentID *int, voiceMode string) (*models.Channel, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.servers[serverID]; !ok {
+		return nil, ErrNotFound
+	}
+	position := 0
+	for _, ch := range r.m.channels {
+		if ch.ServerID == serverID && ch.Position >= position {
+			position = ch.Position + 1
+		}
+	}
+	now := r.m.now()
+	ch := &models.Channel{
+		ID: r.m.nextID(), ServerID: serverID, Name: name, Type: chType, Position: position,
+		Bitrate: 64000, VoiceMode: voiceMode, VideoLimit: 4, VideoBitrate: 1000000,
+		CreatedAt: now, UpdatedAt: now,
+	}
+	if parentID != nil {
+		parent := *parentID
+		ch.ParentID = &parent
+	}
+	r.m.channels[ch.ID] = ch
+	return copyChannel(ch), nil
+}
+
+func (r *memChannels) Get(_ context.Context, serverID, channelID int) (*models.Channel, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	ch, ok := r.m.channels[channelID]
+	if !ok || ch.ServerID != serverID {
+		return nil, ErrNotFound
+	}
+	return copyChannel(ch), nil
+}
+
+func (r *memChannels) GetByID(_ context.Context, channelID int) (*models.Channel, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	ch, ok := r.m.channels[channelID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyChannel(ch), nil
+}
+
+func (r *memChannels) ListByServer(_ context.Context, serverID int) ([]models.Channel, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	channels := []models.Channel{}
+	for _, ch := range r.m.channels {
+		if ch.ServerID == serverID {
+			channels = append(channels, *copyChannel(ch))
+		}
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		if channels[i].Position != channels[j].Position {
+			return channels[i].Position < channels[j].Position
+		}
+		return channels[i].ID < channels[j].ID
+	})
+	return channels, nil
+}
+
+func (r *memChannels) Update(_ context.Context, serverID, channelID int, req *models.UpdateChannelRequest) (*models.Channel, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	ch, ok := r.m.channels[channelID]
+	if !ok || ch.ServerID != serverID {
+		return nil, ErrNotFound
+	}
+	if req.Name != nil {
+		ch.Name = *req.Name
+	}
+	if req.Topic != nil {
+		ch.Topic = *req.Topic
+	}
+	if req.Slowmode != nil {
+		ch.Slowmode = *req.Slowmode
+	}
+	if req.NSFW != nil {
+		ch.NSFW = *req.NSFW
+	}
+	if req.Bitrate != nil {
+		ch.Bitrate = *req.Bitrate
+	}
+	if req.UserLimit != nil {
+		ch.UserLimit = *req.UserLimit
+	}
+	if req.VoiceMode != nil {
+		ch.VoiceMode = *req.VoiceMode
+	}
+	if req.VideoLimit != nil {
+		ch.VideoLimit = *req.VideoLimit
+	}
+	if req.VideoBitrate != nil {
+		ch.VideoBitrate = *req.VideoBitrate
+	}
+	ch.UpdatedAt = r.m.now()
+	return copyChannel(ch), nil
+}
+
+func (r *memChannels) UpdatePositions(_ context.Context, serverID int, positions []models.ChannelPosition, check func(types map[int]string) error) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	types := make(map[int]string)
+	for id, ch := range r.m.channels {
+		if ch.ServerID == serverID {
+			types[id] = ch.Type
+		}
+	}
+	if err := check(types); err != nil {
+		return err
+	}
+	now := r.m.now()
+	for _, p := range positions {
+		ch, ok := r.m.channels[p.ID]
+		if !ok || ch.ServerID != serverID {
+			continue
+		}
+		ch.Position = p.Position
+		ch.ParentID = nil
+		if p.ParentID != nil {
+			parent := *p.ParentID
+			ch.ParentID = &parent
+		}
+		ch.UpdatedAt = now
+	}
+	return nil
+}
+
+func (r *memChannels) Delete(_ context.Context, channelID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.channels[channelID]; !ok {
+		return ErrNotFound
+	}
+	r.m.deleteChannel(channelID)
+	return nil
+}
+
+// ──────────────────────────────────────────────
+// Wiadomości
+// ──────────────────────────────────────────────
+
+type memMessages struct{ m *memoryDB }
+
+// withUsername — kopia wiadomości z nazwą autora (jak JOIN users)
+func (m *memoryDB) withUsername(msg *models.Message) models.Message {
+	out := *msg
+	if u, ok := m.users[msg.UserID]; ok {
+		out.Username = u.Username
+	}
+	return out
+}
+
+// newestFirst — created_at malejąco, przy remisie id malejąco
+func newestFirst(a, b models.Message) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.ID > b.ID
+}
+
+func (r *memMessages) List(_ context.Context, channelID, beforeID, limit int) ([]models.Message, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	messages := []models.Message{}
+	for _, msg := range r.m.messages {
+		if msg.ChannelID == channelID && (beforeID <= 0 || msg.ID < beforeID) {
+			messages = append(messages, r.m.withUsername(msg))
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return newestFirst(messages[i], messages[j]) })
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	// Najstarsze pierwsze
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+func (r *memMessages) Create(_ context.Context, channelID, userID int, content string, attachments []models.Attachment, slowmode time.Duration) (*models.Message, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.channels[channelID]; !ok {
+		return nil, ErrNotFound
+	}
+	now := r.m.now()
+	if slowmode > 0 {
+		for _, msg := range r.m.messages {
+			if msg.ChannelID != channelID || msg.UserID != userID {
+				continue
+			}
+			if wait := msg.CreatedAt.Add(slowmode).Sub(now); wait > 0 {
+				return nil, &SlowmodeError{Wait: wait}
+			}
+		}
+	}
+	msg := &models.Message{ID: r.m.nextID(), ChannelID: channelID, UserID: userID, Content: content, CreatedAt: now}
+	for _, a := range attachments {
+		a.ID = r.m.nextID()
+		msg.Attachments = append(msg.Attachments, a)
+	}
+	r.m.messages[msg.ID] = msg
+	out := r.m.withUsername(msg)
+	return &out, nil
+}
+
+// Search — uproszczony odpowiednik wyszukiwania pełnotekstowego: każde słowo
+// zapytania musi wystąpić w treści (bez rozróżniania wielkości liter).
+func (r *memMessages) Search(_ context.Context, serverID int, q MessageSearch, limit, offset int) ([]models.MessageSearchResult, int, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+
+	words := strings.Fields(strings.ToLower(q.Text))
+	fromID, fromErr := strconv.Atoi(q.From)
+	inID, inErr := strconv.Atoi(q.In)
+
+	matches := []models.MessageSearchResult{}
+	for _, msg := range r.m.messages {
+		ch := r.m.channels[msg.ChannelID]
+		if ch == nil || ch.ServerID != serverID || ch.Type != "text" {
+			continue
+		}
+		hasAttachment := len(msg.Attachments) > 0
+		if q.HasAttachment && !hasAttachment {
+			continue
+		}
+		res := models.MessageSearchResult{Message: r.m.withUsername(msg), ChannelName: ch.Name, HasAttachment: hasAttachment}
+
+		content := strings.ToLower(msg.Content)
+		matched := true
+		for _, w := range words {
+			if !strings.Contains(content, w) {
+				matched = false
+				break
+			}
+		}
+		if q.From != "" {
+			if fromErr == nil {
+				matched = matched && msg.UserID == fromID
+			} else {
+				matched = matched && strings.EqualFold(res.Username, q.From)
+			}
+		}
+		if q.In != "" {
+			if inErr == nil {
+				matched = matched && ch.ID == inID
+			} else {
+				matched = matched && strings.EqualFold(ch.Name, q.In)
+			}
+		}
+		if q.Before != nil {
+			matched = matched && msg.CreatedAt.Before(*q.Before)
+		}
+		if q.After != nil {
+			matched = matched && !msg.CreatedAt.Before(*q.After)
+		}
+		if matched {
+			matches = append(matches, res)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return newestFirst(matches[i].Message, matches[j].Message) })
+	total := len(matches)
+	if offset >= total {
+		return []models.MessageSearchResult{}, total, nil
+	}
+	matches = matches[offset:]
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, total, nil
+}
+
+// ──────────────────────────────────────────────
+// Logowania
+// ──────────────────────────────────────────────
+
+type attemptKey struct {
+	scope, subject string
+}
+
+type memLogins struct{ m *memoryDB }
+
+func (r *memLogins) Record(_ context.Context, event *models.LoginEvent) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	event.ID = r.m.nextID()
+	event.CreatedAt = r.m.now()
+	r.m.logins = append(r.m.logins, *event)
+	return nil
+}
+
+func (r *memLogins) History(_ context.Context, userID, limit int) ([]models.LoginEvent, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	events := []models.LoginEvent{}
+	// Wpisy są dopisywane chronologicznie — od końca to najnowsze pierwsze
+	for i := len(r.m.logins) - 1; i >= 0 && len(events) < limit; i-- {
+		if e := r.m.logins[i]; e.UserID != nil && *e.UserID == userID {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (r *memLogins) Devices(_ context.Context, userID int, userAgent string) (sameAgent, total int, err error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	for _, e := range r.m.logins {
+		if e.UserID == nil || *e.UserID != userID || !e.Success {
+			continue
+		}
+		total++
+		if e.UserAgent == userAgent {
+			sameAgent++
+		}
+	}
+	return sameAgent, total, nil
+}
+
+func (r *memLogins) Attempts(_ context.Context, scope, subject string) (models.LoginAttempts, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	return r.m.attempts[attemptKey{scope, subject}], nil
+}
+
+func (r *memLogins) UpdateAttempts(_ context.Context, scope, subject string, update func(a *models.LoginAttempts)) (models.LoginAttempts, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	key := attemptKey{scope, subject}
+	a := r.m.attempts[key]
+	update(&a)
+	r.m.attempts[key] = a
+	return a, nil
+}
+
+func (r *memLogins) ResetAttempts(_ context.Context, scope, subject string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	delete(r.m.attempts, attemptKey{scope, subject})
+	return nil
+}
+
+func (r *memLogins) PruneAttempts(_ context.Context, before time.Time) (int, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	pruned := 0
+	for key, a := range r.m.attempts {
+		if a.LockedUntil.Before(before) && a.LastFailureAt.Before(before) {
+			delete(r.m.attempts, key)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
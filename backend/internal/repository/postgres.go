@@ -0,0 +1,800 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"kodama-backend/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// NewPostgres — repozytoria na bazie PostgreSQL
+func NewPostgres(db *sql.DB) *Store {
+	return &Store{
+		Users:    &pgUsers{db: db},
+		Servers:  &pgServers{db: db},
+		Members:  &pgMembers{db: db},
+		Channels: &pgChannels{db: db},
+		Messages: &pgMessages{db: db},
+		Logins:   &pgLogins{db: db},
+	}
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// pgError mapuje brak wiersza i naruszenie unikalności na błędy pakietu
+func pgError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrConflict
+	}
+	return err
+}
+
+// ──────────────────────────────────────────────
+// Użytkownicy
+// ──────────────────────────────────────────────
+
+type pgUsers struct {
+	db *sql.DB
+}
+
+func (r *pgUsers) EmailExists(ctx context.Context, email string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)`, email).Scan(&exists)
+	return exists, err
+}
+
+func (r *pgUsers) Create(ctx context.Context, email, username, passwordHash string) (*models.User, error) {
+	var user models.User
+	err := r.db.QueryRowContext(ctx,
+		`INSERT INTO users (email, username, password_hash)
+		 VALUES ($1, $2, $3)
+		 RETURNING id, email, username, created_at, updated_at`,
+		email, username, passwordHash,
+	).Scan(&user.ID, &user.Email, &user.Username, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &user, nil
+}
+
+func (r *pgUsers) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	err := r.db.QueryRowContext(ctx,
+		`SELECT id, email, username, password_hash, created_at, updated_at
+		 FROM users WHERE email = $1`,
+		email,
+	).Scan(&user.ID, &user.Email, &user.Username, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &user, nil
+}
+
+func (r *pgUsers) GetByID(ctx context.Context, id int) (*models.User, error) {
+	var user models.User
+	err := r.db.QueryRowContext(ctx,
+		`SELECT id, email, username, created_at, updated_at FROM users WHERE id = $1`,
+		id,
+	).Scan(&user.ID, &user.Email, &user.Username, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &user, nil
+}
+
+// ──────────────────────────────────────────────
+// Serwery
+// ──────────────────────────────────────────────
+
+type pgServers struct {
+	db *sql.DB
+}
+
+const serverColumns = `s.id, s.name, s.owner_id, s.invite_code, s.created_at, s.updated_at`
+
+func scanServer(row rowScanner, s *models.Server, extra ...interface{}) error {
+	return row.Scan(append([]interface{}{
+		&s.ID, &s.Name, &s.OwnerID, &s.InviteCode, &s.CreatedAt, &s.UpdatedAt,
+	}, extra...)...)
+}
+
+func (r *pgServers) Create(ctx context.Context, name string, ownerID int, inviteCode string) (*models.Server, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var server models.Server
+	err = scanServer(tx.QueryRowContext(ctx,
+		`INSERT INTO servers AS s (name, owner_id, invite_code)
+		 VALUES ($1, $2, $3)
+		 RETURNING `+serverColumns,
+		name, ownerID, inviteCode,
+	), &server)
+	if err != nil {
+		return nil, pgError(err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO server_members (server_id, user_id, role) VALUES ($1, $2, 'owner')`,
+		server.ID, ownerID,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &server, nil
+}
+
+func (r *pgServers) GetByInviteCode(ctx context.Context, inviteCode string) (*models.Server, error) {
+	var server models.Server
+	err := scanServer(r.db.QueryRowContext(ctx,
+		`SELECT `+serverColumns+` FROM servers s WHERE s.invite_code = $1`,
+		inviteCode,
+	), &server)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &server, nil
+}
+
+func (r *pgServers) OwnerID(ctx context.Context, serverID int) (int, error) {
+	var ownerID int
+	err := r.db.QueryRowContext(ctx, `SELECT owner_id FROM servers WHERE id = $1`, serverID).Scan(&ownerID)
+	return ownerID, pgError(err)
+}
+
+// serverWithRoleQuery — serwery użytkownika ($1) z jego rolą i liczbą członków
+const serverWithRoleQuery = `SELECT ` + serverColumns + `,
+	        sm.role,
+	        (SELECT COUNT(*) FROM server_members WHERE server_id = s.id) as member_count
+	 FROM servers s
+	 JOIN server_members sm ON sm.server_id = s.id AND sm.user_id = $1`
+
+func (r *pgServers) ListForUser(ctx context.Context, userID int) ([]models.ServerResponse, error) {
+	rows, err := r.db.QueryContext(ctx, serverWithRoleQuery+` ORDER BY s.created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	servers := []models.ServerResponse{}
+	for rows.Next() {
+		var resp models.ServerResponse
+		if err := scanServer(rows, &resp.Server, &resp.Role, &resp.MemberCount); err != nil {
+			return nil, err
+		}
+		servers = append(servers, resp)
+	}
+	return servers, rows.Err()
+}
+
+func (r *pgServers) GetForMember(ctx context.Context, serverID, userID int) (*models.ServerResponse, error) {
+	var resp models.ServerResponse
+	err := scanServer(r.db.QueryRowContext(ctx, serverWithRoleQuery+` WHERE s.id = $2`, userID, serverID),
+		&resp.Server, &resp.Role, &resp.MemberCount)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &resp, nil
+}
+
+func (r *pgServers) UpdateInviteCode(ctx context.Context, serverID int, inviteCode string) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE servers SET invite_code = $1, updated_at = NOW() WHERE id = $2`,
+		inviteCode, serverID,
+	)
+	return affected(res, err)
+}
+
+func (r *pgServers) Delete(ctx context.Context, serverID int) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM servers WHERE id = $1`, serverID)
+	return affected(res, err)
+}
+
+// affected zamienia brak zmienionych wierszy na ErrNotFound
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return pgError(err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ──────────────────────────────────────────────
+// Członkowie
+// ──────────────────────────────────────────────
+
+type pgMembers struct {
+	db *sql.DB
+}
+
+func (r *pgMembers) Role(ctx context.Context, serverID, userID int) (string, error) {
+	var role string
+	err := r.db.QueryRowContext(ctx,
+		`SELECT role FROM server_members WHERE server_id = $1 AND user_id = $2`,
+		serverID, userID,
+	).Scan(&role)
+	return role, pgError(err)
+}
+
+func (r *pgMembers) Add(ctx context.Context, serverID, userID int, role string) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO server_members (server_id, user_id, role) VALUES ($1, $2, $3)`,
+		serverID, userID, role,
+	)
+	return pgError(err)
+}
+
+func (r *pgMembers) Remove(ctx context.Context, serverID, userID int) error {
+	res, err := r.db.ExecContext(ctx,
+		`DELETE FROM server_members WHERE server_id = $1 AND user_id = $2`,
+		serverID, userID,
+	)
+	return affected(res, err)
+}
+
+func (r *pgMembers) Count(ctx context.Context, serverID int) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM server_members WHERE server_id = $1`, serverID).Scan(&count)
+	return count, err
+}
+
+func (r *pgMembers) List(ctx context.Context, serverID int) ([]models.MemberInfo, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT u.id, u.username, u.email, sm.role, sm.joined_at
+		 FROM server_members sm
+		 JOIN users u ON u.id = sm.user_id
+		 WHERE sm.server_id = $1
+		 ORDER BY sm.joined_at ASC`,
+		serverID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.MemberInfo{}
+	for rows.Next() {
+		var m models.MemberInfo
+		if err := rows.Scan(&m.ID, &m.Username, &m.Email, &m.Role, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// ──────────────────────────────────────────────
+// Kanały
+// ──────────────────────────────────────────────
+
+type pgChannels struct {
+	db *sql.DB
+}
+
+// channelColumns — kolumny kanału w kolejności oczekiwanej przez scanChannel
+const channelColumns = `id, server_id, name, type, parent_id, position, topic, slowmode_seconds,
+	nsfw, bitrate, user_limit, voice_mode, video_limit, video_bitrate, created_at, updated_at`
+
+// scanChannel skanuje wiersz z kolumnami channelColumns
+func scanChannel(row rowScanner, ch *models.Channel) error {
+	return row.Scan(
+		&ch.ID, &ch.ServerID, &ch.Name, &ch.Type, &ch.ParentID, &ch.Position, &ch.Topic, &ch.Slowmode,
+		&ch.NSFW, &ch.Bitrate, &ch.UserLimit, &ch.VoiceMode, &ch.VideoLimit, &ch.VideoBitrate,
+		&ch.CreatedAt, &ch.UpdatedAt,
+	)
+}
+
+func (r *pgChannels) queryChannel(ctx context.Context, query string, args ...interface{}) (*models.Channel, error) {
+	var ch models.Channel
+	if err := scanChannel(r.db.QueryRowContext(ctx, query, args...), &ch); err != nil {
+		return nil, pgError(err)
+	}
+	return &ch, nil
+}
+
+func (r *pgChannels) Create(ctx context.Context, serverID int, name, chType string, parentID *int, voiceMode string) (*models.Channel, error) {
+	return r.queryChannel(ctx,
+		`INSERT INTO channels (server_id, name, type, parent_id, position, voice_mode)
+		 VALUES ($1, $2, $3, $4, (SELECT COALESCE(MAX(position), -1) + 1 FROM channels WHERE server_id = $1), $5)
+		 RETURNING `+channelColumns,
+		serverID, name, chType, parentID, voiceMode,
+	)
+}
+
+func (r *pgChannels) Get(ctx context.Context, serverID, channelID int) (*models.Channel, error) {
+	return r.queryChannel(ctx,
+		`SELECT `+channelColumns+` FROM channels WHERE id = $1 AND server_id = $2`,
+		channelID, serverID,
+	)
+}
+
+func (r *pgChannels) GetByID(ctx context.Context, channelID int) (*models.Channel, error) {
+	return r.queryChannel(ctx, `SELECT `+channelColumns+` FROM channels WHERE id = $1`, channelID)
+}
+
+func (r *pgChannels) ListByServer(ctx context.Context, serverID int) ([]models.Channel, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+channelColumns+`
+		 FROM channels WHERE server_id = $1
+		 ORDER BY position ASC, id ASC`,
+		serverID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := []models.Channel{}
+	for rows.Next() {
+		var ch models.Channel
+		if err := scanChannel(rows, &ch); err != nil {
+			return nil, err
+		}
+		channels = append(channels, ch)
+	}
+	return channels, rows.Err()
+}
+
+func (r *pgChannels) Update(ctx context.Context, serverID, channelID int, req *models.UpdateChannelRequest) (*models.Channel, error) {
+	// NULL w COALESCE = pole bez zmian
+	return r.queryChannel(ctx,
+		`UPDATE channels SET
+		    name = COALESCE($1, name),
+		    topic = COALESCE($2, topic),
+		    slowmode_seconds = COALESCE($3, slowmode_seconds),
+		    nsfw = COALESCE($4, nsfw),
+		    bitrate = COALESCE($5, bitrate),
+		    user_limit = COALESCE($6, user_limit),
+		    voice_mode = COALESCE($7, voice_mode),
+		    video_limit = COALESCE($8, video_limit),
+		    video_bitrate = COALESCE($9, video_bitrate),
+		    updated_at = NOW()
+		 WHERE id = $10 AND server_id = $11
+		 RETURNING `+channelColumns,
+		req.Name, req.Topic, req.Slowmode, req.NSFW, req.Bitrate, req.UserLimit, req.VoiceMode,
+		req.VideoLimit, req.VideoBitrate, channelID, serverID,
+	)
+}
+
+func (r *pgChannels) UpdatePositions(ctx context.Context, serverID int, positions []models.ChannelPosition, check func(types map[int]string) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Typy kanałów serwera — blokujemy wiersze do końca transakcji
+	rows, err := tx.QueryContext(ctx, `SELECT id, type FROM channels WHERE server_id = $1 FOR UPDATE`, serverID)
+	if err != nil {
+		return err
+	}
+	types := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var chType string
+		if err := rows.Scan(&id, &chType); err != nil {
+			rows.Close()
+			return err
+		}
+		types[id] = chType
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := check(types); err != nil {
+		return err
+	}
+
+	for _, p := range positions {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE channels SET position = $1, parent_id = $2, updated_at = NOW()
+			 WHERE id = $3 AND server_id = $4`,
+			p.Position, p.ParentID, p.ID, serverID,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *pgChannels) Delete(ctx context.Context, channelID int) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM channels WHERE id = $1`, channelID)
+	return affected(res, err)
+}
+
+// ──────────────────────────────────────────────
+// Wiadomości
+// ──────────────────────────────────────────────
+
+type pgMessages struct {
+	db *sql.DB
+}
+
+func (r *pgMessages) List(ctx context.Context, channelID, beforeID, limit int) ([]models.Message, error) {
+	var rows *sql.Rows
+	var err error
+	if beforeID > 0 {
+		rows, err = r.db.QueryContext(ctx,
+			`SELECT m.id, m.channel_id, m.user_id, u.username, m.content, m.created_at
+			 FROM messages m
+			 JOIN users u ON u.id = m.user_id
+			 WHERE m.channel_id = $1 AND m.id < $2
+			 ORDER BY m.created_at DESC
+			 LIMIT $3`,
+			channelID, beforeID, limit,
+		)
+	} else {
+		rows, err = r.db.QueryContext(ctx,
+			`SELECT m.id, m.channel_id, m.user_id, u.username, m.content, m.created_at
+			 FROM messages m
+			 JOIN users u ON u.id = m.user_id
+			 WHERE m.channel_id = $1
+			 ORDER BY m.created_at DESC
+			 LIMIT $2`,
+			channelID, limit,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		var m models.Message
+		if err := rows.Scan(&m.ID, &m.ChannelID, &m.UserID, &m.Username, &m.Content, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Odwróć kolejność — najstarsze pierwsze
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	if err := r.loadAttachments(ctx, messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// checkSlowmode blokuje wiersz członkostwa autora do końca transakcji — równoległe
+// wiadomości tego użytkownika czekają, aż ta zostanie zapisana — i sprawdza odstęp
+// od jego ostatniej wiadomości na kanale
+func checkSlowmode(ctx context.Context, tx *sql.Tx, channelID, userID int, slowmode time.Duration) error {
+	if _, err := tx.ExecContext(ctx,
+		`SELECT 1 FROM server_members sm
+		 JOIN channels c ON c.server_id = sm.server_id
+		 WHERE c.id = $1 AND sm.user_id = $2
+		 FOR UPDATE OF sm`,
+		channelID, userID,
+	); err != nil {
+		return err
+	}
+
+	var last, now time.Time
+	err := tx.QueryRowContext(ctx,
+		`SELECT created_at, NOW() FROM messages
+		 WHERE channel_id = $1 AND user_id = $2
+		 ORDER BY created_at DESC LIMIT 1`,
+		channelID, userID,
+	).Scan(&last, &now)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if wait := last.Add(slowmode).Sub(now); wait > 0 {
+		return &SlowmodeError{Wait: wait}
+	}
+	return nil
+}
+
+// loadAttachments — załączniki wiadomości jednym zapytaniem
+func (r *pgMessages) loadAttachments(ctx context.Context, messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	index := make(map[int]int, len(messages))
+	ids := make([]int64, len(messages))
+	for i, m := range messages {
+		index[m.ID] = i
+		ids[i] = int64(m.ID)
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, message_id, filename, url, content_type, size_bytes
+		 FROM message_attachments WHERE message_id = ANY($1)
+		 ORDER BY id`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a models.Attachment
+		var messageID int
+		if err := rows.Scan(&a.ID, &messageID, &a.Filename, &a.URL, &a.ContentType, &a.Size); err != nil {
+			return err
+		}
+		m := &messages[index[messageID]]
+		m.Attachments = append(m.Attachments, a)
+	}
+	return rows.Err()
+}
+
+func (r *pgMessages) Create(ctx context.Context, channelID, userID int, content string, attachments []models.Attachment, slowmode time.Duration) (*models.Message, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if slowmode > 0 {
+		if err := checkSlowmode(ctx, tx, channelID, userID, slowmode); err != nil {
+			return nil, err
+		}
+	}
+
+	var msg models.Message
+	err = tx.QueryRowContext(ctx,
+		`WITH m AS (
+		    INSERT INTO messages (channel_id, user_id, content)
+		    VALUES ($1, $2, $3)
+		    RETURNING id, channel_id, user_id, content, created_at
+		 )
+		 SELECT m.id, m.channel_id, m.user_id, u.username, m.content, m.created_at
+		 FROM m JOIN users u ON u.id = m.user_id`,
+		channelID, userID, content,
+	).Scan(&msg.ID, &msg.ChannelID, &msg.UserID, &msg.Username, &msg.Content, &msg.CreatedAt)
+	if err != nil {
+		return nil, pgError(err)
+	}
+
+	for _, a := range attachments {
+		if err := tx.QueryRowContext(ctx,
+			`INSERT INTO message_attachments (message_id, filename, url, content_type, size_bytes)
+			 VALUES ($1, $2, $3, $4, $5)
+			 RETURNING id`,
+			msg.ID, a.Filename, a.URL, a.ContentType, a.Size,
+		).Scan(&a.ID); err != nil {
+			return nil, err
+		}
+		msg.Attachments = append(msg.Attachments, a)
+	}
+	return &msg, tx.Commit()
+}
+
+func (r *pgMessages) Search(ctx context.Context, serverID int, q MessageSearch, limit, offset int) ([]models.MessageSearchResult, int, error) {
+	// Widoczność — tylko kanały tekstowe serwera
+	conds := []string{"c.server_id = $1", "c.type = 'text'"}
+	args := []interface{}{serverID}
+	addCond := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if q.Text != "" {
+		addCond("m.content_tsv @@ websearch_to_tsquery('simple', $%d)", q.Text)
+	}
+	if q.From != "" {
+		if userID, err := strconv.Atoi(q.From); err == nil {
+			addCond("m.user_id = $%d", userID)
+		} else {
+			addCond("LOWER(u.username) = LOWER($%d)", q.From)
+		}
+	}
+	if q.In != "" {
+		if channelID, err := strconv.Atoi(q.In); err == nil {
+			addCond("c.id = $%d", channelID)
+		} else {
+			addCond("LOWER(c.name) = LOWER($%d)", q.In)
+		}
+	}
+	if q.Before != nil {
+		addCond("m.created_at < $%d", *q.Before)
+	}
+	if q.After != nil {
+		addCond("m.created_at >= $%d", *q.After)
+	}
+	if q.HasAttachment {
+		conds = append(conds, "EXISTS(SELECT 1 FROM message_attachments a WHERE a.message_id = m.id)")
+	}
+
+	from := `FROM messages m
+		 JOIN users u ON u.id = m.user_id
+		 JOIN channels c ON c.id = m.channel_id
+		 WHERE ` + strings.Join(conds, " AND ")
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) `+from, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		fmt.Sprintf(
+			`SELECT m.id, m.channel_id, m.user_id, u.username, m.content, m.created_at, c.name,
+			        EXISTS(SELECT 1 FROM message_attachments a WHERE a.message_id = m.id)
+			 %s
+			 ORDER BY m.created_at DESC, m.id DESC
+			 LIMIT $%d OFFSET $%d`,
+			from, len(args)+1, len(args)+2,
+		),
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	results := []models.MessageSearchResult{}
+	for rows.Next() {
+		var res models.MessageSearchResult
+		if err := rows.Scan(
+			&res.ID, &res.ChannelID, &res.UserID, &res.Username, &res.Content, &res.CreatedAt,
+			&res.ChannelName, &res.HasAttachment,
+		); err != nil {
+			return nil, 0, err
+		}
+		results = append(results, res)
+	}
+	return results, total, rows.Err()
+}
+
+// ──────────────────────────────────────────────
+// Logowania
+// ──────────────────────────────────────────────
+
+type pgLogins struct {
+	db *sql.DB
+}
+
+func (r *pgLogins) Record(ctx context.Context, event *models.LoginEvent) error {
+	return r.db.QueryRowContext(ctx,
+		`INSERT INTO login_history (user_id, email, ip_address, user_agent, success, reason, new_device)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 RETURNING id, created_at`,
+		event.UserID, event.Email, event.IPAddress, event.UserAgent, event.Success, event.Reason, event.NewDevice,
+	).Scan(&event.ID, &event.CreatedAt)
+}
+
+func (r *pgLogins) History(ctx context.Context, userID, limit int) ([]models.LoginEvent, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, user_id, email, ip_address, user_agent, success, reason, new_device, created_at
+		 FROM login_history WHERE user_id = $1
+		 ORDER BY created_at DESC, id DESC LIMIT $2`,
+		userID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.LoginEvent{}
+	for rows.Next() {
+		var e models.LoginEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Email, &e.IPAddress, &e.UserAgent, &e.Success, &e.Reason, &e.NewDevice, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (r *pgLogins) Devices(ctx context.Context, userID int, userAgent string) (sameAgent, total int, err error) {
+	err = r.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FILTER (WHERE user_agent = $2), COUNT(*)
+		 FROM login_history WHERE user_id = $1 AND success`,
+		userID, userAgent,
+	).Scan(&sameAgent, &total)
+	return sameAgent, total, err
+}
+
+func scanAttempts(row rowScanner) (models.LoginAttempts, error) {
+	var a models.LoginAttempts
+	var lockedUntil, lastFailure sql.NullTime
+	if err := row.Scan(&a.Failures, &a.Lockouts, &lockedUntil, &lastFailure); err != nil {
+		return models.LoginAttempts{}, err
+	}
+	a.LockedUntil = lockedUntil.Time
+	a.LastFailureAt = lastFailure.Time
+	return a, nil
+}
+
+func (r *pgLogins) Attempts(ctx context.Context, scope, subject string) (models.LoginAttempts, error) {
+	a, err := scanAttempts(r.db.QueryRowContext(ctx,
+		`SELECT failures, lockouts, locked_until, last_failure_at
+		 FROM login_attempts WHERE scope = $1 AND subject = $2`,
+		scope, subject,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.LoginAttempts{}, nil
+	}
+	return a, err
+}
+
+func (r *pgLogins) UpdateAttempts(ctx context.Context, scope, subject string, update func(a *models.LoginAttempts)) (models.LoginAttempts, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.LoginAttempts{}, err
+	}
+	defer tx.Rollback()
+
+	// Wiersz musi istnieć, żeby FOR UPDATE zablokował równoległe próby
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO login_attempts (scope, subject) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		scope, subject,
+	); err != nil {
+		return models.LoginAttempts{}, err
+	}
+	a, err := scanAttempts(tx.QueryRowContext(ctx,
+		`SELECT failures, lockouts, locked_until, last_failure_at
+		 FROM login_attempts WHERE scope = $1 AND subject = $2 FOR UPDATE`,
+		scope, subject,
+	))
+	if err != nil {
+		return models.LoginAttempts{}, err
+	}
+
+	update(&a)
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE login_attempts SET failures = $3, lockouts = $4, locked_until = $5, last_failure_at = $6
+		 WHERE scope = $1 AND subject = $2`,
+		scope, subject, a.Failures, a.Lockouts, nullTime(a.LockedUntil), nullTime(a.LastFailureAt),
+	); err != nil {
+		return models.LoginAttempts{}, err
+	}
+	return a, tx.Commit()
+}
+
+func (r *pgLogins) ResetAttempts(ctx context.Context, scope, subject string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE scope = $1 AND subject = $2`, scope, subject)
+	return err
+}
+
+func (r *pgLogins) PruneAttempts(ctx context.Context, before time.Time) (int, error) {
+	res, err := r.db.ExecContext(ctx,
+		`DELETE FROM login_attempts
+		 WHERE (locked_until IS NULL OR locked_until < $1)
+		   AND (last_failure_at IS NULL OR last_failure_at < $1)`,
+		before,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// nullTime — zerowy czas zapisywany jako NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,145 @@
+// Package repository — dostęp do danych użytkowników, serwerów, członkostwa,
+// kanałów, wiadomości i historii logowań. Handlery korzystają wyłącznie
+// z interfejsów; implementacja Postgres działa na produkcji, implementacja
+// w pamięci służy testom bez bazy.
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"kodama-backend/internal/models"
+)
+
+// ErrNotFound — szukany wiersz nie istnieje
+var ErrNotFound = errors.New("repository: nie znaleziono")
+
+// ErrConflict — naruszenie unikalności (np. zajęty email, istniejące członkostwo)
+var ErrConflict = errors.New("repository: konflikt")
+
+// SlowmodeError — wiadomość odrzucona przez slowmode kanału
+type SlowmodeError struct {
+	Wait time.Duration // czas do następnej dozwolonej wiadomości
+}
+
+func (e *SlowmodeError) Error() string {
+	return fmt.Sprintf("repository: slowmode, następna wiadomość za %s", e.Wait)
+}
+
+// UserRepository — konta użytkowników
+type UserRepository interface {
+	EmailExists(ctx context.Context, email string) (bool, error)
+	Create(ctx context.Context, email, username, passwordHash string) (*models.User, error)
+	// GetByEmail zwraca użytkownika razem z hashem hasła
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	GetByID(ctx context.Context, id int) (*models.User, error)
+}
+
+// ServerRepository — serwery (gildie)
+type ServerRepository interface {
+	// Create tworzy serwer i dodaje właściciela jako członka z rolą "owner"
+	Create(ctx context.Context, name string, ownerID int, inviteCode string) (*models.Server, error)
+	GetByInviteCode(ctx context.Context, inviteCode string) (*models.Server, error)
+	OwnerID(ctx context.Context, serverID int) (int, error)
+	// ListForUser zwraca serwery użytkownika (najnowsze pierwsze) z jego rolą
+	ListForUser(ctx context.Context, userID int) ([]models.ServerResponse, error)
+	// GetForMember zwraca serwer z rolą użytkownika; ErrNotFound także gdy nie jest członkiem
+	GetForMember(ctx context.Context, serverID, userID int) (*models.ServerResponse, error)
+	UpdateInviteCode(ctx context.Context, serverID int, inviteCode string) error
+	// Delete usuwa serwer razem z członkostwem, kanałami i wiadomościami
+	Delete(ctx context.Context, serverID int) error
+}
+
+// MemberRepository — członkostwo w serwerach
+type MemberRepository interface {
+	// Role zwraca rolę użytkownika w serwerze; ErrNotFound gdy nie jest członkiem
+	Role(ctx context.Context, serverID, userID int) (string, error)
+	// Add dodaje członka; ErrConflict gdy już nim jest
+	Add(ctx context.Context, serverID, userID int, role string) error
+	// Remove usuwa członka; ErrNotFound gdy nim nie był
+	Remove(ctx context.Context, serverID, userID int) error
+	Count(ctx context.Context, serverID int) (int, error)
+	// List zwraca członków w kolejności dołączenia
+	List(ctx context.Context, serverID int) ([]models.MemberInfo, error)
+}
+
+// ChannelRepository — kanały i kategorie
+type ChannelRepository interface {
+	// Create dodaje kanał na koniec listy kanałów serwera
+	Create(ctx context.Context, serverID int, name, chType string, parentID *int, voiceMode string) (*models.Channel, error)
+	// Get zwraca kanał należący do serwera
+	Get(ctx context.Context, serverID, channelID int) (*models.Channel, error)
+	GetByID(ctx context.Context, channelID int) (*models.Channel, error)
+	// ListByServer zwraca kanały serwera w kolejności wyświetlania
+	ListByServer(ctx context.Context, serverID int) ([]models.Channel, error)
+	// Update stosuje częściowe zmiany (nil = bez zmian)
+	Update(ctx context.Context, serverID, channelID int, req *models.UpdateChannelRequest) (*models.Channel, error)
+	// UpdatePositions zapisuje pozycje atomowo. check dostaje typy kanałów serwera
+	// (id -> type) z chwili zapisu; jego błąd przerywa operację bez zmian.
+	UpdatePositions(ctx context.Context, serverID int, positions []models.ChannelPosition, check func(types map[int]string) error) error
+	Delete(ctx context.Context, channelID int) error
+}
+
+// MessageSearch — filtry wyszukiwania wiadomości w serwerze
+type MessageSearch struct {
+	Text          string // wyszukiwanie pełnotekstowe
+	From          string // ID lub nazwa autora
+	In            string // ID lub nazwa kanału
+	Before        *time.Time
+	After         *time.Time
+	HasAttachment bool
+}
+
+// IsEmpty — brak jakiegokolwiek filtra
+func (q MessageSearch) IsEmpty() bool {
+	return q.Text == "" && q.From == "" && q.In == "" &&
+		q.Before == nil && q.After == nil && !q.HasAttachment
+}
+
+// MessageRepository — wiadomości kanałów tekstowych
+type MessageRepository interface {
+	// List zwraca do limit wiadomości (najstarsze pierwsze); beforeID > 0 to kursor paginacji
+	List(ctx context.Context, channelID, beforeID, limit int) ([]models.Message, error)
+	// Create zapisuje wiadomość razem z załącznikami (jedna transakcja). Przy
+	// slowmode > 0 w tej samej transakcji sprawdza odstęp od poprzedniej wiadomości
+	// użytkownika na kanale i zwraca *SlowmodeError, gdy jest za krótki.
+	Create(ctx context.Context, channelID, userID int, content string, attachments []models.Attachment, slowmode time.Duration) (*models.Message, error)
+	// Search przeszukuje kanały tekstowe serwera (najnowsze pierwsze) i zwraca łączną liczbę trafień
+	Search(ctx context.Context, serverID int, q MessageSearch, limit, offset int) ([]models.MessageSearchResult, int, error)
+}
+
+// Zakresy liczników nieudanych logowań
+const (
+	ScopeAccount = "account" // klucz: email małymi literami
+	ScopeIP      = "ip"
+)
+
+// LoginRepository — historia logowań i liczniki nieudanych prób (blokady)
+type LoginRepository interface {
+	// Record zapisuje wpis historii (uzupełnia ID i CreatedAt)
+	Record(ctx context.Context, event *models.LoginEvent) error
+	// History zwraca do limit ostatnich logowań użytkownika (najnowsze pierwsze)
+	History(ctx context.Context, userID, limit int) ([]models.LoginEvent, error)
+	// Devices — udane logowania użytkownika z tym user agentem i łącznie
+	Devices(ctx context.Context, userID int, userAgent string) (sameAgent, total int, err error)
+	// Attempts zwraca licznik; zerowy gdy brak wpisu
+	Attempts(ctx context.Context, scope, subject string) (models.LoginAttempts, error)
+	// UpdateAttempts zmienia licznik atomowo — update dostaje bieżący stan (także zerowy)
+	UpdateAttempts(ctx context.Context, scope, subject string, update func(a *models.LoginAttempts)) (models.LoginAttempts, error)
+	// ResetAttempts usuwa licznik
+	ResetAttempts(ctx context.Context, scope, subject string) error
+	// PruneAttempts usuwa liczniki bez blokady i bez nieudanej próby od before
+	PruneAttempts(ctx context.Context, before time.Time) (int, error)
+}
+
+// Store — komplet repozytoriów przekazywany handlerom
+type Store struct {
+	Users    UserRepository
+	Servers  ServerRepository
+	Members  MemberRepository
+	Channels ChannelRepository
+	Messages MessageRepository
+	Logins   LoginRepository
+}
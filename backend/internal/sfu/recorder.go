@@ -0,0 +1,157 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+)
+
+var (
+	ErrAlreadyRecording = errors.New("sfu: kanał jest już nagrywany")
+	ErrNotRecording     = errors.New("sfu: kanał nie jest nagrywany")
+)
+
+// RecordedTrack — plik Ogg/Opus z mikrofonem jednego uczestnika.
+// Każde dołączenie uczestnika w trakcie nagrania to osobny plik.
+type RecordedTrack struct {
+	UserID    int
+	FileName  string // ścieżka względem katalogu podanego w StartRecording
+	SizeBytes int64
+	StartedAt time.Time
+	EndedAt   time.Time
+}
+
+// recording — zapis ścieżek audio pokoju, po jednym pliku na ścieżkę
+type recording struct {
+	dir    string
+	prefix string // podkatalog nagrania w dir
+
+	mu      sync.Mutex
+	stopped bool
+	seq     int
+	writers map[string]*trackWriter // ID ścieżki lokalnej -> plik
+	done    []RecordedTrack
+}
+
+type trackWriter struct {
+	ogg   *oggwriter.OggWriter
+	track RecordedTrack
+}
+
+// StartRecording zaczyna zapis mikrofonów uczestników kanału do plików
+// <dir>/<name>/user-<id>-<n>.ogg. Ścieżki dołączające później też są nagrywane.
+func (s *SFU) StartRecording(channelID int, dir, name string) error {
+	if err := os.MkdirAll(filepath.Join(dir, name), 0o750); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.recordings[channelID]; ok {
+		return ErrAlreadyRecording
+	}
+
+	rec := &recording{dir: dir, prefix: name, writers: make(map[string]*trackWriter)}
+	s.recordings[channelID] = rec
+	if r, ok := s.rooms[channelID]; ok {
+		r.recording.Store(rec)
+	}
+	return nil
+}
+
+// StopRecording kończy nagranie i zwraca listę zapisanych plików (posortowaną po czasie)
+func (s *SFU) StopRecording(channelID int) ([]RecordedTrack, error) {
+	s.mu.Lock()
+	rec, ok := s.recordings[channelID]
+	delete(s.recordings, channelID)
+	if r, exists := s.rooms[channelID]; exists {
+		r.recording.Store(nil)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrNotRecording
+	}
+	return rec.stop(), nil
+}
+
+// IsRecording — czy kanał jest nagrywany
+func (s *SFU) IsRecording(channelID int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.recordings[channelID]
+	return ok
+}
+
+// write dopisuje pakiet RTP do pliku ścieżki (plik powstaje przy pierwszym pakiecie)
+func (rec *recording) write(ownerID int, trackID string, pkt *rtp.Packet) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.stopped {
+		return
+	}
+
+	w, ok := rec.writers[trackID]
+	if !ok {
+		rec.seq++
+		name := filepath.Join(rec.prefix, fmt.Sprintf("user-%d-%03d.ogg", ownerID, rec.seq))
+		ogg, err := oggwriter.New(filepath.Join(rec.dir, name), 48000, 2)
+		if err != nil {
+			slog.Error("SFU: nie można utworzyć pliku nagrania", "file", name, "error", err)
+			// Zapamiętujemy brak pliku, żeby nie próbować przy każdym pakiecie
+			rec.writers[trackID] = nil
+			return
+		}
+		w = &trackWriter{ogg: ogg, track: RecordedTrack{UserID: ownerID, FileName: name, StartedAt: time.Now().UTC()}}
+		rec.writers[trackID] = w
+	}
+	if w == nil {
+		return
+	}
+	if err := w.ogg.WriteRTP(pkt); err != nil {
+		slog.Error("SFU: błąd zapisu nagrania", "file", w.track.FileName, "error", err)
+	}
+}
+
+// endTrack zamyka plik ścieżki, która się zakończyła (uczestnik wyszedł)
+func (rec *recording) endTrack(trackID string) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if w := rec.writers[trackID]; w != nil {
+		rec.done = append(rec.done, rec.closeWriter(w))
+	}
+	delete(rec.writers, trackID)
+}
+
+func (rec *recording) stop() []RecordedTrack {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.stopped = true
+	for id, w := range rec.writers {
+		if w != nil {
+			rec.done = append(rec.done, rec.closeWriter(w))
+		}
+		delete(rec.writers, id)
+	}
+	sort.Slice(rec.done, func(i, j int) bool { return rec.done[i].StartedAt.Before(rec.done[j].StartedAt) })
+	return rec.done
+}
+
+func (rec *recording) closeWriter(w *trackWriter) RecordedTrack {
+	if err := w.ogg.Close(); err != nil {
+		slog.Error("SFU: błąd zamykania nagrania", "file", w.track.FileName, "error", err)
+	}
+	w.track.EndedAt = time.Now().UTC()
+	if info, err := os.Stat(filepath.Join(rec.dir, w.track.FileName)); err == nil {
+		w.track.SizeBytes = info.Size()
+	}
+	return w.track
+}
@@ -0,0 +1,608 @@
+// Package sfu — Selective Forwarding Unit dla kanałów głosowych.
+//
+// Każdy klient ma jedno połączenie PeerConnection z backendem. Serwer odbiera
+// ścieżki klienta (mikrofon, kamera, ekran) i przekazuje pakiety RTP bez dekodowania
+// do wszystkich pozostałych uczestników pokoju. Oferty SDP zawsze wysyła serwer,
+// klient tylko odpowiada — renegocjacja następuje przy każdej zmianie zestawu ścieżek.
+//
+// Na żądanie serwer nagrywa mikrofony uczestników do plików Ogg/Opus (recorder.go).
+//
+// Aktywność głosowa jest wykrywana po stronie serwera z rozszerzenia RTP
+// ssrc-audio-level (RFC 6464), bez polegania na deklaracjach klientów.
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// Typy wiadomości signaling wysyłanych do klienta
+const (
+	SignalOffer        = "sfu-offer"
+	SignalICECandidate = "sfu-ice-candidate"
+)
+
+// SignalFunc — wysłanie wiadomości do klienta przez WebSocket signaling
+type SignalFunc func(msgType string, payload interface{}) error
+
+// SpeakingFunc — zmiana stanu mówienia uczestnika wykryta przez serwer
+type SpeakingFunc func(channelID, userID int, speaking bool)
+
+// keyframeInterval — co ile serwer prosi nadawcę wideo o klatkę kluczową (PLI),
+// żeby nowi odbiorcy i odbiorcy po utracie pakietów szybko dostali obraz
+const keyframeInterval = 3 * time.Second
+
+var ErrNotJoined = errors.New("sfu: uczestnik nie jest połączony")
+
+// Config — ustawienia sieciowe serwerowych PeerConnection
+type Config struct {
+	ICEServers      []webrtc.ICEServer
+	PublicIPs       []string // adresy ogłaszane klientom (NAT 1:1, np. w Dockerze)
+	PortMin         uint16   // zakres portów UDP (0 = dowolne)
+	PortMax         uint16
+	IncludeLoopback bool // kandydaci ICE na 127.0.0.1 (testy lokalne)
+}
+
+// SFU — zarządza pokojami SFU (channelID -> room)
+type SFU struct {
+	api    *webrtc.API
+	config webrtc.Configuration
+
+	mu         sync.Mutex
+	rooms      map[int]*room
+	recordings map[int]*recording // channelID -> trwające nagranie (przeżywa pusty pokój)
+	onSpeaking SpeakingFunc
+}
+
+// New tworzy SFU z domyślnymi kodekami (Opus) i interceptorami RTCP
+func New(cfg Config) (*SFU, error) {
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+	// Poziom dźwięku w nagłówkach RTP — podstawa detekcji mowy
+	if err := m.RegisterHeaderExtension(
+		webrtc.RTPHeaderExtensionCapability{URI: sdp.AudioLevelURI},
+		webrtc.RTPCodecTypeAudio,
+	); err != nil {
+		return nil, err
+	}
+
+	i := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+		return nil, err
+	}
+
+	se := webrtc.SettingEngine{}
+	if len(cfg.PublicIPs) > 0 {
+		se.SetNAT1To1IPs(cfg.PublicIPs, webrtc.ICECandidateTypeHost)
+	}
+	if cfg.PortMin > 0 && cfg.PortMax >= cfg.PortMin {
+		if err := se.SetEphemeralUDPPortRange(cfg.PortMin, cfg.PortMax); err != nil {
+			return nil, err
+		}
+	}
+	se.SetIncludeLoopbackCandidate(cfg.IncludeLoopback)
+
+	return &SFU{
+		api: webrtc.NewAPI(
+			webrtc.WithMediaEngine(m),
+			webrtc.WithInterceptorRegistry(i),
+			webrtc.WithSettingEngine(se),
+		),
+		config:     webrtc.Configuration{ICEServers: cfg.ICEServers},
+		rooms:      make(map[int]*room),
+		recordings: make(map[int]*recording),
+	}, nil
+}
+
+// OnSpeaking ustawia funkcję wywoływaną przy zmianie stanu mówienia uczestnika
+func (s *SFU) OnSpeaking(f SpeakingFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onSpeaking = f
+}
+
+func (s *SFU) emitSpeaking(channelID, userID int, speaking bool) {
+	s.mu.Lock()
+	f := s.onSpeaking
+	s.mu.Unlock()
+	if f != nil {
+		f(channelID, userID, speaking)
+	}
+}
+
+// room — uczestnicy i przekazywane ścieżki jednego kanału
+type room struct {
+	channelID    int
+	mu           sync.Mutex
+	participants map[int]*participant
+	tracks       map[string]*forwardedTrack // ID ścieżki lokalnej -> ścieżka
+	recording    atomic.Pointer[recording]  // nil = bez nagrywania
+}
+
+// forwardedTrack — ścieżka odebrana od uczestnika i rozsyłana pozostałym
+type forwardedTrack struct {
+	ownerID int
+	local   *webrtc.TrackLocalStaticRTP
+}
+
+// participant — serwerowa strona połączenia z jednym klientem
+type participant struct {
+	userID int
+	pc     *webrtc.PeerConnection
+	signal SignalFunc
+
+	mu      sync.Mutex // serializuje negocjację SDP
+	pending bool       // zmiana ścieżek w trakcie trwającej negocjacji
+
+	muted    atomic.Bool // dźwięk uczestnika nie jest przekazywany ani nagrywany (wyciszony przez moderatora)
+	silenced atomic.Bool // żadne media uczestnika nie są przekazywane (słuchacz sceny)
+	deafened atomic.Bool // uczestnik nie dostaje cudzego dźwięku (wygłuszony przez moderatora)
+}
+
+func (s *SFU) getOrCreateRoom(channelID int) *room {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.rooms[channelID]
+	if !ok {
+		r = &room{
+			channelID:    channelID,
+			participants: make(map[int]*participant),
+			tracks:       make(map[string]*forwardedTrack),
+		}
+		if rec, ok := s.recordings[channelID]; ok {
+			r.recording.Store(rec)
+		}
+		s.rooms[channelID] = r
+	}
+	return r
+}
+
+func (s *SFU) getParticipant(channelID, userID int) (*room, *participant, bool) {
+	s.mu.Lock()
+	r, ok := s.rooms[channelID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil, false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.participants[userID]
+	return r, p, ok
+}
+
+// Join tworzy PeerConnection dla uczestnika i wysyła mu pierwszą ofertę
+func (s *SFU) Join(channelID, userID int, signal SignalFunc) error {
+	pc, err := s.api.NewPeerConnection(s.config)
+	if err != nil {
+		return err
+	}
+
+	// Odbiór mikrofonu klienta
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	}); err != nil {
+		pc.Close()
+		return err
+	}
+
+	p := &participant{userID: userID, pc: pc, signal: signal}
+	r := s.getOrCreateRoom(channelID)
+
+	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c != nil {
+			signal(SignalICECandidate, c.ToJSON())
+		}
+	})
+
+	pc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		if remote.Kind() == webrtc.RTPCodecTypeVideo {
+			done := make(chan struct{})
+			defer close(done)
+			go requestKeyframes(pc, remote.SSRC(), done)
+		}
+		s.forwardTrack(r, p, remote, receiver)
+	})
+
+	r.mu.Lock()
+	old := r.participants[userID]
+	r.participants[userID] = p
+	r.mu.Unlock()
+
+	if old != nil {
+		old.pc.Close()
+	}
+
+	s.renegotiate(r, p, true)
+	return nil
+}
+
+// forwardTrack rejestruje ścieżkę uczestnika w pokoju i przepisuje jej pakiety RTP.
+// Dla audio z rozszerzeniem ssrc-audio-level śledzi też aktywność głosową.
+// Strumień lokalny "user-<id>_<stream nadawcy>" pozwala odbiorcom dopasować ścieżkę
+// do ogłoszenia track-add (stream_id).
+// Odrzucane są pakiety audio wyciszonego uczestnika (SetMuted) i wszystkie
+// pakiety uczestnika bez prawa nadawania (SetSilenced).
+func (s *SFU) forwardTrack(r *room, owner *participant, remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	ownerID := owner.userID
+	local, err := webrtc.NewTrackLocalStaticRTP(
+		remote.Codec().RTPCodecCapability,
+		fmt.Sprintf("%s-%d-%s", remote.Kind(), ownerID, remote.ID()),
+		fmt.Sprintf("user-%d_%s", ownerID, remote.StreamID()),
+	)
+	if err != nil {
+		slog.Error("SFU: nie można utworzyć ścieżki lokalnej", "user_id", ownerID, "error", err)
+		return
+	}
+
+	r.mu.Lock()
+	r.tracks[local.ID()] = &forwardedTrack{ownerID: ownerID, local: local}
+	r.mu.Unlock()
+
+	s.renegotiateAll(r)
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.tracks, local.ID())
+		r.mu.Unlock()
+		s.renegotiateAll(r)
+	}()
+
+	var levelExtID uint8
+	if remote.Kind() == webrtc.RTPCodecTypeAudio {
+		for _, ext := range receiver.GetParameters().HeaderExtensions {
+			if ext.URI == sdp.AudioLevelURI {
+				levelExtID = uint8(ext.ID)
+			}
+		}
+	}
+
+	var activity vad
+	defer func() {
+		if activity.speaking {
+			s.emitSpeaking(r.channelID, ownerID, false)
+		}
+	}()
+
+	// Nagrywany jest tylko dźwięk; plik ścieżki zamyka się razem z nią
+	isAudio := remote.Kind() == webrtc.RTPCodecTypeAudio
+	recordable := isAudio
+	var recordedBy *recording
+	defer func() {
+		if recordedBy != nil {
+			recordedBy.endTrack(local.ID())
+		}
+	}()
+
+	buf := make([]byte, 1500)
+	var header rtp.Header
+	var pkt rtp.Packet
+	for {
+		n, _, err := remote.Read(buf)
+		if err != nil {
+			return
+		}
+
+		if owner.silenced.Load() || (isAudio && owner.muted.Load()) {
+			if activity.speaking {
+				activity = vad{}
+				s.emitSpeaking(r.channelID, ownerID, false)
+			}
+			continue
+		}
+
+		if rec := r.recording.Load(); recordable && rec != nil {
+			if rec != recordedBy && recordedBy != nil {
+				recordedBy.endTrack(local.ID())
+			}
+			recordedBy = rec
+			if err := pkt.Unmarshal(buf[:n]); err == nil {
+				rec.write(ownerID, local.ID(), &pkt)
+			}
+		}
+
+		if levelExtID != 0 {
+			if _, err := header.Unmarshal(buf[:n]); err == nil {
+				var level rtp.AudioLevelExtension
+				if ext := header.GetExtension(levelExtID); ext != nil && level.Unmarshal(ext) == nil {
+					if activity.update(level.Level, time.Now()) {
+						s.emitSpeaking(r.channelID, ownerID, activity.speaking)
+					}
+				}
+			}
+		}
+
+		if _, err := local.Write(buf[:n]); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			return
+		}
+	}
+}
+
+// requestKeyframes okresowo wysyła PLI do nadawcy ścieżki wideo
+func requestKeyframes(pc *webrtc.PeerConnection, ssrc webrtc.SSRC, done <-chan struct{}) {
+	ticker := time.NewTicker(keyframeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(ssrc)}}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// EnsureReceivers dba o to, by PeerConnection uczestnika miało co najmniej tyle
+// transceiverów odbiorczych audio i wideo, ile ścieżek ogłosił klient.
+// Nowe transceivery wymagają renegocjacji — serwer wysyła wtedy ofertę.
+func (s *SFU) EnsureReceivers(channelID, userID, audio, video int) error {
+	r, p, ok := s.getParticipant(channelID, userID)
+	if !ok {
+		return ErrNotJoined
+	}
+
+	p.mu.Lock()
+	have := map[webrtc.RTPCodecType]int{}
+	for _, t := range p.pc.GetTransceivers() {
+		if t.Direction() == webrtc.RTPTransceiverDirectionRecvonly {
+			have[t.Kind()]++
+		}
+	}
+	added := false
+	for kind, want := range map[webrtc.RTPCodecType]int{webrtc.RTPCodecTypeAudio: audio, webrtc.RTPCodecTypeVideo: video} {
+		for ; have[kind] < want; have[kind]++ {
+			if _, err := p.pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{
+				Direction: webrtc.RTPTransceiverDirectionRecvonly,
+			}); err != nil {
+				p.mu.Unlock()
+				return err
+			}
+			added = true
+		}
+	}
+	p.mu.Unlock()
+
+	if added {
+		s.renegotiate(r, p, true)
+	}
+	return nil
+}
+
+// renegotiateAll wysyła nowe oferty wszystkim uczestnikom, którym zmienił się zestaw ścieżek
+func (s *SFU) renegotiateAll(r *room) {
+	r.mu.Lock()
+	participants := make([]*participant, 0, len(r.participants))
+	for _, p := range r.participants {
+		participants = append(participants, p)
+	}
+	r.mu.Unlock()
+
+	for _, p := range participants {
+		s.renegotiate(r, p, false)
+	}
+}
+
+// renegotiate synchronizuje nadawców PeerConnection ze ścieżkami pokoju
+// i wysyła ofertę, jeśli coś się zmieniło (lub force)
+func (s *SFU) renegotiate(r *room, p *participant, force bool) {
+	// Migawka ścieżek — bez zagnieżdżania blokad pokoju i uczestnika.
+	// Wygłuszony uczestnik dostaje tylko obraz.
+	deafened := p.deafened.Load()
+	r.mu.Lock()
+	wanted := make(map[string]*webrtc.TrackLocalStaticRTP)
+	for id, t := range r.tracks {
+		if t.ownerID != p.userID && !(deafened && t.local.Kind() == webrtc.RTPCodecTypeAudio) {
+			wanted[id] = t.local
+		}
+	}
+	r.mu.Unlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		return
+	}
+
+	changed := force
+	for _, sender := range p.pc.GetSenders() {
+		track := sender.Track()
+		if track == nil {
+			continue
+		}
+		if _, ok := wanted[track.ID()]; ok {
+			delete(wanted, track.ID())
+			continue
+		}
+		if err := p.pc.RemoveTrack(sender); err == nil {
+			changed = true
+		}
+	}
+	for _, local := range wanted {
+		sender, err := p.pc.AddTrack(local)
+		if err != nil {
+			slog.Error("SFU: nie można dodać ścieżki", "user_id", p.userID, "track_id", local.ID(), "error", err)
+			continue
+		}
+		changed = true
+		go drainRTCP(sender)
+	}
+
+	if !changed && !p.pending {
+		return
+	}
+
+	// Oferta w trakcie — wyślemy nową po otrzymaniu odpowiedzi
+	if p.pc.SignalingState() != webrtc.SignalingStateStable {
+		p.pending = true
+		return
+	}
+	p.pending = false
+
+	offer, err := p.pc.CreateOffer(nil)
+	if err != nil {
+		slog.Error("SFU: błąd tworzenia oferty", "user_id", p.userID, "error", err)
+		return
+	}
+	if err := p.pc.SetLocalDescription(offer); err != nil {
+		slog.Error("SFU: błąd ustawiania oferty", "user_id", p.userID, "error", err)
+		return
+	}
+	p.signal(SignalOffer, offer)
+}
+
+// drainRTCP odczytuje pakiety RTCP nadawcy — wymagane, by działały interceptory
+func drainRTCP(sender *webrtc.RTPSender) {
+	buf := make([]byte, 1500)
+	for {
+		if _, _, err := sender.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+// HandleAnswer ustawia odpowiedź klienta na ofertę serwera
+func (s *SFU) HandleAnswer(channelID, userID int, answer webrtc.SessionDescription) error {
+	r, p, ok := s.getParticipant(channelID, userID)
+	if !ok {
+		return ErrNotJoined
+	}
+
+	p.mu.Lock()
+	err := p.pc.SetRemoteDescription(answer)
+	pending := p.pending
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if pending {
+		s.renegotiate(r, p, false)
+	}
+	return nil
+}
+
+// Resume ponawia negocjację po wznowieniu sesji signaling: oferta bez odpowiedzi
+// jest wysyłana ponownie, a w stanie stabilnym serwer wykonuje ICE restart
+func (s *SFU) Resume(channelID, userID int) error {
+	_, p, ok := s.getParticipant(channelID, userID)
+	if !ok {
+		return ErrNotJoined
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pc.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		return p.signal(SignalOffer, p.pc.PendingLocalDescription())
+	}
+
+	offer, err := p.pc.CreateOffer(&webrtc.OfferOptions{ICERestart: true})
+	if err != nil {
+		return err
+	}
+	if err := p.pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	return p.signal(SignalOffer, offer)
+}
+
+// AddICECandidate dodaje kandydata ICE od klienta
+func (s *SFU) AddICECandidate(channelID, userID int, candidate webrtc.ICECandidateInit) error {
+	_, p, ok := s.getParticipant(channelID, userID)
+	if !ok {
+		return ErrNotJoined
+	}
+	return p.pc.AddICECandidate(candidate)
+}
+
+// SetMuted włącza lub wyłącza przekazywanie dźwięku uczestnika (wyciszenie przez
+// moderatora — kamera i udostępnianie ekranu działają dalej). Wywoływać po Join —
+// stan nie przechodzi na kolejne połączenie.
+func (s *SFU) SetMuted(channelID, userID int, muted bool) error {
+	_, p, ok := s.getParticipant(channelID, userID)
+	if !ok {
+		return ErrNotJoined
+	}
+	p.muted.Store(muted)
+	return nil
+}
+
+// SetSilenced włącza lub wyłącza przekazywanie wszystkich mediów uczestnika
+// (słuchacz kanału scenicznego). Wywoływać po Join — stan nie przechodzi na
+// kolejne połączenie.
+func (s *SFU) SetSilenced(channelID, userID int, silenced bool) error {
+	_, p, ok := s.getParticipant(channelID, userID)
+	if !ok {
+		return ErrNotJoined
+	}
+	p.silenced.Store(silenced)
+	return nil
+}
+
+// SetDeafened odbiera lub przywraca uczestnikowi ścieżki audio pozostałych
+// (renegocjacja). Wywoływać po Join — stan nie przechodzi na kolejne połączenie.
+func (s *SFU) SetDeafened(channelID, userID int, deafened bool) error {
+	r, p, ok := s.getParticipant(channelID, userID)
+	if !ok {
+		return ErrNotJoined
+	}
+	if p.deafened.Swap(deafened) != deafened {
+		s.renegotiate(r, p, false)
+	}
+	return nil
+}
+
+// Leave zamyka połączenie uczestnika; jego ścieżki znikają z pokoju,
+// gdy zakończą się pętle przekazywania
+func (s *SFU) Leave(channelID, userID int) {
+	s.mu.Lock()
+	r, ok := s.rooms[channelID]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+
+	r.mu.Lock()
+	p, ok := r.participants[userID]
+	delete(r.participants, userID)
+	if len(r.participants) == 0 {
+		delete(s.rooms, channelID)
+	}
+	r.mu.Unlock()
+	s.mu.Unlock()
+
+	if ok {
+		p.pc.Close()
+	}
+}
+
+// Participants zwraca liczbę uczestników pokoju SFU
+func (s *SFU) Participants(channelID int) int {
+	s.mu.Lock()
+	r, ok := s.rooms[channelID]
+	s.mu.Unlock()
+	if !ok {
+		return 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.participants)
+}
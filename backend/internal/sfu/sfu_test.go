@@ -0,0 +1,268 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// opusSilence — ramka ciszy Opus (SFU nie dekoduje, liczy się tylko przepływ RTP)
+var opusSilence = []byte{0xf8, 0xff, 0xfe}
+
+// vp8Frame — dowolne bajty jako ramka VP8 (też tylko dla przepływu RTP)
+var vp8Frame = []byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a}
+
+// testClient — headless klient pion po drugiej stronie SFU
+type testClient struct {
+	pc     *webrtc.PeerConnection
+	tracks chan *webrtc.TrackRemote // ścieżki odebrane od SFU (OnTrack)
+}
+
+// newTestClient dołącza klienta do pokoju, nadającego ścieżki podanych rodzajów. Oferty
+// SFU obsługuje w kolejności nadejścia w osobnej goroutine — SignalFunc działa
+// pod blokadą uczestnika, więc HandleAnswer nie może być wywołane synchronicznie.
+func newTestClient(t *testing.T, s *SFU, channelID, userID int, send ...webrtc.RTPCodecType) *testClient {
+	t.Helper()
+
+	se := webrtc.SettingEngine{}
+	se.SetIncludeLoopbackCandidate(true)
+	api := webrtc.NewAPI(webrtc.WithSettingEngine(se))
+	pc, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	c := &testClient{pc: pc, tracks: make(chan *webrtc.TrackRemote, 4)}
+	pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		c.tracks <- track
+	})
+	// Przed pierwszą ofertą — ścieżki trafią do transceiverów odbiorczych SFU
+	for _, kind := range send {
+		c.sendMedia(t, kind)
+	}
+
+	signals := make(chan func(), 64)
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case f := <-signals:
+				f()
+			}
+		}
+	}()
+
+	signal := func(msgType string, payload interface{}) error {
+		switch msgType {
+		case SignalOffer:
+			offer := payload.(webrtc.SessionDescription)
+			signals <- func() { c.answer(t, s, channelID, userID, offer) }
+		case SignalICECandidate:
+			candidate := payload.(webrtc.ICECandidateInit)
+			signals <- func() { pc.AddICECandidate(candidate) }
+		}
+		return nil
+	}
+	if err := s.Join(channelID, userID, signal); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// answer — odpowiedź z kompletem kandydatów w SDP (bez trickle od klienta)
+func (c *testClient) answer(t *testing.T, s *SFU, channelID, userID int, offer webrtc.SessionDescription) {
+	if err := c.pc.SetRemoteDescription(offer); err != nil {
+		t.Error(err)
+		return
+	}
+	answer, err := c.pc.CreateAnswer(nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	gathered := webrtc.GatheringCompletePromise(c.pc)
+	if err := c.pc.SetLocalDescription(answer); err != nil {
+		t.Error(err)
+		return
+	}
+	<-gathered
+	if err := s.HandleAnswer(channelID, userID, *c.pc.LocalDescription()); err != nil {
+		t.Error(err)
+	}
+}
+
+// sendMedia dodaje ścieżkę (audio: Opus z mikrofonu, wideo: VP8 z kamery)
+// i nadaje próbki co 20 ms do końca testu
+func (c *testClient) sendMedia(t *testing.T, kind webrtc.RTPCodecType) {
+	t.Helper()
+	codec := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}
+	id, streamID, sample := "audio", "mic", opusSilence
+	if kind == webrtc.RTPCodecTypeVideo {
+		codec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+		id, streamID, sample = "video", "camera", vp8Frame
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(codec, id, streamID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.pc.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				track.WriteSample(media.Sample{Data: sample, Duration: 20 * time.Millisecond})
+			}
+		}
+	}()
+}
+
+// TestForwardOverLoopback — dwóch klientów w jednym pokoju: mikrofon A dociera
+// do B jako pakiety RTP, a do A nic nie wraca
+func TestForwardOverLoopback(t *testing.T) {
+	s, err := New(Config{IncludeLoopback: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newTestClient(t, s, 1, 1, webrtc.RTPCodecTypeAudio)
+	b := newTestClient(t, s, 1, 2)
+	t.Cleanup(func() {
+		s.Leave(1, 1)
+		s.Leave(1, 2)
+	})
+
+	var track *webrtc.TrackRemote
+	select {
+	case track = <-b.tracks:
+	case <-time.After(10 * time.Second):
+		t.Fatal("B nie dostał ścieżki A (OnTrack)")
+	}
+	if track.Kind() != webrtc.RTPCodecTypeAudio {
+		t.Fatalf("rodzaj ścieżki = %s, oczekiwano audio", track.Kind())
+	}
+	if want := "user-1_mic"; track.StreamID() != want {
+		t.Errorf("stream ID = %q, oczekiwano %q", track.StreamID(), want)
+	}
+
+	track.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 5; i++ {
+		pkt, _, err := track.ReadRTP()
+		if err != nil {
+			t.Fatalf("odbiór RTP: %v", err)
+		}
+		if pkt.PayloadType != uint8(track.PayloadType()) {
+			t.Errorf("payload type = %d, oczekiwano %d", pkt.PayloadType, track.PayloadType())
+		}
+	}
+
+	// Nadawca nie dostaje własnej ścieżki
+	select {
+	case echoed := <-a.tracks:
+		t.Fatalf("A dostał ścieżkę %q — SFU odesłał nadawcy jego mikrofon", echoed.ID())
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+// TestDeafenedGetsNoAudio — wygłuszony uczestnik nie dostaje cudzego dźwięku,
+// a po zdjęciu wygłuszenia ścieżka dochodzi renegocjacją
+func TestDeafenedGetsNoAudio(t *testing.T) {
+	s, err := New(Config{IncludeLoopback: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := newTestClient(t, s, 1, 2)
+	if err := s.SetDeafened(1, 2, true); err != nil {
+		t.Fatal(err)
+	}
+	newTestClient(t, s, 1, 1, webrtc.RTPCodecTypeAudio)
+	t.Cleanup(func() {
+		s.Leave(1, 1)
+		s.Leave(1, 2)
+	})
+
+	select {
+	case track := <-b.tracks:
+		t.Fatalf("wygłuszony B dostał ścieżkę %q", track.ID())
+	case <-time.After(time.Second):
+	}
+
+	if err := s.SetDeafened(1, 2, false); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case track := <-b.tracks:
+		if track.Kind() != webrtc.RTPCodecTypeAudio {
+			t.Fatalf("rodzaj ścieżki = %s, oczekiwano audio", track.Kind())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("B nie dostał ścieżki A po zdjęciu wygłuszenia")
+	}
+
+	if err := s.SetDeafened(1, 3, true); err != ErrNotJoined {
+		t.Errorf("SetDeafened(nieobecny) = %v, oczekiwano ErrNotJoined", err)
+	}
+}
+
+// TestMutedForwardsVideo — wyciszony przez moderatora uczestnik dalej nadaje
+// obraz, a jego dźwięk nie dociera do pozostałych
+func TestMutedForwardsVideo(t *testing.T) {
+	s, err := New(Config{IncludeLoopback: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newTestClient(t, s, 1, 1, webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo)
+	// Odbiór kamery — jak po ogłoszeniu ścieżki przez klienta (track-add)
+	if err := s.EnsureReceivers(1, 1, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetMuted(1, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	b := newTestClient(t, s, 1, 2)
+	t.Cleanup(func() {
+		s.Leave(1, 1)
+		s.Leave(1, 2)
+	})
+
+	// OnTrack odbiorcy wymaga pierwszego pakietu — dociera tylko obraz
+	var video *webrtc.TrackRemote
+	select {
+	case video = <-b.tracks:
+	case <-time.After(10 * time.Second):
+		t.Fatal("B nie dostał obrazu wyciszonego A")
+	}
+	if video.Kind() != webrtc.RTPCodecTypeVideo {
+		t.Fatalf("rodzaj ścieżki = %s, oczekiwano video", video.Kind())
+	}
+	video.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := video.ReadRTP(); err != nil {
+		t.Fatalf("odbiór RTP obrazu: %v", err)
+	}
+
+	select {
+	case track := <-b.tracks:
+		t.Fatalf("B dostał ścieżkę %s wyciszonego A", track.Kind())
+	case <-time.After(time.Second):
+	}
+
+	if err := s.SetMuted(1, 3, true); err != ErrNotJoined {
+		t.Errorf("SetMuted(nieobecny) = %v, oczekiwano ErrNotJoined", err)
+	}
+}
@@ -0,0 +1,33 @@
+package sfu
+
+import "time"
+
+// Detekcja mowy na podstawie poziomu dźwięku z rozszerzenia nagłówka RTP (RFC 6464).
+// Poziom to -dBov w zakresie 0–127: 0 = najgłośniej, 127 = cisza.
+const (
+	vadLevelThreshold = 50                     // poziom ≤ 50 (≥ -50 dBov) traktujemy jako mowę
+	vadHangover       = 400 * time.Millisecond // tyle ciszy kończy mówienie (bez migotania między słowami)
+)
+
+// vad — stan detekcji mowy jednej ścieżki audio
+type vad struct {
+	speaking  bool
+	lastVoice time.Time
+}
+
+// update przetwarza poziom z kolejnego pakietu; zwraca true przy zmianie stanu
+func (v *vad) update(level uint8, now time.Time) bool {
+	if level <= vadLevelThreshold {
+		v.lastVoice = now
+		if !v.speaking {
+			v.speaking = true
+			return true
+		}
+		return false
+	}
+	if v.speaking && now.Sub(v.lastVoice) > vadHangover {
+		v.speaking = false
+		return true
+	}
+	return false
+}
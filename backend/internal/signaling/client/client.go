@@ -0,0 +1,240 @@
+// Package client — klient protokołu signaling kanałów głosowych dla testów i botów.
+//
+//	c, err := client.Dial(ctx, "ws://localhost:8080/api", channelID, client.Options{Token: token})
+//	peers, err := c.WaitFor(ctx, signaling.TypeRoomPeers)
+//	c.SetMuted(true)
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"kodama-backend/internal/models"
+	"kodama-backend/internal/signaling"
+
+	"github.com/gorilla/websocket"
+)
+
+// Options — parametry połączenia
+type Options struct {
+	Token       string            // JWT użytkownika
+	ResumeToken string            // token wznowienia z room-peers/resumed (opcjonalny)
+	Version     int               // wersja protokołu (0 = signaling.CurrentVersion)
+	Dialer      *websocket.Dialer // nil = websocket.DefaultDialer
+	Header      http.Header
+	Query       url.Values // dodatkowe parametry URL, np. traceparent (przeglądarka nie ustawi nagłówka)
+}
+
+// Client — połączenie WebSocket mówiące protokołem signaling
+type Client struct {
+	conn    *websocket.Conn
+	version int
+
+	mu sync.Mutex // serializuje zapisy
+}
+
+// Dial łączy z /ws/voice/{channelID}; baseURL to adres API, np. "ws://localhost:8080/api"
+func Dial(ctx context.Context, baseURL string, channelID int, opts Options) (*Client, error) {
+	version := opts.Version
+	if version == 0 {
+		version = signaling.CurrentVersion
+	}
+	dialer := opts.Dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+
+	u, err := url.Parse(strings.TrimRight(baseURL, "/") + fmt.Sprintf("/ws/voice/%d", channelID))
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	for key, values := range opts.Query {
+		q[key] = values
+	}
+	q.Set("token", opts.Token)
+	if opts.ResumeToken != "" {
+		q.Set("resume", opts.ResumeToken)
+	}
+	u.RawQuery = q.Encode()
+
+	d := *dialer
+	d.Subprotocols = []string{signaling.Subprotocol(version)}
+
+	conn, resp, err := d.DialContext(ctx, u.String(), opts.Header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("signaling: połączenie odrzucone (HTTP %d): %w", resp.StatusCode, err)
+		}
+		return nil, err
+	}
+
+	negotiated, ok := signaling.ParseSubprotocol(conn.Subprotocol())
+	if !ok || negotiated != version {
+		conn.Close()
+		return nil, fmt.Errorf("signaling: serwer nie obsługuje wersji %d", version)
+	}
+
+	return &Client{conn: conn, version: negotiated}, nil
+}
+
+// Version — wynegocjowana wersja protokołu
+func (c *Client) Version() int {
+	return c.version
+}
+
+// Send wysyła ramkę protokołu
+func (c *Client) Send(msg signaling.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteJSON(msg)
+}
+
+// sendPayload wysyła wiadomość danego typu z payloadem
+func (c *Client) sendPayload(msgType string, to int, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return c.Send(signaling.Message{Type: msgType, To: to, Payload: data})
+}
+
+// Offer wysyła ofertę SDP do peera (tryb mesh)
+func (c *Client) Offer(to int, sdp string) error {
+	return c.sendPayload(signaling.TypeOffer, to, signaling.SessionDescription{Type: signaling.TypeOffer, SDP: sdp})
+}
+
+// Answer wysyła odpowiedź SDP do peera (tryb mesh)
+func (c *Client) Answer(to int, sdp string) error {
+	return c.sendPayload(signaling.TypeAnswer, to, signaling.SessionDescription{Type: signaling.TypeAnswer, SDP: sdp})
+}
+
+// ICECandidate wysyła kandydata ICE do peera (tryb mesh)
+func (c *Client) ICECandidate(to int, candidate signaling.ICECandidate) error {
+	return c.sendPayload(signaling.TypeICECandidate, to, candidate)
+}
+
+// SFUAnswer odpowiada na ofertę serwera SFU
+func (c *Client) SFUAnswer(sdp string) error {
+	return c.sendPayload(signaling.TypeSFUAnswer, 0, signaling.SessionDescription{Type: signaling.TypeAnswer, SDP: sdp})
+}
+
+// SFUICECandidate wysyła kandydata ICE do serwera SFU
+func (c *Client) SFUICECandidate(candidate signaling.ICECandidate) error {
+	return c.sendPayload(signaling.TypeSFUICECandidate, 0, candidate)
+}
+
+// SetMuted ogłasza stan mikrofonu
+func (c *Client) SetMuted(muted bool) error {
+	return c.Send(signaling.Message{Type: signaling.TypeMuteState, Muted: muted})
+}
+
+// SetDeafened ogłasza własne wygłuszenie
+func (c *Client) SetDeafened(deafened bool) error {
+	return c.Send(signaling.Message{Type: signaling.TypeDeafenState, Deafened: deafened})
+}
+
+// SetVideo ogłasza stan kamery
+func (c *Client) SetVideo(on bool) error {
+	return c.Send(signaling.Message{Type: signaling.TypeVideoState, Video: on})
+}
+
+// SetScreenShare ogłasza stan udostępniania ekranu
+func (c *Client) SetScreenShare(on bool) error {
+	return c.Send(signaling.Message{Type: signaling.TypeScreenShareState, ScreenShare: on})
+}
+
+// SetSpeaking ogłasza aktywność głosową (ignorowane przez serwer w trybie SFU)
+func (c *Client) SetSpeaking(speaking bool) error {
+	return c.Send(signaling.Message{Type: signaling.TypeSpeaking, Speaking: speaking})
+}
+
+// AddTrack ogłasza ścieżkę; serwer potwierdza echem track-add albo odmawia ramką
+// "error" (np. video_limit)
+func (c *Client) AddTrack(track models.VoiceTrack) error {
+	return c.sendPayload(signaling.TypeTrackAdd, 0, track)
+}
+
+// RemoveTrack wycofuje ogłoszoną ścieżkę
+func (c *Client) RemoveTrack(trackID string) error {
+	return c.sendPayload(signaling.TypeTrackRemove, 0, models.VoiceTrack{TrackID: trackID})
+}
+
+// MuteTrack wycisza lub włącza pojedynczą ścieżkę
+func (c *Client) MuteTrack(trackID string, muted bool) error {
+	return c.sendPayload(signaling.TypeTrackMute, 0, models.VoiceTrack{TrackID: trackID, Muted: muted})
+}
+
+// RaiseHand podnosi lub opuszcza rękę słuchacza sceny
+func (c *Client) RaiseHand(raised bool) error {
+	return c.Send(signaling.Message{Type: signaling.TypeRaiseHand, HandRaised: raised})
+}
+
+// SetSpeaker zatwierdza (true) lub odbiera głos uczestnikowi sceny; serwer
+// rozsyła stage-role albo odmawia ramką "error" (not_allowed)
+func (c *Client) SetSpeaker(userID int, speaker bool) error {
+	return c.Send(signaling.Message{Type: signaling.TypeStageSpeaker, To: userID, Speaker: speaker})
+}
+
+// Recv odczytuje następną ramkę; ramka "error" jest zwracana jako *signaling.Error
+func (c *Client) Recv(ctx context.Context) (*signaling.Message, error) {
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var msg signaling.Message
+	if err := c.conn.ReadJSON(&msg); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+
+	if msg.Type == signaling.TypeError {
+		var payload signaling.ErrorPayload
+		json.Unmarshal(msg.Payload, &payload)
+		return &msg, &signaling.Error{Code: payload.Code, Message: payload.Message, RefType: payload.RefType}
+	}
+	return &msg, nil
+}
+
+// WaitFor odczytuje ramki do pierwszej z podanych typów, pomijając pozostałe
+func (c *Client) WaitFor(ctx context.Context, types ...string) (*signaling.Message, error) {
+	for {
+		msg, err := c.Recv(ctx)
+		if err != nil {
+			return msg, err
+		}
+		for _, t := range types {
+			if msg.Type == t {
+				return msg, nil
+			}
+		}
+	}
+}
+
+// Peers dekoduje listę uczestników z room-peers/resumed/moved
+func Peers(msg *signaling.Message) ([]models.VoiceParticipant, error) {
+	var peers []models.VoiceParticipant
+	err := json.Unmarshal(msg.Payload, &peers)
+	return peers, err
+}
+
+// Close kończy sesję (normal closure — serwer od razu zwalnia miejsce w pokoju)
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second),
+	)
+	c.mu.Unlock()
+	return c.conn.Close()
+}
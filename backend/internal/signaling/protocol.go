@@ -0,0 +1,308 @@
+// Package signaling — protokół WebSocket signaling kanałów głosowych.
+//
+// Wersja protokołu jest negocjowana przy połączeniu nagłówkiem
+// Sec-WebSocket-Protocol ("kodama-signaling.v1"); klient bez subprotokołu
+// dostaje wersję 1. Wiadomości klienta są walidowane według typu, a błędy
+// wracają do nadawcy jako ramki "error".
+package signaling
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"kodama-backend/internal/models"
+)
+
+// Wersje protokołu
+const (
+	Version1       = 1
+	CurrentVersion = Version1
+
+	subprotocolPrefix = "kodama-signaling.v"
+)
+
+// MaxMessageSize — maksymalny rozmiar wiadomości klienta (SDP z wideo mieści się z zapasem)
+const MaxMessageSize = 64 << 10
+
+// Typy wiadomości klient → serwer
+const (
+	TypeOffer            = "offer"         // przekazywana do peera (To)
+	TypeAnswer           = "answer"        // przekazywana do peera (To)
+	TypeICECandidate     = "ice-candidate" // przekazywana do peera (To)
+	TypeMuteState        = "mute-state"
+	TypeDeafenState      = "deafen-state"
+	TypeVideoState       = "video-state"
+	TypeScreenShareState = "screen-share-state"
+	TypeSpeaking         = "speaking" // także serwer → klient (ograniczane czasowo; w trybie SFU wykrywane przez serwer)
+	TypeSFUAnswer        = "sfu-answer"
+	TypeSFUICECandidate  = "sfu-ice-candidate" // także serwer → klient
+	TypeTrackAdd         = "track-add"         // także serwer → klient (rozsyłane do całego pokoju, łącznie z nadawcą)
+	TypeTrackRemove      = "track-remove"      // także serwer → klient
+	TypeTrackMute        = "track-mute"        // także serwer → klient
+	TypeRaiseHand        = "raise-hand"        // słuchacz sceny prosi o głos (hand_raised); także serwer → klient
+	TypeStageSpeaker     = "stage-speaker"     // moderator zatwierdza/odbiera głos (to, speaker); mówca może sam zejść ze sceny
+)
+
+// Typy wiadomości serwer → klient
+const (
+	TypeRoomPeers         = "room-peers"
+	TypeResumed           = "resumed"
+	TypePeerJoined        = "peer-joined"
+	TypePeerLeft          = "peer-left"
+	TypeServerMuteState   = "server-mute-state"
+	TypeServerDeafenState = "server-deafen-state"
+	TypeMoved             = "moved"
+	TypeDisconnected      = "disconnected"
+	TypeSFUOffer          = "sfu-offer"
+	TypeRecordingState    = "recording-state" // payload: models.RecordingState
+	TypeStageRole         = "stage-role"      // nowa rola uczestnika sceny (to, stage_role); payload: models.VoiceParticipant
+	TypeServerShutdown    = "server-shutdown" // serwer się zamyka; klient dołącza ponownie (bez resume) po restarcie
+	TypeError             = "error"
+)
+
+// Kody błędów w ramkach "error"
+const (
+	ErrCodeBadJSON        = "bad_json"
+	ErrCodeUnknownType    = "unknown_type"
+	ErrCodeInvalidPayload = "invalid_payload"
+	ErrCodeInvalidTarget  = "invalid_target"
+	ErrCodeVideoLimit     = "video_limit" // kanał osiągnął limit nadających wideo
+	ErrCodeTrackLimit     = "track_limit" // klient ogłosił zbyt wiele ścieżek
+	ErrCodeUnknownTrack   = "unknown_track"
+	ErrCodeNotSpeaker     = "not_speaker"  // słuchacz sceny nie może nadawać
+	ErrCodeNotAllowed     = "not_allowed"  // brak uprawnień lub operacja niedostępna na tym kanale
+	ErrCodeRateLimited    = "rate_limited" // klient przekroczył limit wiadomości — kolejne są odrzucane
+)
+
+// Role uczestników kanału scenicznego
+const (
+	StageSpeaker  = "speaker"
+	StageAudience = "audience"
+)
+
+// Źródła ścieżek w track-add
+const (
+	SourceMicrophone  = "microphone"
+	SourceCamera      = "camera"
+	SourceScreen      = "screen"
+	SourceScreenAudio = "screen-audio"
+)
+
+// maxTrackIDLength — ograniczenie długości identyfikatorów ścieżek i strumieni
+const maxTrackIDLength = 128
+
+// Message — ramka protokołu (wspólna koperta dla wszystkich typów)
+type Message struct {
+	Type        string                     `json:"type"`
+	From        int                        `json:"from"`                   // user ID nadawcy (ustawiany przez serwer)
+	To          int                        `json:"to"`                     // user ID odbiorcy (0 = broadcast)
+	FromName    string                     `json:"from_name"`              // username nadawcy
+	Payload     json.RawMessage            `json:"payload"`                // treść zależna od typu
+	ChannelID   int                        `json:"channel_id"`             // ID kanału głosowego
+	Muted       bool                       `json:"muted"`                  // stan mikrofonu
+	Deafened    bool                       `json:"deafened"`               // stan słuchawek (deafen-state, server-deafen-state)
+	Speaking    bool                       `json:"speaking"`               // aktywność głosowa (speaking)
+	Video       bool                       `json:"video,omitempty"`        // kamera (video-state)
+	ScreenShare bool                       `json:"screen_share,omitempty"` // udostępnianie ekranu (screen-share-state)
+	Mode        string                     `json:"mode,omitempty"`         // tryb pokoju w room-peers/resumed/moved: "mesh" lub "sfu"
+	ICE         *models.ICEServersResponse `json:"ice,omitempty"`          // serwery STUN/TURN w room-peers/resumed
+	Resume      string                     `json:"resume_token,omitempty"` // token wznowienia sesji w room-peers/resumed
+	Limits      *models.MediaLimits        `json:"limits,omitempty"`       // limity mediów kanału w room-peers/resumed/moved
+	StageRole   string                     `json:"stage_role,omitempty"`   // rola na scenie w room-peers/resumed/moved/stage-role
+	HandRaised  bool                       `json:"hand_raised,omitempty"`  // podniesiona ręka (raise-hand)
+	Speaker     bool                       `json:"speaker,omitempty"`      // zatwierdzenie (true) lub odebranie głosu (stage-speaker)
+}
+
+// SessionDescription — payload offer/answer/sfu-offer/sfu-answer (RTCSessionDescriptionInit)
+type SessionDescription struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp"`
+}
+
+// ICECandidate — payload ice-candidate/sfu-ice-candidate (RTCIceCandidateInit)
+type ICECandidate struct {
+	Candidate        string  `json:"candidate"`
+	SDPMid           *string `json:"sdpMid,omitempty"`
+	SDPMLineIndex    *uint16 `json:"sdpMLineIndex,omitempty"`
+	UsernameFragment *string `json:"usernameFragment,omitempty"`
+}
+
+// ErrorPayload — payload ramki "error"
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	RefType string `json:"ref_type,omitempty"` // typ wiadomości, która spowodowała błąd
+}
+
+// Error — błąd protokołu odsyłany klientowi jako ramka "error"
+type Error struct {
+	Code    string
+	Message string
+	RefType string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Frame zamienia błąd na ramkę "error"
+func (e *Error) Frame() Message {
+	payload, _ := json.Marshal(ErrorPayload{Code: e.Code, Message: e.Message, RefType: e.RefType})
+	return Message{Type: TypeError, Payload: payload}
+}
+
+// Subprotocol — nazwa subprotokołu WebSocket dla wersji
+func Subprotocol(version int) string {
+	return subprotocolPrefix + strconv.Itoa(version)
+}
+
+// Subprotocols — obsługiwane subprotokoły w kolejności preferencji serwera
+func Subprotocols() []string {
+	return []string{Subprotocol(Version1)}
+}
+
+// ParseSubprotocol zwraca wersję protokołu z wynegocjowanego subprotokołu
+// ("" = klient bez subprotokołu, czyli wersja 1)
+func ParseSubprotocol(subprotocol string) (int, bool) {
+	if subprotocol == "" {
+		return Version1, true
+	}
+	v, err := strconv.Atoi(strings.TrimPrefix(subprotocol, subprotocolPrefix))
+	if err != nil || !strings.HasPrefix(subprotocol, subprotocolPrefix) {
+		return 0, false
+	}
+	for _, supported := range Subprotocols() {
+		if supported == subprotocol {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// Supports sprawdza czy któryś z subprotokołów oferowanych przez klienta jest obsługiwany
+// (brak oferty oznacza wersję 1)
+func Supports(offered []string) bool {
+	if len(offered) == 0 {
+		return true
+	}
+	for _, p := range offered {
+		if _, ok := ParseSubprotocol(p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeClientMessage parsuje i waliduje wiadomość klienta.
+// Zwracany błąd jest zawsze typu *Error.
+func DecodeClientMessage(data []byte) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, &Error{Code: ErrCodeBadJSON, Message: "Nieprawidłowy JSON"}
+	}
+	if err := validate(&msg); err != nil {
+		err.RefType = msg.Type
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func validate(msg *Message) *Error {
+	switch msg.Type {
+	case TypeOffer, TypeAnswer:
+		if msg.To <= 0 {
+			return &Error{Code: ErrCodeInvalidTarget, Message: "Brak odbiorcy (to)"}
+		}
+		return validateSDP(msg.Payload, msg.Type)
+
+	case TypeICECandidate:
+		if msg.To <= 0 {
+			return &Error{Code: ErrCodeInvalidTarget, Message: "Brak odbiorcy (to)"}
+		}
+		return validateCandidate(msg.Payload)
+
+	case TypeMuteState, TypeDeafenState, TypeVideoState, TypeScreenShareState, TypeSpeaking, TypeRaiseHand:
+		return nil
+
+	case TypeStageSpeaker:
+		if msg.To <= 0 {
+			return &Error{Code: ErrCodeInvalidTarget, Message: "Brak odbiorcy (to)"}
+		}
+		return nil
+
+	case TypeSFUAnswer:
+		return validateSDP(msg.Payload, TypeAnswer)
+
+	case TypeSFUICECandidate:
+		return validateCandidate(msg.Payload)
+
+	case TypeTrackAdd:
+		return validateTrack(msg.Payload)
+
+	case TypeTrackRemove, TypeTrackMute:
+		var track models.VoiceTrack
+		if err := json.Unmarshal(msg.Payload, &track); err != nil {
+			return &Error{Code: ErrCodeInvalidPayload, Message: "Payload musi być opisem ścieżki"}
+		}
+		if track.TrackID == "" {
+			return &Error{Code: ErrCodeInvalidPayload, Message: "Brak track_id"}
+		}
+		return nil
+
+	case "":
+		return &Error{Code: ErrCodeUnknownType, Message: "Brak typu wiadomości"}
+	}
+	return &Error{Code: ErrCodeUnknownType, Message: fmt.Sprintf("Nieznany typ wiadomości: %q", msg.Type)}
+}
+
+func validateSDP(payload json.RawMessage, sdpType string) *Error {
+	var desc SessionDescription
+	if err := json.Unmarshal(payload, &desc); err != nil {
+		return &Error{Code: ErrCodeInvalidPayload, Message: "Payload musi być opisem sesji SDP"}
+	}
+	if desc.Type != sdpType {
+		return &Error{Code: ErrCodeInvalidPayload, Message: fmt.Sprintf("Oczekiwano SDP typu %q", sdpType)}
+	}
+	if desc.SDP == "" {
+		return &Error{Code: ErrCodeInvalidPayload, Message: "Puste SDP"}
+	}
+	return nil
+}
+
+// validateTrack sprawdza ogłoszenie ścieżki: identyfikatory i zgodność rodzaju ze źródłem
+func validateTrack(payload json.RawMessage) *Error {
+	var track models.VoiceTrack
+	if err := json.Unmarshal(payload, &track); err != nil {
+		return &Error{Code: ErrCodeInvalidPayload, Message: "Payload musi być opisem ścieżki"}
+	}
+	if track.TrackID == "" || len(track.TrackID) > maxTrackIDLength || len(track.StreamID) > maxTrackIDLength {
+		return &Error{Code: ErrCodeInvalidPayload, Message: "Nieprawidłowy identyfikator ścieżki"}
+	}
+
+	var kind string
+	switch track.Source {
+	case SourceMicrophone, SourceScreenAudio:
+		kind = "audio"
+	case SourceCamera, SourceScreen:
+		kind = "video"
+	default:
+		return &Error{Code: ErrCodeInvalidPayload, Message: fmt.Sprintf("Nieznane źródło ścieżki: %q", track.Source)}
+	}
+	if track.Kind != kind {
+		return &Error{Code: ErrCodeInvalidPayload, Message: fmt.Sprintf("Źródło %q wymaga ścieżki typu %q", track.Source, kind)}
+	}
+	return nil
+}
+
+func validateCandidate(payload json.RawMessage) *Error {
+	var candidate ICECandidate
+	if err := json.Unmarshal(payload, &candidate); err != nil {
+		return &Error{Code: ErrCodeInvalidPayload, Message: "Payload musi być kandydatem ICE"}
+	}
+	if candidate.Candidate != "" && candidate.SDPMid == nil && candidate.SDPMLineIndex == nil {
+		return &Error{Code: ErrCodeInvalidPayload, Message: "Kandydat ICE wymaga sdpMid lub sdpMLineIndex"}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName — nazwa tracera spanów tworzonych przez kod aplikacji
+const instrumentationName = "kodama-backend"
+
+// Options — ustawienia eksportu (z config.Config)
+type Options struct {
+	Enabled     bool
+	ServiceName string
+	Endpoint    string  // URL kolektora OTLP/HTTP; pusty = OTEL_EXPORTER_OTLP_* albo localhost:4318
+	SampleRatio float64 // ułamek śladów rozpoczynanych na serwerze (kontynuowane zawsze)
+}
+
+// Setup instaluje globalny TracerProvider eksportujący spany przez OTLP/HTTP oraz
+// propagator W3C Trace Context. Wyłączony tracing zostawia domyślny no-op —
+// instrumentacja zostaje na miejscu, ale nic nie nagrywa. Zwraca funkcję
+// opróżniającą bufor spanów przy zamykaniu serwera.
+func Setup(ctx context.Context, opts Options) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !opts.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var exporterOpts []otlptracehttp.Option
+	if opts.Endpoint != "" {
+		exporterOpts = append(exporterOpts, otlptracehttp.WithEndpointURL(opts.Endpoint))
+	}
+	exporter, err := otlptracehttp.New(ctx, exporterOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(opts.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Tracer — tracer spanów aplikacji (no-op, gdy tracing jest wyłączony)
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// QueryParamPropagation przepisuje traceparent/tracestate z parametrów URL do
+// nagłówków — przeglądarka nie ustawi nagłówków przy otwieraniu WebSocketu.
+// Musi działać przed middleware tworzącym span żądania.
+func QueryParamPropagation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("traceparent") == "" {
+			query := r.URL.Query()
+			if parent := query.Get("traceparent"); parent != "" {
+				r.Header.Set("traceparent", parent)
+				if state := query.Get("tracestate"); state != "" {
+					r.Header.Set("tracestate", state)
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -42,6 +42,11 @@ export const api = {
       method: 'PUT',
       body: JSON.stringify(body),
     }),
+  patch: <T>(endpoint: string, body: unknown) =>
+    request<T>(endpoint, {
+      method: 'PATCH',
+      body: JSON.stringify(body),
+    }),
   delete: <T>(endpoint: string) =>
     request<T>(endpoint, { method: 'DELETE' }),
 };
@@ -53,11 +58,16 @@ import type {
   JoinServerRequest,
   ServerMember,
   Channel,
+  ChannelPosition,
   CreateChannelRequest,
   Message,
+  MessageSearchResponse,
   SendMessageRequest,
+  UpdateChannelRequest,
   VoiceParticipant,
   VoiceState,
+  ICEServersResponse,
+  VoiceRecording,
 } from '../types';
 
 export const serverApi = {
@@ -80,8 +90,12 @@ export const channelApi = {
     api.get<Channel[]>(`/servers/${serverId}/channels`),
   create: (serverId: number, data: CreateChannelRequest) =>
     api.post<Channel>(`/servers/${serverId}/channels`, data),
+  update: (serverId: number, channelId: number, data: UpdateChannelRequest) =>
+    api.patch<Channel>(`/servers/${serverId}/channels/${channelId}`, data),
   delete: (serverId: number, channelId: number) =>
     api.delete<{ message: string }>(`/servers/${serverId}/channels/${channelId}`),
+  updatePositions: (serverId: number, positions: ChannelPosition[]) =>
+    api.patch<Channel[]>(`/servers/${serverId}/channels/positions`, positions),
 };
 
 // Message API
@@ -97,6 +111,10 @@ export const messageApi = {
       `/servers/${serverId}/channels/${channelId}/messages`,
       data
     ),
+  search: (serverId: number, query: string, offset = 0) =>
+    api.get<MessageSearchResponse>(
+      `/servers/${serverId}/messages/search?q=${encodeURIComponent(query)}&offset=${offset}`
+    ),
 };
 
 // Voice API
@@ -113,5 +131,28 @@ export const voiceApi = {
     ),
   toggleMute: (muted: boolean) =>
     api.post<VoiceParticipant[]>('/voice/mute', { muted }),
+  toggleDeafen: (deafened: boolean) =>
+    api.post<VoiceParticipant[]>('/voice/deafen', { deafened }),
   getState: () => api.get<VoiceState>('/voice/state'),
+  getIceServers: () => api.get<ICEServersResponse>('/voice/ice-servers'),
+  startRecording: (serverId: number, channelId: number) =>
+    api.post<VoiceRecording>(`/servers/${serverId}/channels/${channelId}/voice/recording/start`, {}),
+  stopRecording: (serverId: number, channelId: number) =>
+    api.post<VoiceRecording>(`/servers/${serverId}/channels/${channelId}/voice/recording/stop`, {}),
+  listRecordings: (serverId: number, channelId: number) =>
+    api.get<VoiceRecording[]>(`/servers/${serverId}/channels/${channelId}/voice/recordings`),
+  getRecording: (serverId: number, recordingId: number) =>
+    api.get<VoiceRecording>(`/servers/${serverId}/voice/recordings/${recordingId}`),
+  // Plik Ogg/Opus — pobierany z tokenem, więc nie przez zwykły link
+  downloadRecordingTrack: async (serverId: number, recordingId: number, trackId: number): Promise<Blob> => {
+    const token = localStorage.getItem('kodama-token');
+    const response = await fetch(`${API_BASE}/servers/${serverId}/voice/recordings/${recordingId}/tracks/${trackId}`, {
+      headers: token ? { Authorization: `Bearer ${token}` } : {},
+    });
+    if (!response.ok) {
+      const data = await response.json().catch(() => null);
+      throw new Error(data?.error || 'Nie udało się pobrać nagrania');
+    }
+    return response.blob();
+  },
 };
@@ -11,6 +11,7 @@ interface CreateChannelFormProps {
 
 function CreateChannelForm({ type, onClose }: CreateChannelFormProps) {
   const [name, setName] = useState('');
+  const [stage, setStage] = useState(false);
   const { createChannel, isLoading } = useChannelStore();
   const { activeServer } = useServerStore();
 
@@ -18,7 +19,7 @@ function CreateChannelForm({ type, onClose }: CreateChannelFormProps) {
     e.preventDefault();
     if (!activeServer) return;
     try {
-      await createChannel(activeServer.server.id, name.trim(), type);
+      await createChannel(activeServer.server.id, name.trim(), type === 'voice' && stage ? 'stage' : type);
       onClose();
     } catch {
       // error in store
@@ -36,6 +37,12 @@ function CreateChannelForm({ type, onClose }: CreateChannelFormProps) {
         autoFocus
         className="create-channel-input"
       />
+      {type === 'voice' && (
+        <label className="create-channel-option">
+          <input type="checkbox" checked={stage} onChange={(e) => setStage(e.target.checked)} />
+          Scena (mówią tylko zatwierdzeni mówcy)
+        </label>
+      )}
       <div className="create-channel-actions">
         <button type="button" className="create-channel-cancel" onClick={onClose}>
           Anuluj
@@ -65,7 +72,7 @@ export function ChannelList() {
   const isOwner = activeServer.role === 'owner';
 
   const textChannels = channels.filter((c) => c.type === 'text');
-  const voiceChannels = channels.filter((c) => c.type === 'voice');
+  const voiceChannels = channels.filter((c) => c.type === 'voice' || c.type === 'stage');
 
   const handleChannelClick = (channel: Channel) => {
     setActiveChannel(channel, serverId);
@@ -151,7 +158,7 @@ export function ChannelList() {
               className={`channel-item ${activeChannel?.id === ch.id ? 'active' : ''}`}
               onClick={() => handleChannelClick(ch)}
             >
-              <span className="channel-icon voice-icon">)))</span>
+              <span className="channel-icon voice-icon">{ch.type === 'stage' ? '📢' : ')))'}</span>
               <span className="channel-name">{ch.name}</span>
               {isOwner && (
                 <button
@@ -168,9 +175,10 @@ export function ChannelList() {
               <div className="voice-participants-inline">
                 {voiceParticipants.map((p) => (
                   <div key={p.user_id} className="voice-participant-inline">
-                    <span className="voice-participant-dot" />
+                    <span className={`voice-participant-dot ${p.speaking ? 'speaking' : ''}`} />
                     <span className="voice-participant-name">{p.username}</span>
                     {p.muted && <span className="voice-muted-badge">MIC OFF</span>}
+                    {p.hand_raised && <span className="voice-hand-badge">✋</span>}
                   </div>
                 ))}
               </div>
@@ -1,27 +1,61 @@
-import { useState } from 'react';
+import { useEffect, useRef, useState } from 'react';
 import { useChannelStore } from '../../stores/channelStore';
 import { useServerStore } from '../../stores/serverStore';
+import { useAuthStore } from '../../stores/authStore';
+import { voiceService, type VideoSource } from '../../services/voiceService';
+import type { VoiceParticipant } from '../../types';
 import './VoiceView.css';
 
+function VideoTile({ stream, local }: { stream: MediaStream; local: boolean }) {
+  const ref = useRef<HTMLVideoElement>(null);
+
+  useEffect(() => {
+    if (ref.current && ref.current.srcObject !== stream) {
+      ref.current.srcObject = stream;
+    }
+  }, [stream]);
+
+  // Dźwięk idzie osobnymi elementami audio — wideo zawsze wyciszone
+  return <video ref={ref} className={`voice-participant-video ${local ? 'local' : ''}`} autoPlay playsInline muted />;
+}
+
 export function VoiceView() {
   const {
     activeChannel,
     voiceParticipants,
     currentVoiceChannelId,
     isMuted,
+    isDeafened,
+    isVideoOn,
+    isScreenSharing,
+    isRecording,
+    stageRole,
+    isHandRaised,
     joinVoice,
     leaveVoice,
     toggleMute,
+    toggleDeafen,
+    toggleVideo,
+    toggleRecording,
+    toggleHand,
+    setSpeaker,
+    error,
+    clearError,
   } = useChannelStore();
   const { activeServer } = useServerStore();
+  const { user } = useAuthStore();
   const [isJoining, setIsJoining] = useState(false);
   const [voiceError, setVoiceError] = useState<string | null>(null);
 
-  if (!activeChannel || activeChannel.type !== 'voice' || !activeServer) return null;
+  if (!activeChannel || (activeChannel.type !== 'voice' && activeChannel.type !== 'stage') || !activeServer) return null;
 
   const serverId = activeServer.server.id;
   const channelId = activeChannel.id;
   const isConnected = currentVoiceChannelId === channelId;
+  const isStage = activeChannel.type === 'stage';
+  // Nagrywanie i zarządzanie sceną — owner i moderatorzy
+  const isModerator = activeServer.role === 'owner' || activeServer.role === 'moderator';
+  const isAudience = isStage && stageRole === 'audience';
 
   const handleJoin = async () => {
     setIsJoining(true);
@@ -45,13 +79,61 @@ export function VoiceView() {
     setVoiceError(null);
   };
 
+  const renderParticipant = (p: VoiceParticipant) => {
+    const sources: VideoSource[] = ['screen', 'camera'];
+    const videos = sources
+      .map((source) => ({ source, stream: voiceService.getVideoStream(p.user_id, source) }))
+      .filter((v): v is { source: VideoSource; stream: MediaStream } => v.stream !== null);
+    const isMe = p.user_id === user?.id;
+    return (
+      <div
+        key={p.user_id}
+        className={`voice-participant-card ${p.muted ? 'muted' : ''} ${p.speaking ? 'speaking' : ''} ${videos.length > 0 ? 'has-video' : ''}`}
+      >
+        {videos.length > 0 ? (
+          videos.map((v) => (
+            <VideoTile key={v.source} stream={v.stream} local={isMe && v.source === 'camera'} />
+          ))
+        ) : (
+          <div className="voice-participant-avatar">
+            {p.username.charAt(0).toUpperCase()}
+          </div>
+        )}
+        <span className="voice-participant-username">{p.username}</span>
+        {p.muted || p.server_muted ? (
+          <span className="voice-participant-muted">🔇</span>
+        ) : (
+          <span className="voice-participant-active">🎙</span>
+        )}
+        {(p.deafened || p.server_deafened) && <span className="voice-participant-muted">🎧</span>}
+        {p.video && <span className="voice-participant-active">📹</span>}
+        {p.screen_share && <span className="voice-participant-active">🖥</span>}
+        {p.hand_raised && <span className="voice-participant-hand" title="Prosi o głos">✋</span>}
+        {isStage && isModerator && !isMe && (
+          <button
+            className="voice-stage-btn"
+            onClick={() => setSpeaker(p.user_id, p.stage_role !== 'speaker')}
+          >
+            {p.stage_role === 'speaker' ? 'Odbierz głos' : 'Zaproś na scenę'}
+          </button>
+        )}
+      </div>
+    );
+  };
+
+  const speakers = voiceParticipants.filter((p) => p.stage_role === 'speaker');
+  const audience = voiceParticipants.filter((p) => p.stage_role !== 'speaker');
+
   return (
     <div className="voice-view">
       {/* Header */}
       <div className="voice-header">
-        <span className="voice-header-icon">🎙</span>
+        <span className="voice-header-icon">{isStage ? '📢' : '🎙'}</span>
         <span className="voice-header-name">{activeChannel.name}</span>
         {isConnected && <span className="voice-header-live">LIVE</span>}
+        {isConnected && isRecording && (
+          <span className="voice-header-recording" title="Rozmowa jest nagrywana">● REC</span>
+        )}
       </div>
 
       {/* Content */}
@@ -59,8 +141,12 @@ export function VoiceView() {
         {!isConnected ? (
           <div className="voice-join-section">
             <div className="voice-join-icon">🎧</div>
-            <h3>Kanał głosowy</h3>
-            <p>Kliknij przycisk, aby dołączyć do rozmowy głosowej. Potrzebujesz mikrofonu.</p>
+            <h3>{isStage ? 'Scena' : 'Kanał głosowy'}</h3>
+            <p>
+              {isStage
+                ? 'Dołączysz jako słuchacz. Podnieś rękę, aby poprosić moderatora o głos.'
+                : 'Kliknij przycisk, aby dołączyć do rozmowy głosowej. Potrzebujesz mikrofonu.'}
+            </p>
             {voiceError && <div className="voice-error">{voiceError}</div>}
             <button
               className="voice-join-btn"
@@ -76,33 +162,87 @@ export function VoiceView() {
               <span className="voice-status-dot" />
               Połączono — głos przesyłany przez WebRTC
             </div>
+            {error && (
+              <div className="voice-error" onClick={clearError}>
+                {error}
+              </div>
+            )}
 
             {/* Participants */}
-            <div className="voice-participants-grid">
-              {voiceParticipants.map((p) => (
-                <div key={p.user_id} className={`voice-participant-card ${p.muted ? 'muted' : 'speaking'}`}>
-                  <div className="voice-participant-avatar">
-                    {p.username.charAt(0).toUpperCase()}
-                  </div>
-                  <span className="voice-participant-username">{p.username}</span>
-                  {p.muted ? (
-                    <span className="voice-participant-muted">🔇</span>
-                  ) : (
-                    <span className="voice-participant-active">🎙</span>
-                  )}
-                </div>
-              ))}
-            </div>
+            {isStage ? (
+              <>
+                <div className="voice-stage-section-title">Mówcy — {speakers.length}</div>
+                <div className="voice-participants-grid">{speakers.map(renderParticipant)}</div>
+                <div className="voice-stage-section-title">Słuchacze — {audience.length}</div>
+                <div className="voice-participants-grid audience">{audience.map(renderParticipant)}</div>
+              </>
+            ) : (
+              <div className="voice-participants-grid">{voiceParticipants.map(renderParticipant)}</div>
+            )}
 
             {/* Controls */}
             <div className="voice-controls">
+              {!isAudience && (
+                <button
+                  className={`voice-control-btn ${isMuted ? 'muted' : ''}`}
+                  onClick={toggleMute}
+                  title={isMuted ? 'Odcisz mikrofon' : 'Wycisz mikrofon'}
+                >
+                  {isMuted ? '🔇 Wyciszony' : '🎙 Mikrofon'}
+                </button>
+              )}
               <button
-                className={`voice-control-btn ${isMuted ? 'muted' : ''}`}
-                onClick={toggleMute}
-                title={isMuted ? 'Odcisz mikrofon' : 'Wycisz mikrofon'}
+                className={`voice-control-btn ${isDeafened ? 'muted' : ''}`}
+                onClick={toggleDeafen}
+                title={isDeafened ? 'Przywróć dźwięk' : 'Wygłusz'}
               >
-                {isMuted ? '🔇 Wyciszony' : '🎙 Mikrofon'}
+                {isDeafened ? '🔕 Wygłuszony' : '🎧 Słuchawki'}
               </button>
+              {!isAudience && (
+                <>
+                  <button
+                    className={`voice-control-btn ${isVideoOn ? 'active' : ''}`}
+                    onClick={() => toggleVideo('camera')}
+                    title={isVideoOn ? 'Wyłącz kamerę' : 'Włącz kamerę'}
+                  >
+                    {isVideoOn ? '📹 Kamera włączona' : '📷 Kamera'}
+                  </button>
+                  <button
+                    className={`voice-control-btn ${isScreenSharing ? 'active' : ''}`}
+                    onClick={() => toggleVideo('screen')}
+                    title={isScreenSharing ? 'Zakończ udostępnianie' : 'Udostępnij ekran'}
+                  >
+                    {isScreenSharing ? '🖥 Udostępniasz' : '🖥 Ekran'}
+                  </button>
+                </>
+              )}
+              {isAudience && !isModerator && (
+                <button
+                  className={`voice-control-btn ${isHandRaised ? 'active' : ''}`}
+                  onClick={toggleHand}
+                  title={isHandRaised ? 'Wycofaj prośbę o głos' : 'Poproś o głos'}
+                >
+                  {isHandRaised ? '✋ Ręka podniesiona' : '✋ Podnieś rękę'}
+                </button>
+              )}
+              {isStage && user && (isModerator || !isAudience) && (
+                <button
+                  className="voice-control-btn"
+                  onClick={() => setSpeaker(user.id, isAudience)}
+                  title={isAudience ? 'Wejdź na scenę' : 'Zejdź ze sceny'}
+                >
+                  {isAudience ? '🎤 Wejdź na scenę' : '⬇ Zejdź ze sceny'}
+                </button>
+              )}
+              {isModerator && activeChannel.voice_mode === 'sfu' && (
+                <button
+                  className={`voice-control-btn ${isRecording ? 'muted' : ''}`}
+                  onClick={() => toggleRecording(serverId)}
+                  title={isRecording ? 'Zakończ nagrywanie' : 'Nagrywaj kanał'}
+                >
+                  {isRecording ? '⏹ Zatrzymaj nagrywanie' : '⏺ Nagrywaj'}
+                </button>
+              )}
               <button className="voice-control-btn disconnect" onClick={handleLeave}>
                 📞 Rozłącz
               </button>
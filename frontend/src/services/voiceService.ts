@@ -3,10 +3,22 @@
 // Zarządza połączeniami peer-to-peer i strumieniami audio
 // ──────────────────────────────────────────────
 
+import type { ICEServersResponse, MediaLimits, RecordingState, StageRole, VoiceTrack } from '../types';
+
 export interface VoicePeer {
   userId: number;
   username: string;
   muted: boolean;
+  serverMuted: boolean;
+  serverDeafened: boolean;
+  deafened: boolean;
+  speaking: boolean;
+  video: boolean;
+  screenShare: boolean;
+  joinedAt?: string;
+  tracks: VoiceTrack[]; // ogłoszone ścieżki kamery i ekranu
+  stageRole?: StageRole; // tylko kanały sceniczne
+  handRaised: boolean;
   connection?: RTCPeerConnection;
   stream?: MediaStream;
 }
@@ -14,12 +26,61 @@ export interface VoicePeer {
 type VoiceEventType =
   | 'peers-updated'
   | 'connected'
+  | 'moved'
   | 'disconnected'
   | 'error';
 
 type VoiceEventCallback = (data?: unknown) => void;
 
-const ICE_SERVERS: RTCConfiguration = {
+interface RoomPeer {
+  user_id: number;
+  username: string;
+  muted: boolean;
+  server_muted?: boolean;
+  server_deafened?: boolean;
+  deafened?: boolean;
+  speaking?: boolean;
+  video?: boolean;
+  screen_share?: boolean;
+  joined_at?: string;
+  tracks?: VoiceTrack[];
+  stage_role?: StageRole;
+  hand_raised?: boolean;
+}
+
+export type VideoSource = 'camera' | 'screen';
+
+// Własna ścieżka wideo — strumień i track_id są stałe przez całą sesję, więc ponowne
+// włączenie kamery/ekranu podmienia tylko ścieżkę w istniejących nadawcach (bez renegocjacji)
+interface LocalVideo {
+  stream: MediaStream;
+  trackId: string;
+  track: MediaStreamTrack | null;
+  muted: boolean;
+}
+
+// Kody zamknięcia WebSocket wysyłane przez backend
+const CLOSE_CHANNEL_FULL = 4003;
+const CLOSE_DISCONNECTED = 4004;
+const CLOSE_SERVER_SHUTDOWN = 4006;
+const CLOSE_RATE_LIMITED = 4007;
+
+// Wersja protokołu signaling negocjowana przez Sec-WebSocket-Protocol
+const SIGNALING_PROTOCOL = 'kodama-signaling.v1';
+
+// Detekcja mowy w trybie mesh (w trybie SFU wykrywa ją serwer)
+const VAD_THRESHOLD = 0.02; // RMS sygnału mikrofonu
+const VAD_HANGOVER_MS = 400;
+const VAD_INTERVAL_MS = 100;
+
+// Backend trzyma miejsce w pokoju przez 15 s po zerwaniu połączenia
+const RESUME_WINDOW_MS = 15000;
+
+// Po restarcie serwera sesja przepada — dołączamy od nowa, czekając najwyżej minutę
+const REJOIN_WINDOW_MS = 60000;
+
+// Konfiguracja ICE do czasu otrzymania serwerów STUN/TURN z backendu (room-peers)
+const DEFAULT_ICE_CONFIG: RTCConfiguration = {
   iceServers: [
     { urls: 'stun:stun.l.google.com:19302' },
     { urls: 'stun:stun1.l.google.com:19302' },
@@ -34,8 +95,32 @@ export class VoiceService {
   private myUserId: number = 0;
   private myUsername: string = '';
   private isMuted: boolean = false;
+  private serverMuted: boolean = false;
+  private serverDeafened: boolean = false;
+  private mode: 'mesh' | 'sfu' = 'mesh';
+  private sfuConnection: RTCPeerConnection | null = null;
+  private iceConfig: RTCConfiguration = DEFAULT_ICE_CONFIG;
+  private wsUrl: string = '';
+  private resumeToken: string | null = null;
   private listeners: Map<VoiceEventType, Set<VoiceEventCallback>> = new Map();
   private audioElements: Map<number, HTMLAudioElement> = new Map();
+  private isSpeaking: boolean = false;
+  private isDeafened: boolean = false;
+  // Stan peerów z peer-joined — w trybie mesh połączenie powstaje dopiero przy ich offerze
+  private joinedStates: Map<number, RoomPeer> = new Map();
+  private vadContext: AudioContext | null = null;
+  private vadTimer: ReturnType<typeof setInterval> | null = null;
+  private limits: MediaLimits | null = null;
+  private recording: RecordingState = { recording: false };
+  private stageRole: StageRole | null = null; // null poza kanałem scenicznym
+  private handRaised: boolean = false;
+  private localVideo: Map<VideoSource, LocalVideo> = new Map();
+  // Ścieżki czekające na potwierdzenie track-add przez serwer (limit nadających wideo)
+  private pendingVideo: Map<VideoSource, MediaStreamTrack> = new Map();
+  private meshSenders: Map<number, Map<VideoSource, RTCRtpSender>> = new Map();
+  private sfuSenders: Map<VideoSource, RTCRtpSender> = new Map();
+  // Wideo pozostałych uczestników: "<userId>/<stream_id>" -> strumień
+  private remoteVideo: Map<string, MediaStream> = new Map();
 
   // ── Event system ──
 
@@ -62,6 +147,7 @@ export class VoiceService {
       await this.leave();
     }
 
+    this.resumeToken = null;
     this.channelId = channelId;
     this.myUserId = userId;
     this.myUsername = username;
@@ -81,6 +167,7 @@ export class VoiceService {
       this.emit('error', 'Nie udało się uzyskać dostępu do mikrofonu');
       throw err;
     }
+    this.startVoiceActivityDetection();
 
     // 2. Połącz WebSocket
     const token = localStorage.getItem('kodama-token');
@@ -101,28 +188,77 @@ export class VoiceService {
       wsUrl = `${protocol}//${window.location.host}/api/ws/voice/${channelId}?token=${token}`;
     }
 
+    this.wsUrl = wsUrl;
+    try {
+      await this.openSocket(wsUrl);
+    } catch (err) {
+      this.emit('error', 'Błąd połączenia WebSocket');
+      this.cleanup();
+      throw err;
+    }
+    this.emit('connected');
+  }
+
+  private openSocket(url: string): Promise<void> {
     return new Promise((resolve, reject) => {
-      this.ws = new WebSocket(wsUrl);
+      const ws = new WebSocket(url, SIGNALING_PROTOCOL);
+      this.ws = ws;
+      let opened = false;
 
-      this.ws.onopen = () => {
+      ws.onopen = () => {
         console.log('[Voice] WebSocket connected');
-        this.emit('connected');
+        opened = true;
         resolve();
       };
 
-      this.ws.onerror = (e) => {
+      ws.onerror = (e) => {
         console.error('[Voice] WebSocket error:', e);
-        this.emit('error', 'Błąd połączenia WebSocket');
         reject(e);
       };
 
-      this.ws.onclose = () => {
-        console.log('[Voice] WebSocket closed');
+      ws.onclose = (event) => {
+        if (this.ws !== ws) return;
+        if (!opened) {
+          // Nieudana próba połączenia — obsługuje ją wywołujący (reject)
+          this.ws = null;
+          return;
+        }
+        console.log(`[Voice] WebSocket closed (${event.code})`);
+        if (event.code === CLOSE_CHANNEL_FULL) {
+          this.emit('error', 'Kanał głosowy jest pełny');
+        }
+        if (event.code === CLOSE_DISCONNECTED) {
+          this.emit('error', 'Zostałeś rozłączony przez moderatora');
+        }
+        if (event.code === CLOSE_RATE_LIMITED) {
+          this.emit('error', 'Rozłączono — zbyt wiele wiadomości do serwera');
+        }
+
+        // Restart serwera — sesji nie da się wznowić, dołączamy do kanału od nowa
+        if (event.code === CLOSE_SERVER_SHUTDOWN && this.channelId !== null) {
+          this.ws = null;
+          this.rejoin();
+          return;
+        }
+
+        // Zerwane połączenie — próbujemy wznowić sesję bez opuszczania pokoju
+        const resumable =
+          this.resumeToken !== null &&
+          event.code !== 1000 &&
+          event.code !== CLOSE_CHANNEL_FULL &&
+          event.code !== CLOSE_DISCONNECTED &&
+          event.code !== CLOSE_RATE_LIMITED;
+        if (resumable) {
+          this.ws = null;
+          this.resume();
+          return;
+        }
+
         this.cleanup();
         this.emit('disconnected');
       };
 
-      this.ws.onmessage = (event) => {
+      ws.onmessage = (event) => {
         try {
           const msg = JSON.parse(event.data);
           this.handleSignalingMessage(msg);
@@ -133,23 +269,82 @@ export class VoiceService {
     });
   }
 
+  private async resume() {
+    const token = this.resumeToken;
+    const started = Date.now();
+    let delay = 500;
+
+    while (token && this.resumeToken === token && Date.now() - started < RESUME_WINDOW_MS) {
+      await new Promise((r) => setTimeout(r, delay));
+      if (this.resumeToken !== token) return;
+      try {
+        console.log('[Voice] Resuming session...');
+        await this.openSocket(`${this.wsUrl}&resume=${token}`);
+        return;
+      } catch {
+        delay = Math.min(delay * 2, 4000);
+      }
+    }
+
+    if (this.resumeToken === token) {
+      this.emit('error', 'Utracono połączenie z kanałem głosowym');
+      this.cleanup();
+      this.emit('disconnected');
+    }
+  }
+
+  private async rejoin() {
+    const channelId = this.channelId;
+    const started = Date.now();
+    let delay = 1000;
+
+    // Pokój na serwerze już nie istnieje — zostawiamy tylko mikrofon
+    this.resumeToken = null;
+    this.peers.forEach((_, userId) => this.removePeer(userId));
+    this.closeSfuConnection();
+    this.releaseVideo();
+    this.remoteVideo.clear();
+    this.emit('error', 'Serwer jest restartowany — ponowne łączenie…');
+    this.emit('peers-updated');
+
+    while (this.channelId === channelId && !this.ws && Date.now() - started < REJOIN_WINDOW_MS) {
+      await new Promise((r) => setTimeout(r, delay));
+      if (this.channelId !== channelId || this.ws) return;
+      try {
+        console.log('[Voice] Rejoining after server restart...');
+        await this.openSocket(this.wsUrl);
+        // Nowa sesja na serwerze nie zna naszego stanu wyciszenia
+        if (this.isMuted) this.setMuted(true);
+        if (this.isDeafened) this.setDeafened(true);
+        return;
+      } catch {
+        delay = Math.min(delay * 2, 8000);
+      }
+    }
+
+    if (this.channelId === channelId && !this.ws) {
+      this.emit('error', 'Nie udało się ponownie połączyć z kanałem głosowym');
+      this.cleanup();
+      this.emit('disconnected');
+    }
+  }
+
   async leave(): Promise<void> {
+    this.resumeToken = null;
     if (this.ws && this.ws.readyState === WebSocket.OPEN) {
-      this.ws.close();
+      this.ws.close(1000);
     }
     this.cleanup();
     this.emit('disconnected');
   }
 
   setMuted(muted: boolean): void {
-    this.isMuted = muted;
-
-    // Wycisz/odcisz lokalny stream
-    if (this.localStream) {
-      this.localStream.getAudioTracks().forEach((track) => {
-        track.enabled = !muted;
-      });
+    if (!muted && this.stageRole === 'audience') {
+      this.emit('error', 'Słuchacze sceny nie mogą mówić — podnieś rękę, aby poprosić o głos');
+      return;
     }
+    this.isMuted = muted;
+    this.applyLocalTrackState();
 
     // Powiadom pozostałych przez WebSocket
     this.sendSignal({
@@ -173,6 +368,146 @@ export class VoiceService {
     return this.isMuted;
   }
 
+  setDeafened(deafened: boolean): void {
+    this.isDeafened = deafened;
+    this.applyRemoteAudioState();
+
+    this.sendSignal({
+      type: 'deafen-state',
+      from: this.myUserId,
+      from_name: this.myUsername,
+      to: 0,
+      muted: this.isMuted,
+      deafened,
+      channel_id: this.channelId || 0,
+      payload: null,
+    });
+
+    this.emit('peers-updated');
+  }
+
+  getMyDeafenState(): boolean {
+    return this.isDeafened;
+  }
+
+  getMyServerState(): { serverMuted: boolean; serverDeafened: boolean } {
+    return { serverMuted: this.serverMuted, serverDeafened: this.serverDeafened };
+  }
+
+  getMySpeakingState(): boolean {
+    return this.isSpeaking;
+  }
+
+  getRecordingState(): RecordingState {
+    return this.recording;
+  }
+
+  getMyStageState(): { stageRole: StageRole | null; handRaised: boolean } {
+    return { stageRole: this.stageRole, handRaised: this.handRaised };
+  }
+
+  // Słuchacz sceny prosi o głos (lub wycofuje prośbę)
+  raiseHand(raised: boolean): void {
+    if (this.stageRole !== 'audience') return;
+    this.sendSignal({
+      type: 'raise-hand',
+      from: this.myUserId,
+      from_name: this.myUsername,
+      to: 0,
+      muted: this.isMuted,
+      hand_raised: raised,
+      channel_id: this.channelId || 0,
+      payload: null,
+    });
+  }
+
+  // Moderator zatwierdza lub odbiera głos; mówca może sam zejść ze sceny (userId = własne ID)
+  setSpeaker(userId: number, speaker: boolean): void {
+    this.sendSignal({
+      type: 'stage-speaker',
+      from: this.myUserId,
+      from_name: this.myUsername,
+      to: userId,
+      muted: this.isMuted,
+      speaker,
+      channel_id: this.channelId || 0,
+      payload: null,
+    });
+  }
+
+  getLimits(): MediaLimits | null {
+    return this.limits;
+  }
+
+  getMyVideoState(): { video: boolean; screenShare: boolean } {
+    const on = (source: VideoSource) => {
+      const local = this.localVideo.get(source);
+      return !!local?.track && !local.muted;
+    };
+    return { video: on('camera'), screenShare: on('screen') };
+  }
+
+  // Włącza kamerę lub udostępnianie ekranu. Media są wysyłane dopiero po potwierdzeniu
+  // track-add — serwer może odmówić, gdy kanał osiągnął limit nadających wideo.
+  async startVideo(source: VideoSource): Promise<void> {
+    if (!this.ws || this.localVideo.get(source)?.track || this.pendingVideo.has(source)) return;
+    if (this.stageRole === 'audience') {
+      this.emit('error', 'Słuchacze sceny nie mogą nadawać obrazu');
+      return;
+    }
+
+    let media: MediaStream;
+    try {
+      media =
+        source === 'camera'
+          ? await navigator.mediaDevices.getUserMedia({ video: { width: 1280, height: 720 } })
+          : await navigator.mediaDevices.getDisplayMedia({ video: true });
+    } catch (err) {
+      this.emit('error', source === 'camera' ? 'Nie udało się uzyskać dostępu do kamery' : 'Nie udało się udostępnić ekranu');
+      throw err;
+    }
+
+    const track = media.getVideoTracks()[0];
+    // Zakończenie udostępniania z poziomu przeglądarki
+    track.onended = () => this.stopVideo(source);
+
+    let local = this.localVideo.get(source);
+    if (!local) {
+      local = { stream: new MediaStream(), trackId: track.id, track: null, muted: false };
+      this.localVideo.set(source, local);
+    }
+    local.muted = false;
+    this.pendingVideo.set(source, track);
+    this.sendTrackSignal('track-add', source, local);
+  }
+
+  stopVideo(source: VideoSource): void {
+    const local = this.detachVideo(source);
+    if (!local) return;
+    this.sendTrackSignal('track-remove', source, local);
+    this.emit('peers-updated');
+  }
+
+  // Wstrzymanie ścieżki bez jej usuwania (pozostali widzą ją jako wyciszoną)
+  setVideoMuted(source: VideoSource, muted: boolean): void {
+    const local = this.localVideo.get(source);
+    if (!local?.track) return;
+    local.muted = muted;
+    local.track.enabled = !muted;
+    this.sendTrackSignal('track-mute', source, local);
+    this.emit('peers-updated');
+  }
+
+  // Strumień wideo uczestnika (także własny podgląd) lub null, gdy źródło jest wyłączone
+  getVideoStream(userId: number, source: VideoSource): MediaStream | null {
+    if (userId === this.myUserId) {
+      const local = this.localVideo.get(source);
+      return local?.track && !local.muted ? local.stream : null;
+    }
+    const track = this.peers.get(userId)?.tracks.find((t) => t.source === source && !t.muted);
+    return track ? this.remoteVideo.get(`${userId}/${track.stream_id}`) ?? null : null;
+  }
+
   isConnected(): boolean {
     return this.ws !== null && this.ws.readyState === WebSocket.OPEN;
   }
@@ -187,32 +522,265 @@ export class VoiceService {
     payload: unknown;
     channel_id: number;
     muted: boolean;
+    deafened?: boolean;
+    speaking?: boolean;
+    video?: boolean;
+    screen_share?: boolean;
+    mode?: 'mesh' | 'sfu';
+    ice?: ICEServersResponse;
+    resume_token?: string;
+    limits?: MediaLimits;
+    stage_role?: StageRole;
+    hand_raised?: boolean;
   }) {
     switch (msg.type) {
       case 'room-peers': {
-        // Lista istniejących peerów — tworzymy offer do każdego
-        const peers = msg.payload as Array<{
-          user_id: number;
-          username: string;
-          muted: boolean;
-        }>;
-        console.log(`[Voice] Room has ${peers.length} existing peers`);
-        for (const peer of peers) {
-          await this.createPeerConnection(peer.user_id, peer.username, peer.muted, true);
+        // Lista istniejących peerów — w trybie mesh tworzymy offer do każdego
+        const peers = msg.payload as RoomPeer[];
+        this.applySession(msg);
+        // Nowa sesja (także gdy wznowienie się nie udało) — zaczynamy od czystego stanu
+        this.peers.forEach((_, userId) => this.removePeer(userId));
+        this.closeSfuConnection();
+        this.applyStageRole(msg.stage_role ?? null);
+        console.log(`[Voice] Room has ${peers.length} existing peers (${msg.mode ?? 'mesh'})`);
+        await this.enterRoom(peers, msg.mode ?? 'mesh');
+        break;
+      }
+
+      case 'resumed': {
+        // Sesja wznowiona — uzupełniamy zmiany w pokoju, które przegapiliśmy
+        const peers = msg.payload as RoomPeer[];
+        console.log(`[Voice] Session resumed, room has ${peers.length} peers`);
+        this.applySession(msg);
+        if ((msg.stage_role ?? null) !== this.stageRole) this.applyStageRole(msg.stage_role ?? null);
+        await this.reconcilePeers(peers);
+        this.emit('peers-updated');
+        break;
+      }
+
+      case 'moved': {
+        // Moderator przeniósł nas na inny kanał — negocjujemy od nowa z nowym pokojem
+        console.log(`[Voice] Moved to channel ${msg.channel_id} by ${msg.from_name}`);
+        this.peers.forEach((_, userId) => this.removePeer(userId));
+        this.closeSfuConnection();
+        // Serwer usunął nasze ścieżki — kamerę i ekran trzeba włączyć ponownie
+        this.releaseVideo();
+        this.channelId = msg.channel_id;
+        if (msg.limits) this.limits = msg.limits;
+        this.applyStageRole(msg.stage_role ?? null);
+        this.emit('moved', msg.channel_id);
+        await this.enterRoom(msg.payload as RoomPeer[], msg.mode ?? 'mesh');
+        this.emit('peers-updated');
+        break;
+      }
+
+      case 'sfu-offer': {
+        // Serwer SFU zawsze inicjuje negocjację — odpowiadamy na każdą ofertę
+        const pc = this.sfuConnection;
+        if (!pc) break;
+        await pc.setRemoteDescription(new RTCSessionDescription(msg.payload as RTCSessionDescriptionInit));
+        await this.attachSfuVideo(pc);
+        const answer = await pc.createAnswer();
+        await pc.setLocalDescription(answer);
+        this.sendSignal({
+          type: 'sfu-answer',
+          from: this.myUserId,
+          from_name: this.myUsername,
+          to: 0,
+          payload: pc.localDescription!.toJSON(),
+          channel_id: this.channelId || 0,
+          muted: this.isMuted,
+        });
+        this.applySenderLimits(pc);
+        break;
+      }
+
+      case 'track-add': {
+        const track = msg.payload as VoiceTrack;
+        if (msg.from === this.myUserId) {
+          // Potwierdzenie — zaczynamy wysyłać
+          await this.publishVideo(track.source as VideoSource);
+        } else {
+          const peer = this.peers.get(msg.from);
+          if (peer) {
+            peer.tracks = [...peer.tracks.filter((t) => t.track_id !== track.track_id), track];
+            this.syncPeerMediaFlags(peer);
+          }
+        }
+        this.emit('peers-updated');
+        break;
+      }
+
+      case 'track-remove':
+      case 'track-mute': {
+        const track = msg.payload as VoiceTrack;
+        const peer = this.peers.get(msg.from);
+        if (peer) {
+          peer.tracks =
+            msg.type === 'track-remove'
+              ? peer.tracks.filter((t) => t.track_id !== track.track_id)
+              : peer.tracks.map((t) => (t.track_id === track.track_id ? track : t));
+          this.syncPeerMediaFlags(peer);
+          this.emit('peers-updated');
+        }
+        break;
+      }
+
+      case 'sfu-ice-candidate': {
+        try {
+          await this.sfuConnection?.addIceCandidate(new RTCIceCandidate(msg.payload as RTCIceCandidateInit));
+        } catch (err) {
+          console.warn('[Voice] Failed to add SFU ICE candidate:', err);
+        }
+        break;
+      }
+
+      case 'server-mute-state': {
+        if (msg.to === this.myUserId) {
+          this.serverMuted = msg.muted;
+          this.applyLocalTrackState();
+        } else {
+          const peer = this.peers.get(msg.to);
+          if (peer) peer.serverMuted = msg.muted;
+        }
+        this.emit('peers-updated');
+        break;
+      }
+
+      case 'server-deafen-state': {
+        const deafened = msg.deafened ?? false;
+        if (msg.to === this.myUserId) {
+          this.serverDeafened = deafened;
+          this.applyRemoteAudioState();
+        } else {
+          const peer = this.peers.get(msg.to);
+          if (peer) peer.serverDeafened = deafened;
+        }
+        this.emit('peers-updated');
+        break;
+      }
+
+      case 'deafen-state': {
+        const peer = this.peers.get(msg.from);
+        if (peer) peer.deafened = msg.deafened ?? false;
+        this.emit('peers-updated');
+        break;
+      }
+
+      case 'video-state': {
+        const peer = this.peers.get(msg.from);
+        if (peer) peer.video = msg.video ?? false;
+        this.emit('peers-updated');
+        break;
+      }
+
+      case 'screen-share-state': {
+        const peer = this.peers.get(msg.from);
+        if (peer) peer.screenShare = msg.screen_share ?? false;
+        this.emit('peers-updated');
+        break;
+      }
+
+      case 'speaking': {
+        const speaking = msg.speaking ?? false;
+        if (msg.from === this.myUserId) {
+          // W trybie SFU serwer wykrywa też naszą mowę
+          this.isSpeaking = speaking;
+        } else {
+          const peer = this.peers.get(msg.from);
+          if (peer) peer.speaking = speaking;
         }
+        this.emit('peers-updated');
+        break;
+      }
+
+      case 'raise-hand': {
+        const raised = msg.hand_raised ?? false;
+        if (msg.from === this.myUserId) {
+          this.handRaised = raised;
+        } else {
+          const peer = this.peers.get(msg.from);
+          if (peer) peer.handRaised = raised;
+        }
+        this.emit('peers-updated');
+        break;
+      }
+
+      case 'stage-role': {
+        // Moderator zatwierdził lub odebrał głos — payload to pełny stan uczestnika
+        if (msg.to === this.myUserId) {
+          this.applyStageRole(msg.stage_role ?? null);
+          this.isMuted = msg.muted;
+          this.applyLocalTrackState();
+        } else {
+          const peer = this.peers.get(msg.to);
+          if (peer) this.applyPeerState(peer, msg.payload as RoomPeer);
+        }
+        this.emit('peers-updated');
+        break;
+      }
+
+      case 'recording-state': {
+        // Serwer informuje każdego uczestnika o rozpoczęciu i zakończeniu nagrywania
+        this.recording = msg.payload as RecordingState;
+        console.log(`[Voice] Recording ${this.recording.recording ? 'started' : 'stopped'}`);
+        this.emit('peers-updated');
+        break;
+      }
+
+      case 'error': {
+        // Backend odrzucił naszą wiadomość (walidacja protokołu)
+        const err = msg.payload as { code: string; message: string; ref_type?: string };
+        console.warn(`[Voice] Signaling error (${err.ref_type ?? '?'}): ${err.code} — ${err.message}`);
+        if (err.ref_type === 'track-add') {
+          // Odmowa (np. limit wideo) — zwalniamy kamerę/ekran, które czekały na potwierdzenie
+          this.pendingVideo.forEach((track, source) => {
+            track.onended = null;
+            track.stop();
+            if (!this.localVideo.get(source)?.track) this.localVideo.delete(source);
+          });
+          this.pendingVideo.clear();
+          this.emit('error', err.message);
+          this.emit('peers-updated');
+        } else if (err.code === 'not_speaker' || err.code === 'not_allowed') {
+          this.emit('error', err.message);
+        }
+        break;
+      }
+
+      case 'server-shutdown': {
+        // Backend zaraz zamknie połączenie z kodem CLOSE_SERVER_SHUTDOWN
+        console.log('[Voice] Server is shutting down');
+        break;
+      }
+
+      case 'disconnected': {
+        // Backend zaraz zamknie połączenie z kodem CLOSE_DISCONNECTED
+        console.log(`[Voice] Disconnected by ${msg.from_name}`);
         break;
       }
 
       case 'peer-joined': {
         // Nowy peer — czekamy na jego offer
         console.log(`[Voice] Peer joined: ${msg.from_name} (${msg.from})`);
-        // Peer joined, ale to ON wyśle nam offer (bo dostał room-peers)
+        // Peer joined, ale to ON wyśle nam offer (bo dostał room-peers).
+        // W trybie SFU jego audio przyjdzie przez połączenie z serwerem.
+        const state = (msg.payload as RoomPeer | null) ?? { user_id: msg.from, username: msg.from_name, muted: false };
+        if (this.mode === 'sfu') {
+          this.addRosterPeer(state);
+          this.emit('peers-updated');
+        } else {
+          // Nowa sesja peera — poprzednie połączenie z nim (jeśli było) jest nieaktualne
+          this.removePeer(msg.from);
+          this.joinedStates.set(msg.from, state);
+        }
         break;
       }
 
       case 'peer-left': {
         console.log(`[Voice] Peer left: ${msg.from_name} (${msg.from})`);
         this.removePeer(msg.from);
+        this.joinedStates.delete(msg.from);
         this.emit('peers-updated');
         break;
       }
@@ -245,6 +813,194 @@ export class VoiceService {
     }
   }
 
+  private applySession(msg: { ice?: ICEServersResponse; resume_token?: string; limits?: MediaLimits }) {
+    if (msg.limits) {
+      this.limits = msg.limits;
+      this.applyAllSenderLimits();
+    }
+    if (msg.ice && msg.ice.ice_servers.length > 0) {
+      // Serwery STUN/TURN z backendu (dane TURN są tymczasowe, ważne na czas sesji)
+      this.iceConfig = { iceServers: msg.ice.ice_servers };
+    }
+    if (msg.resume_token) {
+      this.resumeToken = msg.resume_token;
+    }
+  }
+
+  private async reconcilePeers(peers: RoomPeer[]) {
+    const present = new Set(peers.map((p) => p.user_id));
+    this.peers.forEach((_, userId) => {
+      if (!present.has(userId)) this.removePeer(userId);
+    });
+
+    for (const peer of peers) {
+      const known = this.peers.get(peer.user_id);
+      if (known) {
+        this.applyPeerState(known, peer);
+      } else if (this.mode === 'sfu') {
+        this.addRosterPeer(peer);
+      } else {
+        // Peer dołączył podczas przerwy — jego offer do nas przepadł, więc inicjujemy sami
+        await this.connectToPeers([peer]);
+      }
+    }
+  }
+
+  private async enterRoom(peers: RoomPeer[], mode: 'mesh' | 'sfu') {
+    this.mode = mode;
+    if (mode === 'sfu') {
+      peers.forEach((peer) => this.addRosterPeer(peer));
+      this.createSfuConnection();
+      this.emit('peers-updated');
+      return;
+    }
+    await this.connectToPeers(peers);
+  }
+
+  // ── SFU ──
+
+  private addRosterPeer(peer: RoomPeer) {
+    const created: VoicePeer = {
+      userId: peer.user_id,
+      username: peer.username,
+      muted: peer.muted,
+      serverMuted: false,
+      serverDeafened: false,
+      deafened: false,
+      speaking: false,
+      video: false,
+      screenShare: false,
+      tracks: [],
+      handRaised: false,
+    };
+    this.applyPeerState(created, peer);
+    this.peers.set(peer.user_id, created);
+  }
+
+  private applyPeerState(peer: VoicePeer, state: RoomPeer) {
+    peer.muted = state.muted;
+    peer.serverMuted = state.server_muted ?? false;
+    peer.serverDeafened = state.server_deafened ?? false;
+    peer.deafened = state.deafened ?? false;
+    peer.speaking = state.speaking ?? false;
+    peer.video = state.video ?? false;
+    peer.screenShare = state.screen_share ?? false;
+    peer.joinedAt = state.joined_at;
+    peer.tracks = state.tracks ?? [];
+    peer.stageRole = state.stage_role;
+    peer.handRaised = state.hand_raised ?? false;
+  }
+
+  // Flagi kamery/ekranu wynikają z ogłoszonych, niewyciszonych ścieżek
+  private syncPeerMediaFlags(peer: VoicePeer) {
+    peer.video = peer.tracks.some((t) => t.source === 'camera' && !t.muted);
+    peer.screenShare = peer.tracks.some((t) => t.source === 'screen' && !t.muted);
+  }
+
+  private createSfuConnection() {
+    const pc = new RTCPeerConnection(this.iceConfig);
+    this.sfuConnection = pc;
+
+    // Transceivery z addTrack zostaną dopasowane do oferty serwera
+    if (this.localStream) {
+      this.localStream.getTracks().forEach((track) => {
+        pc.addTrack(track, this.localStream!);
+      });
+    }
+
+    // Serwer oznacza strumienie jako "user-<id>_<stream_id nadawcy>"
+    pc.ontrack = (event) => {
+      const stream = event.streams[0];
+      const match = stream?.id.match(/^user-(\d+)(?:_(.*))?$/);
+      const userId = Number(match?.[1]);
+      if (!stream || !userId) return;
+      if (event.track.kind === 'video') {
+        this.addRemoteVideo(userId, match?.[2] ?? '', event.track);
+        return;
+      }
+      const peer = this.peers.get(userId);
+      if (peer) peer.stream = stream;
+      this.playRemoteAudio(userId, stream);
+      stream.onremovetrack = () => {
+        if (stream.getTracks().length === 0) {
+          const audio = this.audioElements.get(userId);
+          if (audio) {
+            audio.srcObject = null;
+            this.audioElements.delete(userId);
+          }
+        }
+      };
+      this.emit('peers-updated');
+    };
+
+    pc.onicecandidate = (event) => {
+      if (event.candidate) {
+        this.sendSignal({
+          type: 'sfu-ice-candidate',
+          from: this.myUserId,
+          from_name: this.myUsername,
+          to: 0,
+          payload: event.candidate.toJSON(),
+          channel_id: this.channelId || 0,
+          muted: this.isMuted,
+        });
+      }
+    };
+  }
+
+  private closeSfuConnection() {
+    this.sfuConnection?.close();
+    this.sfuConnection = null;
+    this.sfuSenders.clear();
+  }
+
+  // Podpina włączone kamerę/ekran do transceiverów odbiorczych serwera (oferta po track-add).
+  // Wolny slot to m-linia, w której serwer tylko odbiera (a=recvonly), bez naszej ścieżki.
+  private async attachSfuVideo(pc: RTCPeerConnection) {
+    const directions = new Map<string, string>();
+    let mid = '';
+    for (const line of (pc.remoteDescription?.sdp ?? '').split(/\r?\n/)) {
+      if (line.startsWith('a=mid:')) mid = line.slice(6);
+      if (/^a=(sendrecv|sendonly|recvonly|inactive)$/.test(line)) directions.set(mid, line.slice(2));
+    }
+
+    const used = new Set(this.sfuSenders.values());
+    for (const [source, local] of this.localVideo) {
+      if (!local.track) continue;
+      const existing = this.sfuSenders.get(source);
+      if (existing) {
+        if (existing.track !== local.track) await existing.replaceTrack(local.track);
+        continue;
+      }
+      const slot = pc
+        .getTransceivers()
+        .find(
+          (t) =>
+            t.mid !== null &&
+            directions.get(t.mid) === 'recvonly' &&
+            t.receiver.track.kind === 'video' &&
+            !t.sender.track &&
+            !used.has(t.sender)
+        );
+      if (!slot) continue; // slot przyjdzie w kolejnej ofercie serwera
+      await slot.sender.replaceTrack(local.track);
+      slot.sender.setStreams?.(local.stream);
+      slot.direction = 'sendonly';
+      this.sfuSenders.set(source, slot.sender);
+      used.add(slot.sender);
+    }
+  }
+
+  private async connectToPeers(peers: RoomPeer[]) {
+    for (const peer of peers) {
+      const pc = await this.createPeerConnection(peer.user_id, peer.username, peer.muted, true);
+      const created = this.peers.get(peer.user_id);
+      if (created?.connection === pc) {
+        this.applyPeerState(created, peer);
+      }
+    }
+  }
+
   private async createPeerConnection(
     remoteUserId: number,
     remoteUsername: string,
@@ -254,14 +1010,27 @@ export class VoiceService {
     // Zamknij istniejące połączenie jeśli jest
     this.removePeer(remoteUserId);
 
-    const pc = new RTCPeerConnection(ICE_SERVERS);
+    const pc = new RTCPeerConnection(this.iceConfig);
 
     const peer: VoicePeer = {
       userId: remoteUserId,
       username: remoteUsername,
       muted: remoteMuted,
+      serverMuted: false,
+      serverDeafened: false,
+      deafened: false,
+      speaking: false,
+      video: false,
+      screenShare: false,
+      tracks: [],
+      handRaised: false,
       connection: pc,
     };
+    const joined = this.joinedStates.get(remoteUserId);
+    if (joined) {
+      this.applyPeerState(peer, joined);
+      this.joinedStates.delete(remoteUserId);
+    }
     this.peers.set(remoteUserId, peer);
 
     // Dodaj lokalny stream
@@ -271,10 +1040,19 @@ export class VoiceService {
       });
     }
 
+    // Włączone kamera i ekran
+    this.localVideo.forEach((local, source) => {
+      if (local.track) this.attachMeshVideo(remoteUserId, pc, source, local);
+    });
+
     // Obsłuż remote stream
     pc.ontrack = (event) => {
-      console.log(`[Voice] Got remote track from ${remoteUsername}`);
+      console.log(`[Voice] Got remote ${event.track.kind} track from ${remoteUsername}`);
       const remoteStream = event.streams[0];
+      if (event.track.kind === 'video') {
+        if (remoteStream) this.addRemoteVideo(remoteUserId, remoteStream.id, event.track);
+        return;
+      }
       peer.stream = remoteStream;
 
       // Odtwarzaj audio
@@ -304,32 +1082,51 @@ export class VoiceService {
       }
     };
 
+    // Renegocjacja po dodaniu kamery/ekranu — pierwszą ofertę wysyła nowy uczestnik
+    pc.onnegotiationneeded = async () => {
+      if (!pc.remoteDescription || pc.signalingState !== 'stable') return;
+      await this.sendOffer(remoteUserId, pc);
+    };
+
     // Tworzymy offer jeśli to my inicjujemy (jesteśmy nowym peerem)
     if (createOffer) {
-      const offer = await pc.createOffer();
-      await pc.setLocalDescription(offer);
-
-      this.sendSignal({
-        type: 'offer',
-        from: this.myUserId,
-        from_name: this.myUsername,
-        to: remoteUserId,
-        payload: pc.localDescription!.toJSON(),
-        channel_id: this.channelId || 0,
-        muted: this.isMuted,
-      });
+      await this.sendOffer(remoteUserId, pc);
     }
 
     this.emit('peers-updated');
     return pc;
   }
 
+  private async sendOffer(remoteUserId: number, pc: RTCPeerConnection) {
+    const offer = await pc.createOffer();
+    await pc.setLocalDescription(offer);
+
+    this.sendSignal({
+      type: 'offer',
+      from: this.myUserId,
+      from_name: this.myUsername,
+      to: remoteUserId,
+      payload: pc.localDescription!.toJSON(),
+      channel_id: this.channelId || 0,
+      muted: this.isMuted,
+    });
+  }
+
   private async handleOffer(
     fromUserId: number,
     fromUsername: string,
     offer: RTCSessionDescriptionInit
   ) {
-    const pc = await this.createPeerConnection(fromUserId, fromUsername, false, false);
+    let pc = this.peers.get(fromUserId)?.connection;
+    if (pc && pc.signalingState !== 'closed') {
+      // Renegocjacja istniejącego połączenia. Przy kolizji ofert ustępuje peer z niższym ID.
+      if (pc.signalingState !== 'stable') {
+        if (this.myUserId > fromUserId) return;
+        await pc.setLocalDescription({ type: 'rollback' });
+      }
+    } else {
+      pc = await this.createPeerConnection(fromUserId, fromUsername, false, false);
+    }
     await pc.setRemoteDescription(new RTCSessionDescription(offer));
 
     const answer = await pc.createAnswer();
@@ -344,13 +1141,156 @@ export class VoiceService {
       channel_id: this.channelId || 0,
       muted: this.isMuted,
     });
+    this.applySenderLimits(pc);
   }
 
   private async handleAnswer(fromUserId: number, answer: RTCSessionDescriptionInit) {
     const peer = this.peers.get(fromUserId);
     if (peer?.connection) {
       await peer.connection.setRemoteDescription(new RTCSessionDescription(answer));
+      this.applySenderLimits(peer.connection);
+    }
+  }
+
+  // ── Video ──
+
+  private sendTrackSignal(type: 'track-add' | 'track-remove' | 'track-mute', source: VideoSource, local: LocalVideo) {
+    const track: VoiceTrack = {
+      track_id: local.trackId,
+      stream_id: local.stream.id,
+      kind: 'video',
+      source,
+      muted: local.muted,
+    };
+    this.sendSignal({
+      type,
+      from: this.myUserId,
+      from_name: this.myUsername,
+      to: 0,
+      payload: track,
+      channel_id: this.channelId || 0,
+      muted: this.isMuted,
+    });
+  }
+
+  // Serwer potwierdził track-add — ścieżka trafia do wszystkich połączeń
+  private async publishVideo(source: VideoSource) {
+    const track = this.pendingVideo.get(source);
+    const local = this.localVideo.get(source);
+    if (!track || !local) return;
+    this.pendingVideo.delete(source);
+
+    local.stream.getTracks().forEach((t) => local.stream.removeTrack(t));
+    local.stream.addTrack(track);
+    local.track = track;
+
+    if (this.mode === 'sfu') {
+      // Nowy slot przyjdzie w ofercie serwera; istniejący dostaje ścieżkę od razu
+      const sender = this.sfuSenders.get(source);
+      if (sender) {
+        await sender.replaceTrack(track);
+        if (this.sfuConnection) this.applySenderLimits(this.sfuConnection);
+      }
+      return;
+    }
+    this.peers.forEach((peer, userId) => {
+      if (peer.connection) this.attachMeshVideo(userId, peer.connection, source, local);
+    });
+  }
+
+  // Pierwsze włączenie źródła dodaje nadawcę (renegocjacja), kolejne tylko podmieniają ścieżkę
+  private attachMeshVideo(userId: number, pc: RTCPeerConnection, source: VideoSource, local: LocalVideo) {
+    let senders = this.meshSenders.get(userId);
+    if (!senders) {
+      senders = new Map();
+      this.meshSenders.set(userId, senders);
     }
+    const sender = senders.get(source);
+    if (sender) {
+      sender.replaceTrack(local.track).then(() => this.applySenderLimits(pc));
+    } else if (local.track) {
+      senders.set(source, pc.addTrack(local.track, local.stream));
+    }
+  }
+
+  // Zatrzymuje własne kamerę i ekran bez powiadamiania serwera
+  // Zatrzymuje kamerę/ekran bez sygnalizacji; zwraca opis ścieżki, jeśli była aktywna
+  private detachVideo(source: VideoSource): LocalVideo | null {
+    const pending = this.pendingVideo.get(source);
+    if (pending) {
+      pending.stop();
+      this.pendingVideo.delete(source);
+    }
+    const local = this.localVideo.get(source);
+    if (!local?.track) return null;
+
+    local.track.onended = null;
+    local.track.stop();
+    local.stream.removeTrack(local.track);
+    local.track = null;
+
+    // Nadawcy zostają bez ścieżki — ponowne włączenie nie wymaga renegocjacji
+    this.meshSenders.forEach((senders) => senders.get(source)?.replaceTrack(null));
+    this.sfuSenders.get(source)?.replaceTrack(null);
+    return local;
+  }
+
+  // Nowa rola na scenie (null poza sceną). Słuchacz ma wyłączony mikrofon, a jego
+  // kamerę i ekran serwer już wycofał — zatrzymujemy je lokalnie bez track-remove.
+  private applyStageRole(role: StageRole | null) {
+    this.stageRole = role;
+    this.handRaised = false;
+    if (role === 'audience') {
+      this.isMuted = true;
+      this.detachVideo('camera');
+      this.detachVideo('screen');
+    }
+    this.applyLocalTrackState();
+  }
+
+  private releaseVideo() {
+    this.pendingVideo.forEach((track) => track.stop());
+    this.pendingVideo.clear();
+    this.localVideo.forEach((local) => {
+      if (local.track) local.track.onended = null;
+      local.track?.stop();
+    });
+    this.localVideo.clear();
+  }
+
+  private addRemoteVideo(userId: number, streamId: string, track: MediaStreamTrack) {
+    const key = `${userId}/${streamId}`;
+    this.remoteVideo.set(key, new MediaStream([track]));
+    track.onended = () => {
+      this.remoteVideo.delete(key);
+      this.emit('peers-updated');
+    };
+    this.emit('peers-updated');
+  }
+
+  // Limity bitrate kanału (room-peers/resumed/moved) dla wszystkich nadawców połączenia
+  private applySenderLimits(pc: RTCPeerConnection) {
+    const limits = this.limits;
+    if (!limits) return;
+    pc.getSenders().forEach((sender) => {
+      const kind = sender.track?.kind;
+      if (!kind) return;
+      const maxBitrate = kind === 'video' ? limits.video_bitrate : limits.audio_bitrate;
+      const params = sender.getParameters();
+      // Parametry kodowania są dostępne dopiero po negocjacji
+      if (!params.encodings?.length || params.encodings.every((e) => e.maxBitrate === maxBitrate)) return;
+      params.encodings.forEach((e) => {
+        e.maxBitrate = maxBitrate;
+      });
+      sender.setParameters(params).catch((err) => console.warn('[Voice] Failed to apply bitrate limit:', err));
+    });
+  }
+
+  private applyAllSenderLimits() {
+    this.peers.forEach((peer) => {
+      if (peer.connection) this.applySenderLimits(peer.connection);
+    });
+    if (this.sfuConnection) this.applySenderLimits(this.sfuConnection);
   }
 
   private async handleIceCandidate(fromUserId: number, candidate: RTCIceCandidateInit) {
@@ -377,6 +1317,7 @@ export class VoiceService {
     const audio = new Audio();
     audio.srcObject = stream;
     audio.autoplay = true;
+    audio.muted = this.serverDeafened || this.isDeafened;
     (audio as HTMLAudioElement & { playsInline: boolean }).playsInline = true;
     // Nie dodajemy do DOM — Audio() działa bez tego
     audio.play().catch((err) => {
@@ -396,12 +1337,84 @@ export class VoiceService {
     payload: unknown;
     channel_id: number;
     muted: boolean;
+    deafened?: boolean;
+    speaking?: boolean;
+    hand_raised?: boolean;
+    speaker?: boolean;
   }) {
     if (this.ws && this.ws.readyState === WebSocket.OPEN) {
       this.ws.send(JSON.stringify(msg));
     }
   }
 
+  private applyLocalTrackState() {
+    // Wycisz/odcisz lokalny stream (własny mute, mute moderatora lub rola słuchacza sceny)
+    if (this.localStream) {
+      const enabled = !this.isMuted && !this.serverMuted && this.stageRole !== 'audience';
+      this.localStream.getAudioTracks().forEach((track) => {
+        track.enabled = enabled;
+      });
+    }
+  }
+
+  // ── Voice activity ──
+
+  private startVoiceActivityDetection() {
+    if (!this.localStream) return;
+    const ctx = new AudioContext();
+    const analyser = ctx.createAnalyser();
+    analyser.fftSize = 512;
+    ctx.createMediaStreamSource(this.localStream).connect(analyser);
+    this.vadContext = ctx;
+
+    const samples = new Float32Array(analyser.fftSize);
+    let lastVoice = 0;
+    this.vadTimer = setInterval(() => {
+      // W trybie SFU stan mówienia przychodzi od serwera
+      if (this.mode === 'sfu') return;
+
+      analyser.getFloatTimeDomainData(samples);
+      let sum = 0;
+      for (const v of samples) sum += v * v;
+      const rms = Math.sqrt(sum / samples.length);
+
+      const now = Date.now();
+      const active = !this.isMuted && !this.serverMuted && rms > VAD_THRESHOLD;
+      if (active) lastVoice = now;
+      const speaking = active || (this.isSpeaking && !this.isMuted && !this.serverMuted && now - lastVoice < VAD_HANGOVER_MS);
+      if (speaking !== this.isSpeaking) {
+        this.isSpeaking = speaking;
+        this.sendSignal({
+          type: 'speaking',
+          from: this.myUserId,
+          from_name: this.myUsername,
+          to: 0,
+          payload: null,
+          channel_id: this.channelId || 0,
+          muted: this.isMuted,
+          speaking,
+        });
+        this.emit('peers-updated');
+      }
+    }, VAD_INTERVAL_MS);
+  }
+
+  private stopVoiceActivityDetection() {
+    if (this.vadTimer) clearInterval(this.vadTimer);
+    this.vadTimer = null;
+    this.vadContext?.close();
+    this.vadContext = null;
+    this.isSpeaking = false;
+  }
+
+  private applyRemoteAudioState() {
+    // Własne wygłuszenie lub wygłuszenie moderatora
+    const muted = this.serverDeafened || this.isDeafened;
+    this.audioElements.forEach((audio) => {
+      audio.muted = muted;
+    });
+  }
+
   private removePeer(userId: number) {
     const peer = this.peers.get(userId);
     if (peer) {
@@ -414,14 +1427,31 @@ export class VoiceService {
       }
       this.peers.delete(userId);
     }
+    this.meshSenders.delete(userId);
+    this.remoteVideo.forEach((_, key) => {
+      if (key.startsWith(`${userId}/`)) this.remoteVideo.delete(key);
+    });
   }
 
   private cleanup() {
     // Zamknij wszystkie połączenia peer
     this.peers.forEach((_, userId) => this.removePeer(userId));
     this.peers.clear();
+    this.closeSfuConnection();
+    this.mode = 'mesh';
+    this.iceConfig = DEFAULT_ICE_CONFIG;
+    this.resumeToken = null;
+
+    // Zatrzymaj kamerę i udostępnianie ekranu
+    this.releaseVideo();
+    this.remoteVideo.clear();
+    this.limits = null;
+    this.recording = { recording: false };
+    this.stageRole = null;
+    this.handRaised = false;
 
     // Zatrzymaj lokalny stream (mikrofon)
+    this.stopVoiceActivityDetection();
     if (this.localStream) {
       this.localStream.getTracks().forEach((track) => track.stop());
       this.localStream = null;
@@ -430,13 +1460,17 @@ export class VoiceService {
     // Zamknij WebSocket
     if (this.ws) {
       if (this.ws.readyState === WebSocket.OPEN) {
-        this.ws.close();
+        this.ws.close(1000);
       }
       this.ws = null;
     }
 
     this.channelId = null;
     this.isMuted = false;
+    this.serverMuted = false;
+    this.serverDeafened = false;
+    this.isDeafened = false;
+    this.joinedStates.clear();
 
     // Wyczyść elementy audio
     this.audioElements.forEach((audio) => {
@@ -1,7 +1,7 @@
 import { create } from 'zustand';
 import { channelApi, messageApi, voiceApi } from '../api/client';
-import { voiceService } from '../services/voiceService';
-import type { Channel, Message, VoiceParticipant } from '../types';
+import { voiceService, type VideoSource } from '../services/voiceService';
+import type { Channel, Message, StageRole, VoiceParticipant } from '../types';
 
 interface ChannelState {
   channels: Channel[];
@@ -11,11 +11,17 @@ interface ChannelState {
   voiceParticipants: VoiceParticipant[];
   currentVoiceChannelId: number | null;
   isMuted: boolean;
+  isDeafened: boolean;
+  isVideoOn: boolean;
+  isScreenSharing: boolean;
+  isRecording: boolean;
+  stageRole: StageRole | null; // null poza kanałem scenicznym
+  isHandRaised: boolean;
   isLoading: boolean;
   error: string | null;
 
   fetchChannels: (serverId: number) => Promise<void>;
-  createChannel: (serverId: number, name: string, type: 'text' | 'voice') => Promise<void>;
+  createChannel: (serverId: number, name: string, type: 'text' | 'voice' | 'stage') => Promise<void>;
   deleteChannel: (serverId: number, channelId: number) => Promise<void>;
   setActiveChannel: (channel: Channel | null, serverId: number) => void;
 
@@ -29,6 +35,11 @@ interface ChannelState {
   joinVoice: (serverId: number, channelId: number) => Promise<void>;
   leaveVoice: () => Promise<void>;
   toggleMute: () => Promise<void>;
+  toggleDeafen: () => Promise<void>;
+  toggleVideo: (source: VideoSource) => Promise<void>;
+  toggleRecording: (serverId: number) => Promise<void>;
+  toggleHand: () => void;
+  setSpeaker: (userId: number, speaker: boolean) => void;
   fetchVoiceParticipants: (serverId: number, channelId: number) => Promise<void>;
   fetchMyVoiceState: () => Promise<void>;
 
@@ -44,6 +55,12 @@ export const useChannelStore = create<ChannelState>((set, get) => ({
   voiceParticipants: [],
   currentVoiceChannelId: null,
   isMuted: false,
+  isDeafened: false,
+  isVideoOn: false,
+  isScreenSharing: false,
+  isRecording: false,
+  stageRole: null,
+  isHandRaised: false,
   isLoading: false,
   error: null,
 
@@ -56,7 +73,7 @@ export const useChannelStore = create<ChannelState>((set, get) => ({
     }
   },
 
-  createChannel: async (serverId: number, name: string, type: 'text' | 'voice') => {
+  createChannel: async (serverId: number, name: string, type: 'text' | 'voice' | 'stage') => {
     set({ isLoading: true, error: null });
     try {
       const channel = await channelApi.create(serverId, { name, type });
@@ -92,7 +109,7 @@ export const useChannelStore = create<ChannelState>((set, get) => ({
     if (channel && channel.type === 'text') {
       get().fetchMessages(serverId, channel.id);
     }
-    if (channel && channel.type === 'voice') {
+    if (channel && (channel.type === 'voice' || channel.type === 'stage')) {
       get().fetchVoiceParticipants(serverId, channel.id);
     }
   },
@@ -163,26 +180,76 @@ export const useChannelStore = create<ChannelState>((set, get) => ({
       // Nasłuchuj aktualizacji peerów
       const updatePeers = () => {
         const peers = voiceService.getPeers();
+        const myVideo = voiceService.getMyVideoState();
+        const myStage = voiceService.getMyStageState();
         const voiceParticipants: VoiceParticipant[] = [
           // Ja
-          { user_id: user.id, username: user.username, muted: voiceService.getMyMuteState() },
+          {
+            user_id: user.id,
+            username: user.username,
+            muted: voiceService.getMyMuteState(),
+            server_muted: voiceService.getMyServerState().serverMuted,
+            server_deafened: voiceService.getMyServerState().serverDeafened,
+            deafened: voiceService.getMyDeafenState(),
+            speaking: voiceService.getMySpeakingState(),
+            video: myVideo.video,
+            screen_share: myVideo.screenShare,
+            stage_role: myStage.stageRole ?? undefined,
+            hand_raised: myStage.handRaised,
+          },
           // Inni peerzy
           ...peers.map((p) => ({
             user_id: p.userId,
             username: p.username,
             muted: p.muted,
+            server_muted: p.serverMuted,
+            server_deafened: p.serverDeafened,
+            deafened: p.deafened,
+            speaking: p.speaking,
+            video: p.video,
+            screen_share: p.screenShare,
+            joined_at: p.joinedAt,
+            tracks: p.tracks,
+            stage_role: p.stageRole,
+            hand_raised: p.handRaised,
           })),
         ];
-        set({ voiceParticipants, isMuted: voiceService.getMyMuteState() });
+        set({
+          voiceParticipants,
+          isMuted: voiceService.getMyMuteState(),
+          isDeafened: voiceService.getMyDeafenState(),
+          isVideoOn: myVideo.video,
+          isScreenSharing: myVideo.screenShare,
+          isRecording: voiceService.getRecordingState().recording,
+          stageRole: myStage.stageRole,
+          isHandRaised: myStage.handRaised,
+        });
+      };
+      // Odmowy serwera w trakcie rozmowy (np. limit osób nadających wideo)
+      const onError = (message?: unknown) => {
+        set({ error: message as string });
+      };
+      const onMoved = (movedTo?: unknown) => {
+        set({ currentVoiceChannelId: movedTo as number });
       };
 
       voiceService.on('peers-updated', updatePeers);
+      voiceService.on('moved', onMoved);
+      voiceService.on('error', onError);
       voiceService.on('disconnected', () => {
         voiceService.off('peers-updated', updatePeers);
+        voiceService.off('moved', onMoved);
+        voiceService.off('error', onError);
         set({
           voiceParticipants: [],
           currentVoiceChannelId: null,
           isMuted: false,
+          isDeafened: false,
+          isVideoOn: false,
+          isScreenSharing: false,
+          isRecording: false,
+          stageRole: null,
+          isHandRaised: false,
         });
       });
 
@@ -190,6 +257,7 @@ export const useChannelStore = create<ChannelState>((set, get) => ({
         voiceParticipants: participants,
         currentVoiceChannelId: channelId,
         isMuted: false,
+        isDeafened: false,
       });
     } catch (err) {
       const message = err instanceof Error ? err.message : 'Błąd dołączania do kanału głosowego';
@@ -208,6 +276,12 @@ export const useChannelStore = create<ChannelState>((set, get) => ({
         voiceParticipants: [],
         currentVoiceChannelId: null,
         isMuted: false,
+        isDeafened: false,
+        isVideoOn: false,
+        isScreenSharing: false,
+        isRecording: false,
+        stageRole: null,
+        isHandRaised: false,
       });
     } catch (err) {
       const message = err instanceof Error ? err.message : 'Błąd opuszczania kanału głosowego';
@@ -216,19 +290,62 @@ export const useChannelStore = create<ChannelState>((set, get) => ({
   },
 
   toggleMute: async () => {
-    const { isMuted } = get();
+    const newMuted = !get().isMuted;
+    // Ustaw mute w WebRTC; serwer dostaje mute-state przez WebSocket
+    voiceService.setMuted(newMuted);
+    // Słuchacz sceny nie może włączyć mikrofonu — voiceService odrzuca zmianę
+    if (voiceService.getMyMuteState() !== newMuted) return;
+    set({ isMuted: newMuted });
+  },
+
+  toggleDeafen: async () => {
+    const newDeafened = !get().isDeafened;
+    // Serwer dostaje deafen-state przez WebSocket
+    voiceService.setDeafened(newDeafened);
+    set({ isDeafened: newDeafened });
+  },
+
+  toggleVideo: async (source: VideoSource) => {
+    // Stan (isVideoOn/isScreenSharing) aktualizuje peers-updated po potwierdzeniu serwera
+    const { isVideoOn, isScreenSharing } = get();
+    const on = source === 'camera' ? isVideoOn : isScreenSharing;
+    try {
+      if (on) {
+        voiceService.stopVideo(source);
+      } else {
+        await voiceService.startVideo(source);
+      }
+    } catch (err) {
+      console.error('Błąd zmiany stanu wideo:', err);
+    }
+  },
+
+  toggleRecording: async (serverId: number) => {
+    // Stan isRecording aktualizuje broadcast recording-state
+    const { isRecording, currentVoiceChannelId } = get();
+    if (!currentVoiceChannelId) return;
     try {
-      const newMuted = !isMuted;
-      // Ustaw mute w WebRTC
-      voiceService.setMuted(newMuted);
-      // Powiadom serwer REST
-      await voiceApi.toggleMute(newMuted);
-      set({ isMuted: newMuted });
+      if (isRecording) {
+        await voiceApi.stopRecording(serverId, currentVoiceChannelId);
+      } else {
+        await voiceApi.startRecording(serverId, currentVoiceChannelId);
+      }
     } catch (err) {
-      console.error('Błąd zmiany statusu mikrofonu:', err);
+      const message = err instanceof Error ? err.message : 'Błąd zmiany stanu nagrywania';
+      set({ error: message });
     }
   },
 
+  toggleHand: () => {
+    // Stan isHandRaised aktualizuje broadcast raise-hand
+    voiceService.raiseHand(!get().isHandRaised);
+  },
+
+  setSpeaker: (userId: number, speaker: boolean) => {
+    // Nowa rola przychodzi w stage-role, odmowa (brak uprawnień) jako błąd
+    voiceService.setSpeaker(userId, speaker);
+  },
+
   fetchVoiceParticipants: async (serverId: number, channelId: number) => {
     try {
       const participants = await voiceApi.getParticipants(serverId, channelId);
@@ -244,6 +361,7 @@ export const useChannelStore = create<ChannelState>((set, get) => ({
       set({
         currentVoiceChannelId: state.in_channel ? (state.channel_id ?? null) : null,
         isMuted: state.muted ?? false,
+        isDeafened: state.deafened ?? false,
       });
     } catch (err) {
       console.error('Błąd pobierania stanu voice:', err);
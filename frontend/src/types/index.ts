@@ -65,7 +65,17 @@ export interface Channel {
   id: number;
   server_id: number;
   name: string;
-  type: 'text' | 'voice';
+  type: 'text' | 'voice' | 'stage' | 'category';
+  parent_id: number | null;
+  position: number;
+  topic: string;
+  slowmode_seconds: number;
+  nsfw: boolean;
+  bitrate: number;
+  user_limit: number;
+  voice_mode: 'mesh' | 'sfu';
+  video_limit: number;
+  video_bitrate: number;
   created_at: string;
   updated_at: string;
 }
@@ -76,24 +86,123 @@ export interface Message {
   user_id: number;
   username: string;
   content: string;
+  attachments?: Attachment[];
   created_at: string;
 }
 
+export interface Attachment {
+  id: number;
+  filename: string;
+  url: string;
+  content_type: string;
+  size_bytes: number;
+}
+
+export interface MessageSearchResult extends Message {
+  channel_name: string;
+  has_attachment: boolean;
+}
+
+export interface MessageSearchResponse {
+  results: MessageSearchResult[];
+  total: number;
+}
+
 export interface VoiceParticipant {
   user_id: number;
   username: string;
   muted: boolean;
+  server_muted?: boolean;
+  server_deafened?: boolean;
+  deafened?: boolean;
+  speaking?: boolean;
+  video?: boolean;
+  screen_share?: boolean;
+  joined_at?: string;
+  tracks?: VoiceTrack[];
+  stage_role?: StageRole; // tylko kanały sceniczne
+  hand_raised?: boolean;
+}
+
+// Kanał sceniczny: mówią tylko zatwierdzeni mówcy, słuchacze mogą podnieść rękę
+export type StageRole = 'speaker' | 'audience';
+
+export type VoiceTrackSource = 'microphone' | 'camera' | 'screen' | 'screen-audio';
+
+export interface VoiceTrack {
+  track_id: string;
+  stream_id: string;
+  kind: 'audio' | 'video';
+  source: VoiceTrackSource;
+  muted: boolean;
+}
+
+export interface MediaLimits {
+  audio_bitrate: number;
+  video_bitrate: number;
+  video_limit: number;
+}
+
+export interface RecordingState {
+  recording: boolean;
+  recording_id?: number;
+  started_by?: number;
+  started_at?: string;
+}
+
+export interface VoiceRecordingTrack {
+  id: number;
+  user_id: number | null;
+  username: string;
+  size_bytes: number;
+  started_at: string;
+  ended_at: string;
+}
+
+export interface VoiceRecording {
+  id: number;
+  channel_id: number;
+  server_id: number;
+  started_by: number | null;
+  stopped_by: number | null;
+  status: 'recording' | 'completed' | 'interrupted';
+  started_at: string;
+  stopped_at: string | null;
+  tracks?: VoiceRecordingTrack[];
 }
 
-export interface VoiceState {
+export interface VoiceState extends Partial<VoiceParticipant> {
   in_channel: boolean;
   channel_id?: number;
-  muted?: boolean;
+}
+
+export interface ICEServersResponse {
+  ice_servers: RTCIceServer[];
+  expires_at?: string;
 }
 
 export interface CreateChannelRequest {
   name: string;
-  type: 'text' | 'voice';
+  type: 'text' | 'voice' | 'stage' | 'category';
+  parent_id?: number | null;
+}
+
+export interface UpdateChannelRequest {
+  name?: string;
+  topic?: string;
+  slowmode_seconds?: number;
+  nsfw?: boolean;
+  bitrate?: number;
+  user_limit?: number;
+  voice_mode?: 'mesh' | 'sfu';
+  video_limit?: number;
+  video_bitrate?: number;
+}
+
+export interface ChannelPosition {
+  id: number;
+  position: number;
+  parent_id: number | null;
 }
 
 export interface SendMessageRequest {